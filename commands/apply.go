@@ -0,0 +1,102 @@
+package commands
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "os"
+
+    "github.com/levelshatter/awsum/service"
+)
+
+type ApplyOptions struct {
+    Ctx context.Context
+    // File is the spec file of the services to apply, - reads it from stdin.
+    File string
+    // Plan only prints the changes that would be made.
+    Plan bool
+    Yes  bool
+    // WaitHealthy waits for the targets of each service to become healthy and probes its url.
+    WaitHealthy bool
+}
+
+// Apply makes every service in the spec file match its spec, one after another: each is planned and, once confirmed,
+// applied before the next is planned, so later services can rely on what earlier ones create (like a shared load
+// balancer).
+func Apply(opts ApplyOptions) error {
+    var (
+        data []byte
+        err  error
+    )
+
+    if opts.File == "-" {
+        data, err = io.ReadAll(os.Stdin)
+    } else {
+        data, err = os.ReadFile(opts.File)
+    }
+
+    if err != nil {
+        return err
+    }
+
+    specs, err := service.ParseServiceSpecs(opts.File, data)
+
+    if err != nil {
+        return err
+    }
+
+    // only planning changes nothing, so it doesn't keep others from changing services
+    if !opts.Plan {
+        unlock, err := lockState(opts.Ctx)
+
+        if err != nil {
+            return err
+        }
+
+        defer unlock()
+    }
+
+    for i, spec := range specs {
+        spec.Ctx = opts.Ctx
+
+        if i > 0 {
+            fmt.Println()
+        }
+
+        plan, err := service.DefaultAwsumILB.PlanILBService(spec)
+
+        if err != nil {
+            return fmt.Errorf("service '%s': %w", spec.ServiceName, err)
+        }
+
+        plan.Print(os.Stdout)
+
+        if opts.Plan {
+            continue
+        }
+
+        if plan.HasChanges() {
+            if err = confirmPlan(opts.Ctx, fmt.Sprintf("apply the changes to service '%s'?", spec.ServiceName), opts.Yes); err != nil {
+                return err
+            }
+        }
+
+        resources, err := plan.Apply(opts.Ctx)
+
+        if err != nil {
+            return fmt.Errorf("service '%s': %w", spec.ServiceName, err)
+        }
+
+        url, listener := serviceURL(plan.Options, resources)
+
+        if opts.WaitHealthy {
+            if err = waitForService(opts.Ctx, plan.Options, resources, url, listener, 0); err != nil {
+                return fmt.Errorf("service '%s': %w", spec.ServiceName, err)
+            }
+        }
+
+        fmt.Printf("service '%s' is at %s\n", spec.ServiceName, url)
+    }
+
+    return nil
+}
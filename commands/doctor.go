@@ -0,0 +1,76 @@
+package commands
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+
+    "github.com/levelshatter/awsum/internal/memory"
+    "github.com/levelshatter/awsum/service"
+)
+
+var (
+    ErrNoInstancesMatched = errors.New("no instances matched the given filters")
+)
+
+type InstanceDoctorOptions struct {
+    Ctx             context.Context
+    InstanceFilters service.InstanceFilters
+    // EgressIP overrides the looked up public ip address ssh connections are made from.
+    EgressIP string
+}
+
+func InstanceDoctor(opts InstanceDoctorOptions) error {
+    var egressIP net.IP
+
+    if len(opts.EgressIP) > 0 {
+        if egressIP = net.ParseIP(opts.EgressIP); egressIP == nil {
+            return fmt.Errorf("invalid egress ip '%s'", opts.EgressIP)
+        }
+    }
+
+    // stopped instances are included, since that is one of the things worth diagnosing
+    instances, err := service.DefaultEC2.GetAllInstances(opts.Ctx)
+
+    if err != nil {
+        return err
+    }
+
+    matches := opts.InstanceFilters.Matches(instances)
+
+    if len(matches) == 0 {
+        return ErrNoInstancesMatched
+    }
+
+    var failed bool
+
+    for n, instance := range matches {
+        if n > 0 {
+            fmt.Println()
+        }
+
+        fmt.Printf("'%s' (%s)\n", instance.GetName(), memory.Unwrap(instance.Info.InstanceId))
+
+        for _, diagnostic := range instance.DiagnoseSSH(service.DiagnoseSSHOptions{
+            Ctx:      opts.Ctx,
+            EgressIP: egressIP,
+        }) {
+            fmt.Printf("  [%s] %s: %s\n", diagnostic.Status, diagnostic.Check, diagnostic.Detail)
+
+            if len(diagnostic.Remediation) > 0 && diagnostic.Status != service.DiagnosticPass {
+                fmt.Printf("         -> %s\n", diagnostic.Remediation)
+            }
+
+            if diagnostic.Status == service.DiagnosticFail {
+                failed = true
+            }
+        }
+    }
+
+    if failed {
+        return service.ErrDiagnosticsFailed
+    }
+
+    return nil
+}
@@ -0,0 +1,235 @@
+package commands
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/levelshatter/awsum/internal/files"
+    "github.com/levelshatter/awsum/internal/memory"
+    "github.com/levelshatter/awsum/service"
+    "github.com/olekukonko/tablewriter"
+)
+
+const factsCacheFilename = "awsum-facts-cache.json"
+
+// loadFactsCache reads the cached facts of every instance (keyed by instance id) from the awsum data directory.
+func loadFactsCache() (map[string]*service.HostFacts, error) {
+    cache := make(map[string]*service.HostFacts)
+
+    dataDir, err := files.CreateAwsumDataDirectory()
+
+    if err != nil {
+        return nil, err
+    }
+
+    buf, err := files.ReadFileFull(path.Join(dataDir, factsCacheFilename))
+
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return cache, nil
+        }
+
+        return nil, err
+    }
+
+    if err = json.Unmarshal(buf, &cache); err != nil {
+        return nil, fmt.Errorf("failed to parse facts cache: %w", err)
+    }
+
+    return cache, nil
+}
+
+func saveFactsCache(cache map[string]*service.HostFacts) error {
+    dataDir, err := files.CreateAwsumDataDirectory()
+
+    if err != nil {
+        return err
+    }
+
+    buf, err := json.MarshalIndent(cache, "", "  ")
+
+    if err != nil {
+        return fmt.Errorf("failed to encode facts cache: %w", err)
+    }
+
+    return files.WriteToFile(path.Join(dataDir, factsCacheFilename), buf)
+}
+
+func formatBytes(n uint64) string {
+    const unit = 1024
+
+    if n < unit {
+        return fmt.Sprintf("%dB", n)
+    }
+
+    div, exp := uint64(unit), 0
+
+    for m := n / unit; m >= unit; m /= unit {
+        div *= unit
+        exp++
+    }
+
+    return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatUptime(seconds int64) string {
+    d := time.Duration(seconds) * time.Second
+
+    if days := int(d.Hours()) / 24; days > 0 {
+        return fmt.Sprintf("%dd%dh", days, int(d.Hours())%24)
+    }
+
+    return d.Truncate(time.Minute).String()
+}
+
+type InstanceFactsOptions struct {
+    Ctx             context.Context
+    InstanceFilters service.InstanceFilters
+    User            string
+    Format          string
+    // MaxAge is how old cached facts may be before they are gathered again.
+    MaxAge time.Duration
+    // Refresh ignores cached facts entirely.
+    Refresh bool
+}
+
+func InstanceFacts(opts InstanceFactsOptions) error {
+    instances, err := service.DefaultEC2.GetAllRunningInstances(opts.Ctx)
+
+    if err != nil {
+        return err
+    }
+
+    cache, err := loadFactsCache()
+
+    if err != nil {
+        fmt.Printf("failed to load facts cache, gathering facts from every instance: %s\n", err)
+        cache = make(map[string]*service.HostFacts)
+    }
+
+    var (
+        wg     sync.WaitGroup
+        mu     sync.Mutex
+        errs   []error
+        facts  []*service.HostFacts
+        gained bool
+    )
+
+    for _, instance := range opts.InstanceFilters.Matches(instances) {
+        id := memory.Unwrap(instance.Info.InstanceId)
+
+        if cached, ok := cache[id]; ok && !opts.Refresh && time.Since(cached.GatheredAt) <= opts.MaxAge {
+            facts = append(facts, cached)
+            continue
+        }
+
+        wg.Go(func() {
+            hostFacts, err := instance.GatherFacts(opts.Ctx, opts.User)
+
+            mu.Lock()
+            defer mu.Unlock()
+
+            if err != nil {
+                errs = append(errs, fmt.Errorf("'%s': %w", instance.GetName(), err))
+                return
+            }
+
+            facts = append(facts, hostFacts)
+            cache[id] = hostFacts
+            gained = true
+        })
+    }
+
+    wg.Wait()
+
+    if gained {
+        if err = saveFactsCache(cache); err != nil {
+            fmt.Printf("failed to save facts cache: %s\n", err)
+        }
+    }
+
+    slices.SortFunc(facts, func(a, b *service.HostFacts) int {
+        return strings.Compare(a.Name, b.Name)
+    })
+
+    if opts.Format == "json" {
+        buf, err := json.MarshalIndent(facts, "", "  ")
+
+        if err != nil {
+            return fmt.Errorf("failed to encode facts: %w", err)
+        }
+
+        fmt.Println(string(buf))
+    } else if opts.Format == "pretty" {
+        table := tablewriter.NewWriter(os.Stdout)
+
+        table.Header([]string{
+            "Name",
+            "ID",
+            "OS",
+            "Kernel",
+            "Uptime",
+            "CPU",
+            "Memory",
+            "Disk (/)",
+            "Listening",
+            "Containers",
+            "Packages",
+            "Age",
+        })
+
+        for _, f := range facts {
+            var (
+                rootDisk  = "-"
+                listening []string
+            )
+
+            for _, disk := range f.Disks {
+                if disk.MountPoint == "/" {
+                    rootDisk = fmt.Sprintf("%s / %s", formatBytes(disk.UsedBytes), formatBytes(disk.SizeBytes))
+                }
+            }
+
+            for _, port := range f.ListeningPorts {
+                if entry := fmt.Sprintf("%s/%d", port.Protocol, port.Port); !slices.Contains(listening, entry) {
+                    listening = append(listening, entry)
+                }
+            }
+
+            if err = table.Append([]string{
+                f.Name,
+                f.InstanceId,
+                f.OS.PrettyName,
+                f.Kernel,
+                formatUptime(f.UptimeSeconds),
+                fmt.Sprintf("%d (load %.2f)", f.CPU.Count, f.CPU.LoadAverage[0]),
+                fmt.Sprintf(
+                    "%s / %s",
+                    formatBytes(f.Memory.TotalBytes-min(f.Memory.AvailableBytes, f.Memory.TotalBytes)),
+                    formatBytes(f.Memory.TotalBytes),
+                ),
+                rootDisk,
+                strings.Join(listening, ", "),
+                fmt.Sprintf("%d", len(f.Containers)),
+                fmt.Sprintf("%d (%s)", f.Packages.Count, f.Packages.Manager),
+                time.Since(f.GatheredAt).Truncate(time.Second).String(),
+            }); err != nil {
+                return fmt.Errorf("failed to build instance facts table: %w", err)
+            }
+        }
+
+        if err = table.Render(); err != nil {
+            return err
+        }
+    }
+
+    return errors.Join(errs...)
+}
@@ -5,13 +5,18 @@ import (
     "encoding/csv"
     "errors"
     "fmt"
+    "net/http"
     "os"
+    "strings"
     "sync"
+    "time"
 
     "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/internal/console"
     "github.com/levelshatter/awsum/internal/memory"
     "github.com/levelshatter/awsum/service"
     "github.com/olekukonko/tablewriter"
+    "golang.org/x/term"
 )
 
 func InstanceList(ctx context.Context, format string) error {
@@ -83,6 +88,104 @@ type InstanceShellOptions struct {
     Command         string
     Quiet           bool
     Parallel        bool
+    Timeout         time.Duration
+    KeepAlive       service.KeepAliveOptions
+    All             bool
+    First           bool
+    Broadcast       bool
+    Record          bool
+}
+
+// selectShellInstances narrows down the instances to attach a shell to when several match the filters: all of them
+// (--all), the first one (--first), or the one picked interactively by the user. Without a terminal to pick on, it is
+// all of them, one after another, as it was before the picker.
+func selectShellInstances(opts InstanceShellOptions, instances []*service.Instance) ([]*service.Instance, error) {
+    if len(instances) <= 1 || opts.All {
+        return instances, nil
+    }
+
+    if opts.First {
+        return instances[:1], nil
+    }
+
+    if !term.IsTerminal(int(os.Stdin.Fd())) {
+        return instances, nil
+    }
+
+    var (
+        rows   = make([][]string, len(instances))
+        widths = make([]int, 4)
+    )
+
+    for i, instance := range instances {
+        rows[i] = []string{
+            instance.GetName(),
+            memory.Unwrap(instance.Info.InstanceId),
+            instance.GetFormattedBestIpAddress(),
+            instance.GetFormattedType(),
+        }
+
+        for col, value := range rows[i] {
+            widths[col] = max(widths[col], len(value))
+        }
+    }
+
+    options := make([]string, len(rows))
+
+    for i, row := range rows {
+        options[i] = fmt.Sprintf("%-*s  %-*s  %-*s  %s", widths[0], row[0], widths[1], row[1], widths[2], row[2], row[3])
+    }
+
+    picked, err := console.Pick(opts.Ctx, "select an instance", options)
+
+    if err != nil {
+        return nil, err
+    }
+
+    return instances[picked : picked+1], nil
+}
+
+// attachInstanceShell attaches a shell to the given instance, offering to reconnect (when possible) if the connection
+// drops.
+func attachInstanceShell(opts InstanceShellOptions, instance *service.Instance) error {
+    for {
+        err := instance.AttachShell(service.AttachShellOptions{
+            Ctx:       opts.Ctx,
+            User:      opts.User,
+            KeepAlive: opts.KeepAlive,
+            Record:    opts.Record,
+        })
+
+        if !errors.Is(err, service.ErrConnectionLost) || !term.IsTerminal(int(os.Stdin.Fd())) {
+            return err
+        }
+
+        fmt.Printf("\r\n%s\n", err)
+
+        reconnect, promptErr := console.Confirm(
+            opts.Ctx,
+            fmt.Sprintf("connection to '%s' lost, reconnect?", instance.GetName()),
+            true,
+        )
+
+        if promptErr != nil || !reconnect {
+            return err
+        }
+    }
+}
+
+// runInstanceCommand runs the command on the given instance, bounded by the command timeout (if any).
+func runInstanceCommand(opts InstanceShellOptions, instance *service.Instance) error {
+    ctx := opts.Ctx
+
+    if opts.Timeout > 0 {
+        var cancel context.CancelFunc
+
+        ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+        defer cancel()
+    }
+
+    return instance.RunInteractiveCommand(ctx, opts.User, opts.Command, opts.Quiet)
 }
 
 func InstanceShell(opts InstanceShellOptions) error {
@@ -93,14 +196,48 @@ func InstanceShell(opts InstanceShellOptions) error {
     }
 
     var (
-        wg   sync.WaitGroup
-        errs []error
-        mu   sync.Mutex
+        wg          sync.WaitGroup
+        errs        []error
+        interrupted []string
+        mu          sync.Mutex
     )
 
-    for _, instance := range opts.InstanceFilters.Matches(instances) {
+    addErr := func(instance *service.Instance, err error) {
+        mu.Lock()
+        defer mu.Unlock()
+
+        if errors.Is(err, service.ErrSessionInterrupted) {
+            interrupted = append(interrupted, instance.GetName())
+        }
+
+        errs = append(errs, fmt.Errorf("'%s': %w", instance.GetName(), err))
+    }
+
+    matches := opts.InstanceFilters.Matches(instances)
+
+    if opts.Broadcast {
+        if len(matches) == 0 {
+            return nil
+        }
+
+        return service.BroadcastShell(service.BroadcastShellOptions{
+            Ctx:       opts.Ctx,
+            User:      opts.User,
+            KeepAlive: opts.KeepAlive,
+            Instances: matches,
+            Record:    opts.Record,
+        })
+    }
+
+    if len(opts.Command) == 0 {
+        if matches, err = selectShellInstances(opts, matches); err != nil {
+            return err
+        }
+    }
+
+    for _, instance := range matches {
         if len(opts.Command) == 0 {
-            if err = instance.AttachShell(opts.User); err != nil {
+            if err = attachInstanceShell(opts, instance); err != nil {
                 return err
             }
 
@@ -112,8 +249,9 @@ func InstanceShell(opts InstanceShellOptions) error {
                 fmt.Printf("--- '%s' SHELL START ---\n", instance.GetName())
             }
 
-            if err = instance.RunInteractiveCommand(opts.User, opts.Command, opts.Quiet); err != nil {
-                return err
+            if err = runInstanceCommand(opts, instance); err != nil {
+                addErr(instance, err)
+                break
             }
 
             if !opts.Quiet {
@@ -121,10 +259,8 @@ func InstanceShell(opts InstanceShellOptions) error {
             }
         } else {
             wg.Go(func() {
-                if err = instance.RunInteractiveCommand(opts.User, opts.Command, opts.Quiet); err != nil {
-                    mu.Lock()
-                    errs = append(errs, err)
-                    mu.Unlock()
+                if err := runInstanceCommand(opts, instance); err != nil {
+                    addErr(instance, err)
                 }
             })
         }
@@ -132,59 +268,295 @@ func InstanceShell(opts InstanceShellOptions) error {
 
     wg.Wait()
 
+    if len(interrupted) > 0 {
+        fmt.Printf("command interrupted on %d instance(s): %s\n", len(interrupted), strings.Join(interrupted, ", "))
+    }
+
     return errors.Join(errs...)
 }
 
+// ServiceProbeTimeout is how long a load-balanced service is given to answer requests once its targets are healthy.
+const ServiceProbeTimeout = time.Minute * 2
+
+var ErrServiceProbeFailed = errors.New("failed to probe service")
+
 type InstanceLoadBalanceOptions struct {
-    Ctx                          context.Context
-    ServiceName                  string
-    InstanceFilters              service.InstanceFilters
-    LoadBalancerListenerProtocol types.ProtocolEnum
-    LoadBalancerIpProtocol       string
-    LoadBalancerPort             int32
-    TrafficPort                  int32
-    TrafficProtocol              types.ProtocolEnum
-    CertificateNames             []string
-    DomainNames                  []string
-    Private                      bool
+    Ctx                    context.Context
+    ServiceName            string
+    InstanceFilters        service.InstanceFilters
+    Listeners              []service.ListenerOptions
+    LoadBalancerIpProtocol string
+    TrafficPort            int32
+    TrafficProtocol        types.ProtocolEnum
+    CertificateNames       []string
+    DomainNames            []string
+    Private                bool
+    HealthCheck            service.HealthCheckOptions
+    Canary                 int32
+    LoadBalancerType       types.LoadBalancerTypeEnum
+    AlpnPolicy             string
+    SharedLoadBalancer     string
+    Hosts                  []string
+    Paths                  []string
+    Priority               int32
+    AllowCIDRs             []string
+    IPv6                   bool
+    IpAddressType          types.IpAddressType
+    TargetGroupAttributes  service.TargetGroupAttributes
+    LoadBalancerAttributes service.LoadBalancerAttributes
+    Tags                   map[string]string
+    // WaitHealthy waits for the targets to become healthy (all of them, or MinHealthy) and probes the service url.
+    WaitHealthy bool
+    MinHealthy  int
+    // Plan only prints the changes that would be made.
+    Plan bool
+    // Apply prints the changes and asks for confirmation before making them, unless Yes is set.
+    Apply bool
+    Yes   bool
+}
+
+// waitForHealthyTargets waits for the target group's targets to become healthy, showing their progress.
+func waitForHealthyTargets(ctx context.Context, targetGroupArn string, minHealthy int) error {
+    var (
+        interactive = term.IsTerminal(int(os.Stdout.Fd()))
+        lastStatus  string
+    )
+
+    err := service.DefaultAwsumILB.ELBv2.WaitForHealthyTargets(service.WaitForHealthyTargetsOptions{
+        Ctx:            ctx,
+        TargetGroupArn: targetGroupArn,
+        MinHealthy:     minHealthy,
+        Timeout:        service.TargetHealthyTimeout,
+        OnPoll: func(health []types.TargetHealthDescription) {
+            var (
+                states   = make(map[types.TargetHealthStateEnum]int)
+                required = minHealthy
+                summary  []string
+            )
+
+            if required == 0 {
+                required = len(health)
+            }
+
+            for _, description := range health {
+                if description.TargetHealth != nil {
+                    states[description.TargetHealth.State]++
+                }
+            }
+
+            for _, state := range types.TargetHealthStateEnum("").Values() {
+                if count := states[state]; count > 0 {
+                    summary = append(summary, fmt.Sprintf("%d %s", count, state))
+                }
+            }
+
+            status := fmt.Sprintf(
+                "waiting for healthy targets: %d/%d healthy (%s)",
+                service.CountHealthyTargets(health),
+                required,
+                strings.Join(summary, ", "),
+            )
+
+            if interactive {
+                fmt.Printf("\r\033[K%s", status)
+            } else if status != lastStatus {
+                fmt.Println(status)
+            }
+
+            lastStatus = status
+        },
+    })
+
+    if interactive && len(lastStatus) > 0 {
+        fmt.Println()
+    }
+
+    return err
+}
+
+// probeServiceURL makes a request to the service until it answers without a server error, giving dns records and the
+// load balancer ServiceProbeTimeout to catch up.
+func probeServiceURL(ctx context.Context, url string) error {
+    var (
+        deadline = time.Now().Add(ServiceProbeTimeout)
+        client   = &http.Client{Timeout: time.Second * 10}
+    )
+
+    for {
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+        if err != nil {
+            return err
+        }
+
+        res, err := client.Do(req)
+
+        if err == nil {
+            _ = res.Body.Close()
+
+            if res.StatusCode < http.StatusInternalServerError {
+                fmt.Printf("probed %s: %s\n", url, res.Status)
+                return nil
+            }
+
+            err = fmt.Errorf("unexpected status %s", res.Status)
+        }
+
+        if time.Now().After(deadline) {
+            return fmt.Errorf("%w '%s': %w", ErrServiceProbeFailed, url, err)
+        }
+
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(time.Second * 5):
+        }
+    }
 }
 
 func InstanceLoadBalance(opts InstanceLoadBalanceOptions) error {
-    resources, err := service.DefaultAwsumILB.SetupNewILBService(service.SetupNewILBServiceOptions{
-        Ctx:                          opts.Ctx,
-        ServiceName:                  opts.ServiceName,
-        TargetInstanceFilters:        opts.InstanceFilters,
-        LoadBalancerListenerProtocol: opts.LoadBalancerListenerProtocol,
-        LoadBalancerIpProtocol:       opts.LoadBalancerIpProtocol,
-        LoadBalancerPort:             opts.LoadBalancerPort,
-        TrafficPort:                  opts.TrafficPort,
-        TrafficProtocol:              opts.TrafficProtocol,
-        CertificateNames:             opts.CertificateNames,
-        DomainNames:                  opts.DomainNames,
-        Private:                      opts.Private,
+    // only planning changes nothing, so it doesn't keep others from changing services
+    if !opts.Plan {
+        unlock, err := lockState(opts.Ctx)
+
+        if err != nil {
+            return err
+        }
+
+        defer unlock()
+    }
+
+    plan, err := service.DefaultAwsumILB.PlanILBService(service.SetupNewILBServiceOptions{
+        Ctx:                    opts.Ctx,
+        ServiceName:            opts.ServiceName,
+        TargetInstanceFilters:  opts.InstanceFilters,
+        Listeners:              opts.Listeners,
+        LoadBalancerIpProtocol: opts.LoadBalancerIpProtocol,
+        TrafficPort:            opts.TrafficPort,
+        TrafficProtocol:        opts.TrafficProtocol,
+        CertificateNames:       opts.CertificateNames,
+        DomainNames:            opts.DomainNames,
+        Private:                opts.Private,
+        HealthCheck:            opts.HealthCheck,
+        Canary:                 opts.Canary,
+        LoadBalancerType:       opts.LoadBalancerType,
+        AlpnPolicy:             opts.AlpnPolicy,
+        SharedLoadBalancer:     opts.SharedLoadBalancer,
+        Hosts:                  opts.Hosts,
+        Paths:                  opts.Paths,
+        Priority:               opts.Priority,
+        AllowCIDRs:             opts.AllowCIDRs,
+        IPv6:                   opts.IPv6,
+        IpAddressType:          opts.IpAddressType,
+        TargetGroupAttributes:  opts.TargetGroupAttributes,
+        LoadBalancerAttributes: opts.LoadBalancerAttributes,
+        Tags:                   opts.Tags,
     })
 
     if err != nil {
         return err
     }
 
+    if opts.Plan || opts.Apply {
+        plan.Print(os.Stdout)
+    }
+
+    if opts.Plan {
+        return nil
+    }
+
+    if opts.Apply && plan.HasChanges() {
+        if err = confirmPlan(opts.Ctx, "apply these changes?", opts.Yes); err != nil {
+            return err
+        }
+    }
+
+    resources, err := plan.Apply(opts.Ctx)
+
+    if err != nil {
+        return err
+    }
+
+    output, listener := serviceURL(plan.Options, resources)
+
+    if opts.WaitHealthy {
+        if err = waitForService(opts.Ctx, plan.Options, resources, output, listener, opts.MinHealthy); err != nil {
+            return err
+        }
+    }
+
+    fmt.Println(output)
+
+    return nil
+}
+
+// serviceURL returns the url of a load-balanced service, along with the listener it is for: the first one that isn't a
+// redirect.
+func serviceURL(opts service.SetupNewILBServiceOptions, resources *service.ILBServiceResources) (string, service.ListenerOptions) {
     output := resources.LoadBalancerDNSName
 
     if len(opts.DomainNames) > 0 {
         output = opts.DomainNames[0]
+    } else if len(opts.Hosts) > 0 {
+        // a shared load balancer only routes requests for the service's hosts to it
+        output = opts.Hosts[0]
     }
 
-    switch opts.LoadBalancerListenerProtocol {
+    listener := opts.Listeners[0]
+
+    for _, l := range opts.Listeners {
+        if l.RedirectPort == 0 {
+            listener = l
+            break
+        }
+    }
+
+    switch listener.Protocol {
     case types.ProtocolEnumTcp:
-        fmt.Printf("tcp://%s\n", output)
+        output = fmt.Sprintf("tcp://%s", output)
     case types.ProtocolEnumUdp:
-        fmt.Printf("udp://%s\n", output)
+        output = fmt.Sprintf("udp://%s", output)
+    case types.ProtocolEnumTls:
+        output = fmt.Sprintf("tls://%s:%d", output, listener.Port)
     case types.ProtocolEnumHttp:
-        fmt.Printf("http://%s\n", output)
+        output = fmt.Sprintf("http://%s", output)
+
+        if listener.Port != 80 {
+            output = fmt.Sprintf("%s:%d", output, listener.Port)
+        }
     case types.ProtocolEnumHttps:
-        fmt.Printf("https://%s\n", output)
-    default:
-        fmt.Println(output)
+        output = fmt.Sprintf("https://%s", output)
+
+        if listener.Port != 443 {
+            output = fmt.Sprintf("%s:%d", output, listener.Port)
+        }
+    }
+
+    return output, listener
+}
+
+// waitForService waits for the service's targets to become healthy (all of them, or minHealthy) and probes its url.
+func waitForService(
+    ctx context.Context,
+    opts service.SetupNewILBServiceOptions,
+    resources *service.ILBServiceResources,
+    url string,
+    listener service.ListenerOptions,
+    minHealthy int,
+) error {
+    targetGroupArn := resources.TargetGroupArn
+
+    // the service's own targets are left alone while it has a canary, the new instances are in the canary
+    if opts.Canary > 0 {
+        targetGroupArn = resources.CanaryTargetGroupArn
+    }
+
+    if err := waitForHealthyTargets(ctx, targetGroupArn, minHealthy); err != nil {
+        return err
+    }
+
+    if listener.Protocol == types.ProtocolEnumHttp || listener.Protocol == types.ProtocolEnumHttps {
+        return probeServiceURL(ctx, url)
     }
 
     return nil
@@ -0,0 +1,91 @@
+package commands
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "path"
+    "time"
+
+    "github.com/levelshatter/awsum/internal/asciicast"
+    "github.com/levelshatter/awsum/internal/files"
+    "golang.org/x/term"
+)
+
+type ReplayOptions struct {
+    Ctx      context.Context
+    Filename string
+    Speed    float64
+    MaxIdle  time.Duration
+}
+
+// resolveRecordingFilename returns the path of the recording, looking in the awsum data directory if the file doesn't
+// exist as given.
+func resolveRecordingFilename(filename string) (string, error) {
+    if _, err := os.Stat(filename); err == nil || path.IsAbs(filename) {
+        return filename, nil
+    }
+
+    dataDir, err := files.CreateAwsumDataDirectory()
+
+    if err != nil {
+        return "", err
+    }
+
+    if _, err = os.Stat(path.Join(dataDir, filename)); err == nil {
+        return path.Join(dataDir, filename), nil
+    }
+
+    return filename, nil
+}
+
+func Replay(opts ReplayOptions) error {
+    filename, err := resolveRecordingFilename(opts.Filename)
+
+    if err != nil {
+        return err
+    }
+
+    f, err := os.Open(filename)
+
+    if err != nil {
+        return fmt.Errorf("failed to open recording '%s': %w", filename, err)
+    }
+
+    defer func() {
+        if err = f.Close(); err != nil {
+            fmt.Printf("failed to properly close recording '%s': %s\n", filename, err)
+        }
+    }()
+
+    reader, err := asciicast.NewReader(f)
+
+    if err != nil {
+        return fmt.Errorf("failed to read recording '%s': %w", filename, err)
+    }
+
+    if width, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+        if width < reader.Header.Width || height < reader.Header.Height {
+            fmt.Printf(
+                "note: the recording is %dx%d but your terminal is %dx%d, output may not render correctly\n",
+                reader.Header.Width,
+                reader.Header.Height,
+                width,
+                height,
+            )
+        }
+    }
+
+    err = reader.Play(opts.Ctx, os.Stdout, asciicast.PlayOptions{
+        Speed:   opts.Speed,
+        MaxIdle: opts.MaxIdle,
+    })
+
+    // stopping playback early isn't a failure
+    if errors.Is(err, context.Canceled) {
+        return nil
+    }
+
+    return err
+}
@@ -0,0 +1,392 @@
+package commands
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "maps"
+    "os"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/levelshatter/awsum/internal/console"
+    "github.com/levelshatter/awsum/service"
+    "github.com/olekukonko/tablewriter"
+    "golang.org/x/term"
+)
+
+var (
+    ErrApplyCancelled    = errors.New("apply cancelled")
+    ErrApplyNotConfirmed = errors.New("refusing to apply without confirmation when stdin is not a terminal, pass --yes")
+)
+
+// confirmPlan asks the user whether the printed plan should be applied, unless yes is set.
+func confirmPlan(ctx context.Context, prompt string, yes bool) error {
+    if yes {
+        return nil
+    }
+
+    if !term.IsTerminal(int(os.Stdin.Fd())) {
+        return ErrApplyNotConfirmed
+    }
+
+    fmt.Println()
+
+    confirmed, err := console.Confirm(ctx, prompt, false)
+
+    if err != nil {
+        return err
+    }
+
+    if !confirmed {
+        return ErrApplyCancelled
+    }
+
+    return nil
+}
+
+type ServiceDeleteOptions struct {
+    Ctx         context.Context
+    ServiceName string
+    KeepDNS     bool
+    Yes         bool
+}
+
+func ServiceDelete(opts ServiceDeleteOptions) error {
+    unlock, err := lockState(opts.Ctx)
+
+    if err != nil {
+        return err
+    }
+
+    defer unlock()
+
+    plan, err := service.DefaultAwsumILB.PlanILBServiceDeletion(service.DeleteILBServiceOptions{
+        Ctx:         opts.Ctx,
+        ServiceName: opts.ServiceName,
+        KeepDNS:     opts.KeepDNS,
+    })
+
+    if err != nil {
+        return err
+    }
+
+    plan.Print(os.Stdout)
+
+    if err = confirmPlan(opts.Ctx, fmt.Sprintf("delete service '%s'?", opts.ServiceName), opts.Yes); err != nil {
+        return err
+    }
+
+    if _, err = plan.Apply(opts.Ctx); err != nil {
+        return err
+    }
+
+    fmt.Printf("service '%s' deleted\n", opts.ServiceName)
+
+    return nil
+}
+
+type ServiceShiftCanaryOptions struct {
+    Ctx         context.Context
+    ServiceName string
+    Step        int32
+    Interval    time.Duration
+}
+
+func printCanaryStep(canaryWeight int32) {
+    fmt.Printf("%d%% of requests going to the canary\n", canaryWeight)
+}
+
+// ServicePromote gradually shifts every request of the service to its canary, which then replaces the service's
+// previous instances.
+func ServicePromote(opts ServiceShiftCanaryOptions) error {
+    unlock, err := lockState(opts.Ctx)
+
+    if err != nil {
+        return err
+    }
+
+    defer unlock()
+
+    if err = service.DefaultAwsumILB.PromoteCanary(service.ShiftCanaryOptions{
+        Ctx:         opts.Ctx,
+        ServiceName: opts.ServiceName,
+        Step:        opts.Step,
+        Interval:    opts.Interval,
+        OnStep:      printCanaryStep,
+    }); err != nil {
+        return err
+    }
+
+    fmt.Printf("canary of service '%s' promoted\n", opts.ServiceName)
+
+    return nil
+}
+
+// ServiceRollback gradually shifts every request of the service back from its canary, which is then removed.
+func ServiceRollback(opts ServiceShiftCanaryOptions) error {
+    unlock, err := lockState(opts.Ctx)
+
+    if err != nil {
+        return err
+    }
+
+    defer unlock()
+
+    if err = service.DefaultAwsumILB.RollbackCanary(service.ShiftCanaryOptions{
+        Ctx:         opts.Ctx,
+        ServiceName: opts.ServiceName,
+        Step:        opts.Step,
+        Interval:    opts.Interval,
+        OnStep:      printCanaryStep,
+    }); err != nil {
+        return err
+    }
+
+    fmt.Printf("canary of service '%s' rolled back\n", opts.ServiceName)
+
+    return nil
+}
+
+type ServiceDriftOptions struct {
+    Ctx         context.Context
+    ServiceName string
+    // All checks every service in the state with applied inputs, instead of ServiceName.
+    All bool
+}
+
+// ServiceDrift prints the changes made to services outside of awsum since they were last applied. Services that have
+// drifted make it fail with service.ErrDriftDetected, while failing to check one fails it with that error instead.
+func ServiceDrift(opts ServiceDriftOptions) error {
+    serviceNames := []string{opts.ServiceName}
+
+    if opts.All {
+        state, err := service.DefaultAwsumILB.LoadState(opts.Ctx)
+
+        if err != nil {
+            return err
+        }
+
+        serviceNames = nil
+
+        for _, serviceName := range slices.Sorted(maps.Keys(state.Services)) {
+            if state.Services[serviceName].Inputs == nil {
+                fmt.Printf("skipping service '%s', it has no applied inputs\n", serviceName)
+                continue
+            }
+
+            serviceNames = append(serviceNames, serviceName)
+        }
+    }
+
+    var (
+        drifted []string
+        errs    []error
+    )
+
+    for i, serviceName := range serviceNames {
+        if i > 0 {
+            fmt.Println()
+        }
+
+        plan, err := service.DefaultAwsumILB.PlanILBServiceDrift(opts.Ctx, serviceName)
+
+        if err != nil {
+            if !opts.All {
+                return err
+            }
+
+            fmt.Printf("failed to check service '%s' for drift: %s\n", serviceName, err)
+            errs = append(errs, fmt.Errorf("service '%s': %w", serviceName, err))
+
+            continue
+        }
+
+        plan.PrintDrift(os.Stdout)
+
+        if plan.HasDrift() {
+            drifted = append(drifted, serviceName)
+        }
+    }
+
+    if len(errs) > 0 {
+        return errors.Join(errs...)
+    }
+
+    if len(drifted) > 0 {
+        return fmt.Errorf("%w in service(s) %s", service.ErrDriftDetected, strings.Join(drifted, ", "))
+    }
+
+    return nil
+}
+
+func formatListeners(listeners []service.ILBServiceListener) string {
+    var formatted []string
+
+    for _, listener := range listeners {
+        entry := fmt.Sprintf("%s/%d", strings.ToLower(listener.Protocol), listener.Port)
+
+        if len(listener.Routes) > 0 {
+            entry = fmt.Sprintf("%s (%s)", entry, strings.Join(listener.Routes, ", "))
+        }
+
+        formatted = append(formatted, entry)
+    }
+
+    return strings.Join(formatted, ", ")
+}
+
+func ServiceList(ctx context.Context, format string) error {
+    descriptions, err := service.DefaultAwsumILB.ListILBServices(ctx)
+
+    if err != nil {
+        return err
+    }
+
+    if format == "json" {
+        buf, err := json.MarshalIndent(descriptions, "", "  ")
+
+        if err != nil {
+            return fmt.Errorf("failed to encode services: %w", err)
+        }
+
+        fmt.Println(string(buf))
+    } else if format == "pretty" {
+        table := tablewriter.NewWriter(os.Stdout)
+
+        table.Header([]string{
+            "Name",
+            "DNS Name",
+            "Scheme",
+            "Listeners",
+            "Targets",
+            "Domains",
+            "Certificates",
+        })
+
+        for _, description := range descriptions {
+            healthy, unhealthy := description.TargetCounts()
+            targets := fmt.Sprintf("%d healthy, %d unhealthy", healthy, unhealthy)
+
+            if len(description.CanaryTargetGroupArn) > 0 {
+                targets = fmt.Sprintf("%s (canary %d%%)", targets, description.CanaryWeight)
+            }
+
+            if err = table.Append([]string{
+                description.Name,
+                description.LoadBalancerDNSName,
+                description.Scheme,
+                formatListeners(description.Listeners),
+                targets,
+                strings.Join(description.Domains, ", "),
+                strings.Join(description.Certificates(), ", "),
+            }); err != nil {
+                return fmt.Errorf("failed to build service list table: %w", err)
+            }
+        }
+
+        return table.Render()
+    }
+
+    return nil
+}
+
+func ServiceDescribe(ctx context.Context, serviceName string, format string) error {
+    description, err := service.DefaultAwsumILB.DescribeILBService(ctx, serviceName)
+
+    if err != nil {
+        return err
+    }
+
+    if format == "json" {
+        buf, err := json.MarshalIndent(description, "", "  ")
+
+        if err != nil {
+            return fmt.Errorf("failed to encode service: %w", err)
+        }
+
+        fmt.Println(string(buf))
+
+        return nil
+    }
+
+    fmt.Printf("Name:            %s\n", description.Name)
+    fmt.Printf("DNS Name:        %s\n", description.LoadBalancerDNSName)
+    fmt.Printf("Scheme:          %s\n", description.Scheme)
+    fmt.Printf("IP Addresses:    %s\n", description.IpAddressType)
+
+    if len(description.SharedLoadBalancer) > 0 {
+        fmt.Printf("Shared:          %s\n", description.SharedLoadBalancer)
+    }
+
+    fmt.Printf("State:           %s\n", description.State)
+    fmt.Printf("Load Balancer:   %s\n", description.LoadBalancerArn)
+    fmt.Printf("Target Group:    %s\n", description.TargetGroupArn)
+
+    if len(description.CanaryTargetGroupArn) > 0 {
+        fmt.Printf("Canary:          %s (%d%% of requests)\n", description.CanaryTargetGroupArn, description.CanaryWeight)
+    }
+
+    fmt.Printf("Security Groups: %s\n", strings.Join(description.SecurityGroupIds, ", "))
+    fmt.Printf("Domains:         %s\n", strings.Join(description.Domains, ", "))
+
+    fmt.Println()
+    fmt.Println("Listeners:")
+
+    listenerTable := tablewriter.NewWriter(os.Stdout)
+
+    listenerTable.Header([]string{
+        "Port",
+        "Protocol",
+        "Certificates",
+        "Routes",
+    })
+
+    for _, listener := range description.Listeners {
+        if err = listenerTable.Append([]string{
+            fmt.Sprintf("%d", listener.Port),
+            listener.Protocol,
+            strings.Join(listener.Certificates, ", "),
+            strings.Join(listener.Routes, ", "),
+        }); err != nil {
+            return fmt.Errorf("failed to build listener table: %w", err)
+        }
+    }
+
+    if err = listenerTable.Render(); err != nil {
+        return err
+    }
+
+    fmt.Println()
+    fmt.Println("Targets:")
+
+    targetTable := tablewriter.NewWriter(os.Stdout)
+
+    targetTable.Header([]string{
+        "ID",
+        "Name",
+        "Port",
+        "State",
+        "Reason",
+        "Description",
+        "Canary",
+    })
+
+    for _, target := range description.Targets {
+        if err = targetTable.Append([]string{
+            target.InstanceId,
+            target.InstanceName,
+            fmt.Sprintf("%d", target.Port),
+            target.State,
+            target.Reason,
+            target.Description,
+            fmt.Sprintf("%t", target.Canary),
+        }); err != nil {
+            return fmt.Errorf("failed to build target table: %w", err)
+        }
+    }
+
+    return targetTable.Render()
+}
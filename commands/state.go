@@ -0,0 +1,177 @@
+package commands
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "maps"
+    "os"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/levelshatter/awsum/service"
+    "github.com/olekukonko/tablewriter"
+)
+
+// lockState holds the lock of the state for the rest of a command that plans and changes services, so no other awsum
+// can do the same in between. The returned function releases it.
+func lockState(ctx context.Context) (func(), error) {
+    unlock, err := service.DefaultAwsumILB.LockState(ctx)
+
+    if err != nil {
+        return nil, err
+    }
+
+    return func() {
+        if err := unlock(); err != nil {
+            fmt.Println(err)
+        }
+    }, nil
+}
+
+// formatStateListeners returns the recorded listener arns, by port.
+func formatStateListeners(recorded *service.ServiceState) string {
+    var formatted []string
+
+    for _, port := range slices.Sorted(maps.Keys(recorded.ListenerArns)) {
+        formatted = append(formatted, fmt.Sprintf("%d", port))
+    }
+
+    return strings.Join(formatted, ", ")
+}
+
+func StateList(ctx context.Context, format string) error {
+    state, err := service.DefaultAwsumILB.LoadState(ctx)
+
+    if err != nil {
+        return err
+    }
+
+    if format == "json" {
+        buf, err := json.MarshalIndent(state.Services, "", "  ")
+
+        if err != nil {
+            return fmt.Errorf("failed to encode state: %w", err)
+        }
+
+        fmt.Println(string(buf))
+
+        return nil
+    }
+
+    table := tablewriter.NewWriter(os.Stdout)
+
+    table.Header([]string{
+        "Name",
+        "Shared LB",
+        "Listeners",
+        "Canary",
+        "Updated",
+    })
+
+    for _, serviceName := range slices.Sorted(maps.Keys(state.Services)) {
+        var (
+            recorded = state.Services[serviceName]
+            canary   = "no"
+        )
+
+        if len(recorded.CanaryTargetGroupArn) > 0 {
+            canary = "yes"
+        }
+
+        if err = table.Append([]string{
+            serviceName,
+            recorded.SharedLoadBalancer,
+            formatStateListeners(recorded),
+            canary,
+            recorded.UpdatedAt.Local().Format(time.DateTime),
+        }); err != nil {
+            return fmt.Errorf("failed to build state list table: %w", err)
+        }
+    }
+
+    return table.Render()
+}
+
+// printServiceState prints every resource the state records for the service.
+func printServiceState(serviceName string, recorded *service.ServiceState) {
+    fmt.Printf("Name:                    %s\n", serviceName)
+
+    if len(recorded.SharedLoadBalancer) > 0 {
+        fmt.Printf("Shared:                  %s\n", recorded.SharedLoadBalancer)
+    }
+
+    fmt.Printf("Load Balancer:           %s\n", recorded.LoadBalancerArn)
+
+    for _, port := range slices.Sorted(maps.Keys(recorded.ListenerArns)) {
+        fmt.Printf("Listener %-15d %s\n", port, recorded.ListenerArns[port])
+    }
+
+    fmt.Printf("Target Group:            %s\n", recorded.TargetGroupArn)
+
+    if len(recorded.CanaryTargetGroupArn) > 0 {
+        fmt.Printf("Canary Target Group:     %s\n", recorded.CanaryTargetGroupArn)
+    }
+
+    fmt.Printf("Security Group:          %s\n", recorded.SecurityGroupId)
+    fmt.Printf("Instance Security Group: %s\n", recorded.InstanceSecurityGroupId)
+    fmt.Printf("Updated:                 %s\n", recorded.UpdatedAt.Local().Format(time.DateTime))
+}
+
+func StateShow(ctx context.Context, serviceName string, format string) error {
+    state, err := service.DefaultAwsumILB.LoadState(ctx)
+
+    if err != nil {
+        return err
+    }
+
+    recorded, ok := state.Services[serviceName]
+
+    if !ok {
+        return fmt.Errorf("%w '%s'", service.ErrServiceNotInState, serviceName)
+    }
+
+    if format == "json" {
+        buf, err := json.MarshalIndent(recorded, "", "  ")
+
+        if err != nil {
+            return fmt.Errorf("failed to encode service state: %w", err)
+        }
+
+        fmt.Println(string(buf))
+
+        return nil
+    }
+
+    printServiceState(serviceName, recorded)
+
+    return nil
+}
+
+// StateImport records the existing resources of a service in the state, like ones created before awsum had a state.
+func StateImport(opts service.ImportServiceStateOptions) error {
+    recorded, err := service.DefaultAwsumILB.ImportServiceState(opts)
+
+    if err != nil {
+        return err
+    }
+
+    printServiceState(opts.ServiceName, recorded)
+
+    fmt.Println()
+    fmt.Printf("service '%s' imported\n", opts.ServiceName)
+
+    return nil
+}
+
+// StateForget removes a service from the state, its resources are left as they are.
+func StateForget(ctx context.Context, serviceName string) error {
+    if err := service.DefaultAwsumILB.ForgetServiceState(ctx, serviceName); err != nil {
+        return err
+    }
+
+    fmt.Printf("service '%s' forgotten, its resources were left as they are\n", serviceName)
+
+    return nil
+}
@@ -5,7 +5,6 @@ import (
     "fmt"
     "io"
     "os"
-    "strings"
     "time"
 
     "github.com/aws/aws-sdk-go-v2/aws"
@@ -15,7 +14,8 @@ import (
     "github.com/levelshatter/awsum/service"
 )
 
-var Ctx = context.Background()
+// Ctx is the root context of awsum, it is cancelled via Cancel when awsum is signalled to exit.
+var Ctx, Cancel = context.WithCancel(context.Background())
 
 type Resources struct {
     Files []*os.File
@@ -37,10 +37,7 @@ func Setup() *Resources {
     sessionStartTime := time.Now()
     awsOutputLogFilename := "awsum-global-aws-log-output"
 
-    sessionAwsOutputLogFilename := fmt.Sprintf(
-        "awsum-session-aws-log-output-%s",
-        strings.ReplaceAll(strings.ReplaceAll(sessionStartTime.Format(time.DateTime), " ", "__"), ":", "-"),
-    )
+    sessionAwsOutputLogFilename := files.SessionFilename("awsum-session-aws-log-output", sessionStartTime)
 
     globalAwsLogFile, err := files.OpenAwsumFile(awsOutputLogFilename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 
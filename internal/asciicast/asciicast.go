@@ -0,0 +1,294 @@
+// Package asciicast reads and writes terminal session recordings in the asciicast v2 format
+// (https://docs.asciinema.org/manual/asciicast/v2/).
+package asciicast
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "sync"
+    "time"
+    "unicode/utf8"
+)
+
+const (
+    EventOutput = "o"
+    EventInput  = "i"
+    EventResize = "r"
+)
+
+var (
+    ErrUnsupportedVersion = errors.New("unsupported asciicast version")
+    ErrMalformedEvent     = errors.New("malformed asciicast event")
+)
+
+type Header struct {
+    Version   int               `json:"version"`
+    Width     int               `json:"width"`
+    Height    int               `json:"height"`
+    Timestamp int64             `json:"timestamp,omitempty"`
+    Title     string            `json:"title,omitempty"`
+    Env       map[string]string `json:"env,omitempty"`
+}
+
+type Event struct {
+    Time time.Duration
+    Type string
+    Data string
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+    return json.Marshal([]any{e.Time.Seconds(), e.Type, e.Data})
+}
+
+func (e *Event) UnmarshalJSON(buf []byte) error {
+    var (
+        seconds float64
+        fields  = []any{&seconds, &e.Type, &e.Data}
+    )
+
+    if err := json.Unmarshal(buf, &fields); err != nil {
+        return fmt.Errorf("%w: %w", ErrMalformedEvent, err)
+    }
+
+    if len(fields) != 3 {
+        return fmt.Errorf("%w: expected 3 fields, got %d", ErrMalformedEvent, len(fields))
+    }
+
+    e.Time = time.Duration(seconds * float64(time.Second))
+
+    return nil
+}
+
+// Recorder writes an asciicast v2 recording of a terminal session. It is safe for concurrent use.
+type Recorder struct {
+    mu      sync.Mutex
+    w       io.Writer
+    start   time.Time
+    pending map[string][]byte
+    err     error
+}
+
+// NewRecorder writes the header of a new recording to w and returns a Recorder for its events. The header's version
+// and timestamp are filled in if they are not set.
+func NewRecorder(w io.Writer, header Header) (*Recorder, error) {
+    start := time.Now()
+
+    if header.Version == 0 {
+        header.Version = 2
+    }
+
+    if header.Timestamp == 0 {
+        header.Timestamp = start.Unix()
+    }
+
+    buf, err := json.Marshal(header)
+
+    if err != nil {
+        return nil, fmt.Errorf("failed to encode asciicast header: %w", err)
+    }
+
+    if _, err = w.Write(append(buf, '\n')); err != nil {
+        return nil, fmt.Errorf("failed to write asciicast header: %w", err)
+    }
+
+    return &Recorder{
+        w:       w,
+        start:   start,
+        pending: make(map[string][]byte),
+    }, nil
+}
+
+// record writes an event with the given data. Data is buffered until it ends on a complete UTF-8 sequence, since
+// event data is a JSON string and a sequence split across writes would otherwise be mangled.
+func (r *Recorder) record(eventType string, p []byte) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.err != nil {
+        return
+    }
+
+    data := append(r.pending[eventType], p...)
+    cut := len(data)
+
+    // back up to the start of a trailing incomplete sequence (which is at most utf8.UTFMax-1 bytes long)
+    for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax+1; i-- {
+        if utf8.RuneStart(data[i]) {
+            if !utf8.FullRune(data[i:]) {
+                cut = i
+            }
+
+            break
+        }
+    }
+
+    r.pending[eventType] = append([]byte(nil), data[cut:]...)
+
+    if cut == 0 {
+        return
+    }
+
+    buf, err := json.Marshal(Event{
+        Time: time.Since(r.start),
+        Type: eventType,
+        Data: string(data[:cut]),
+    })
+
+    if err == nil {
+        _, err = r.w.Write(append(buf, '\n'))
+    }
+
+    r.err = err
+}
+
+// Resize records a change of the terminal size.
+func (r *Recorder) Resize(width int, height int) {
+    r.record(EventResize, []byte(fmt.Sprintf("%dx%d", width, height)))
+}
+
+// Output returns a writer that records everything written to it as terminal output.
+func (r *Recorder) Output() io.Writer {
+    return recorderStream{recorder: r, eventType: EventOutput}
+}
+
+// Input returns a writer that records everything written to it as terminal input.
+func (r *Recorder) Input() io.Writer {
+    return recorderStream{recorder: r, eventType: EventInput}
+}
+
+// Err returns the first error encountered while writing the recording, if any.
+func (r *Recorder) Err() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    return r.err
+}
+
+type recorderStream struct {
+    recorder  *Recorder
+    eventType string
+}
+
+func (s recorderStream) Write(p []byte) (int, error) {
+    s.recorder.record(s.eventType, p)
+
+    return len(p), nil
+}
+
+// Reader reads the header and events of an asciicast v2 recording.
+type Reader struct {
+    Header  Header
+    scanner *bufio.Scanner
+    line    int
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+    reader := &Reader{scanner: scanner}
+
+    if !scanner.Scan() {
+        if err := scanner.Err(); err != nil {
+            return nil, fmt.Errorf("failed to read asciicast header: %w", err)
+        }
+
+        return nil, fmt.Errorf("failed to read asciicast header: %w", io.ErrUnexpectedEOF)
+    }
+
+    reader.line++
+
+    if err := json.Unmarshal(scanner.Bytes(), &reader.Header); err != nil {
+        return nil, fmt.Errorf("failed to parse asciicast header: %w", err)
+    }
+
+    if reader.Header.Version != 2 {
+        return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, reader.Header.Version)
+    }
+
+    return reader, nil
+}
+
+// Next returns the next event in the recording, or io.EOF when there are none left.
+func (r *Reader) Next() (Event, error) {
+    var event Event
+
+    for r.scanner.Scan() {
+        r.line++
+
+        if len(r.scanner.Bytes()) == 0 {
+            continue
+        }
+
+        if err := json.Unmarshal(r.scanner.Bytes(), &event); err != nil {
+            return event, fmt.Errorf("line %d: %w", r.line, err)
+        }
+
+        return event, nil
+    }
+
+    if err := r.scanner.Err(); err != nil {
+        return event, err
+    }
+
+    return event, io.EOF
+}
+
+type PlayOptions struct {
+    // Speed is the playback speed multiplier, 1 plays the recording back in real time.
+    Speed float64
+    // MaxIdle caps the time spent waiting between two events (after applying Speed), no cap if it is zero.
+    MaxIdle time.Duration
+}
+
+// Play writes the output events of the recording to w with their original timing (adjusted by the options), until
+// the recording ends or the context is done.
+func (r *Reader) Play(ctx context.Context, w io.Writer, opts PlayOptions) error {
+    var (
+        speed = opts.Speed
+        last  time.Duration
+    )
+
+    if speed <= 0 {
+        speed = 1
+    }
+
+    for {
+        event, err := r.Next()
+
+        if errors.Is(err, io.EOF) {
+            return nil
+        }
+
+        if err != nil {
+            return err
+        }
+
+        wait := time.Duration(float64(event.Time-last) / speed)
+        last = event.Time
+
+        if opts.MaxIdle > 0 {
+            wait = min(wait, opts.MaxIdle)
+        }
+
+        if wait > 0 {
+            select {
+            case <-ctx.Done():
+                return context.Cause(ctx)
+            case <-time.After(wait):
+            }
+        }
+
+        if event.Type != EventOutput {
+            continue
+        }
+
+        if _, err = io.WriteString(w, event.Data); err != nil {
+            return err
+        }
+    }
+}
@@ -0,0 +1,78 @@
+package asciicast_test
+
+import (
+    "bytes"
+    "io"
+    "strings"
+    "testing"
+
+    "github.com/levelshatter/awsum/internal/asciicast"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestRecorder_RoundTrip(t *testing.T) {
+    var buf bytes.Buffer
+
+    recorder, err := asciicast.NewRecorder(&buf, asciicast.Header{Width: 80, Height: 24, Title: "web-1"})
+    require.NoError(t, err)
+
+    _, _ = recorder.Input().Write([]byte("ls\r"))
+    _, _ = recorder.Output().Write([]byte("caf\xc3"))
+    _, _ = recorder.Output().Write([]byte("\xa9\r\n"))
+    recorder.Resize(100, 30)
+
+    require.NoError(t, recorder.Err())
+
+    reader, err := asciicast.NewReader(&buf)
+    require.NoError(t, err)
+
+    assert.Equal(t, 2, reader.Header.Version)
+    assert.Equal(t, 80, reader.Header.Width)
+    assert.Equal(t, "web-1", reader.Header.Title)
+
+    var events []asciicast.Event
+
+    for {
+        event, err := reader.Next()
+
+        if err == io.EOF {
+            break
+        }
+
+        require.NoError(t, err)
+        events = append(events, event)
+    }
+
+    require.Len(t, events, 4)
+    assert.Equal(t, asciicast.EventInput, events[0].Type)
+    assert.Equal(t, "ls\r", events[0].Data)
+    // the split multibyte sequence is held back until it is complete
+    assert.Equal(t, "caf", events[1].Data)
+    assert.Equal(t, "é\r\n", events[2].Data)
+    assert.Equal(t, asciicast.EventResize, events[3].Type)
+    assert.Equal(t, "100x30", events[3].Data)
+}
+
+func TestReader_Play(t *testing.T) {
+    recording := strings.Join([]string{
+        `{"version": 2, "width": 80, "height": 24}`,
+        `[0.001, "o", "hello "]`,
+        `[0.002, "i", "x"]`,
+        `[0.003, "o", "world"]`,
+    }, "\n")
+
+    reader, err := asciicast.NewReader(strings.NewReader(recording))
+    require.NoError(t, err)
+
+    var out bytes.Buffer
+
+    require.NoError(t, reader.Play(t.Context(), &out, asciicast.PlayOptions{Speed: 10}))
+    assert.Equal(t, "hello world", out.String())
+}
+
+func TestNewReader_UnsupportedVersion(t *testing.T) {
+    _, err := asciicast.NewReader(strings.NewReader(`{"version": 1, "width": 80, "height": 24}`))
+
+    assert.ErrorIs(t, err, asciicast.ErrUnsupportedVersion)
+}
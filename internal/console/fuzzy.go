@@ -0,0 +1,47 @@
+package console
+
+import (
+    "strings"
+    "unicode"
+)
+
+// FuzzyMatch reports whether every character of pattern appears in text in order (case-insensitively), along with a
+// score that is higher for tighter matches, matches at word boundaries, and matches near the start of text.
+func FuzzyMatch(pattern string, text string) (int, bool) {
+    var (
+        p         = []rune(strings.ToLower(pattern))
+        t         = []rune(strings.ToLower(text))
+        score     int
+        pi        int
+        lastMatch = -1
+    )
+
+    if len(p) == 0 {
+        return 0, true
+    }
+
+    for ti := 0; ti < len(t) && pi < len(p); ti++ {
+        if t[ti] != p[pi] {
+            continue
+        }
+
+        score += 1
+
+        if lastMatch == ti-1 {
+            score += 5
+        }
+
+        if ti == 0 || !unicode.IsLetter(t[ti-1]) && !unicode.IsDigit(t[ti-1]) {
+            score += 3
+        }
+
+        if lastMatch == -1 {
+            score -= min(ti, 10)
+        }
+
+        lastMatch = ti
+        pi++
+    }
+
+    return score, pi == len(p)
+}
@@ -0,0 +1,27 @@
+package console_test
+
+import (
+    "testing"
+
+    "github.com/levelshatter/awsum/internal/console"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+    _, ok := console.FuzzyMatch("wb1", "web-1 i-0abc 10.0.0.1")
+    assert.True(t, ok)
+
+    _, ok = console.FuzzyMatch("WEB", "web-1")
+    assert.True(t, ok)
+
+    _, ok = console.FuzzyMatch("", "anything")
+    assert.True(t, ok)
+
+    _, ok = console.FuzzyMatch("bew", "web-1")
+    assert.False(t, ok)
+
+    tight, _ := console.FuzzyMatch("web", "web-1")
+    loose, _ := console.FuzzyMatch("web", "worker-eb-b")
+
+    assert.Greater(t, tight, loose)
+}
@@ -0,0 +1,227 @@
+package console
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "slices"
+    "strings"
+    "unicode/utf8"
+
+    "golang.org/x/term"
+)
+
+// pickerHeight is the maximum number of options shown at once by Pick.
+const pickerHeight = 10
+
+var (
+    ErrPickCancelled = errors.New("selection cancelled")
+    ErrNotATerminal  = errors.New("stdin is not a terminal")
+)
+
+type pickerOption struct {
+    index int
+    score int
+}
+
+type picker struct {
+    prompt   string
+    options  []string
+    query    []rune
+    filtered []pickerOption
+    cursor   int
+    offset   int
+    drawn    int
+    width    int
+}
+
+// truncate cuts the line down to the terminal width so that it never wraps (which would break redrawing).
+func (p *picker) truncate(line string) string {
+    if r := []rune(line); p.width > 1 && len(r) >= p.width {
+        return string(r[:p.width-1])
+    }
+
+    return line
+}
+
+func (p *picker) filter() {
+    p.filtered = p.filtered[:0]
+
+    for i, option := range p.options {
+        if score, ok := FuzzyMatch(string(p.query), option); ok {
+            p.filtered = append(p.filtered, pickerOption{index: i, score: score})
+        }
+    }
+
+    if len(p.query) > 0 {
+        slices.SortStableFunc(p.filtered, func(a, b pickerOption) int {
+            return b.score - a.score
+        })
+    }
+
+    p.cursor = 0
+    p.offset = 0
+}
+
+func (p *picker) move(delta int) {
+    if len(p.filtered) == 0 {
+        return
+    }
+
+    p.cursor = (p.cursor + delta + len(p.filtered)) % len(p.filtered)
+
+    if p.cursor < p.offset {
+        p.offset = p.cursor
+    } else if p.cursor >= p.offset+pickerHeight {
+        p.offset = p.cursor - pickerHeight + 1
+    }
+}
+
+func (p *picker) render() {
+    var b strings.Builder
+
+    // move back up to (and clear) whatever was drawn last time
+    if p.drawn > 0 {
+        fmt.Fprintf(&b, "\x1b[%dA", p.drawn)
+    }
+
+    b.WriteString("\r\x1b[J")
+    b.WriteString(p.truncate(fmt.Sprintf("%s (%d/%d) > %s", p.prompt, len(p.filtered), len(p.options), string(p.query))))
+    b.WriteString("\r\n")
+
+    lines := 1
+
+    for i := p.offset; i < len(p.filtered) && i < p.offset+pickerHeight; i++ {
+        marker := "  "
+
+        if i == p.cursor {
+            marker = "> "
+        }
+
+        b.WriteString(p.truncate(marker+p.options[p.filtered[i].index]) + "\r\n")
+        lines++
+    }
+
+    p.drawn = lines
+
+    fmt.Print(b.String())
+}
+
+func (p *picker) clear() {
+    if p.drawn > 0 {
+        fmt.Printf("\x1b[%dA\r\x1b[J", p.drawn)
+    }
+}
+
+// Pick shows an interactive, type-to-filter list of the given options on the terminal and returns the index of the
+// option the user selects. Arrow keys (or ctrl-p/ctrl-n) move the selection, enter confirms and escape or ctrl-c
+// cancels with ErrPickCancelled.
+func Pick(ctx context.Context, prompt string, options []string) (int, error) {
+    fd := int(os.Stdin.Fd())
+
+    if !term.IsTerminal(fd) {
+        return -1, ErrNotATerminal
+    }
+
+    oldState, err := term.MakeRaw(fd)
+
+    if err != nil {
+        return -1, fmt.Errorf("failed to make terminal raw for selection: %w", err)
+    }
+
+    defer func() {
+        _ = term.Restore(fd, oldState)
+    }()
+
+    width, _, err := term.GetSize(fd)
+
+    if err != nil {
+        width = 80
+    }
+
+    p := &picker{
+        prompt:  prompt,
+        options: options,
+        width:   width,
+    }
+
+    p.filter()
+    p.render()
+
+    defer p.clear()
+
+    var (
+        stdin = Stdin(ctx)
+        buf   = make([]byte, 64)
+        // pending is the start of a character whose remaining bytes are yet to be read
+        pending []byte
+    )
+
+    for {
+        n, err := stdin.Read(buf)
+
+        if err != nil {
+            if ctxErr := context.Cause(ctx); ctxErr != nil {
+                return -1, ctxErr
+            }
+
+            return -1, ErrPickCancelled
+        }
+
+        input := append(pending, buf[:n]...)
+        pending = nil
+
+        for len(input) > 0 {
+            switch {
+            case input[0] == '\r' || input[0] == '\n':
+                if len(p.filtered) == 0 {
+                    input = input[1:]
+                    continue
+                }
+
+                return p.filtered[p.cursor].index, nil
+            case input[0] == 0x03 || (input[0] == 0x1b && len(input) == 1):
+                return -1, ErrPickCancelled
+            case strings.HasPrefix(string(input), "\x1b[A") || strings.HasPrefix(string(input), "\x1bOA"):
+                p.move(-1)
+                input = input[3:]
+            case strings.HasPrefix(string(input), "\x1b[B") || strings.HasPrefix(string(input), "\x1bOB"):
+                p.move(1)
+                input = input[3:]
+            case input[0] == 0x10:
+                p.move(-1)
+                input = input[1:]
+            case input[0] == 0x0e:
+                p.move(1)
+                input = input[1:]
+            case input[0] == 0x7f || input[0] == 0x08:
+                if len(p.query) > 0 {
+                    p.query = p.query[:len(p.query)-1]
+                    p.filter()
+                }
+
+                input = input[1:]
+            case input[0] == 0x1b:
+                // ignore any other escape sequence
+                input = input[len(input):]
+            case !utf8.FullRune(input):
+                // a read can end partway through a multi-byte character, the rest of it comes with the next one
+                pending = append(pending, input...)
+                input = nil
+            default:
+                r, size := utf8.DecodeRune(input)
+
+                // invalid bytes decode to utf8.RuneError and are skipped
+                if r >= ' ' && r != utf8.RuneError {
+                    p.query = append(p.query, r)
+                    p.filter()
+                }
+
+                input = input[size:]
+            }
+        }
+
+        p.render()
+    }
+}
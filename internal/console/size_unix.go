@@ -0,0 +1,33 @@
+//go:build !windows
+
+package console
+
+import (
+    "context"
+    "os"
+    "os/signal"
+    "syscall"
+
+    "golang.org/x/term"
+)
+
+// WatchSize calls onResize with the new size of the terminal behind fd every time it is resized, until ctx is done.
+func WatchSize(ctx context.Context, fd int, onResize func(width, height int)) {
+    resized := make(chan os.Signal, 1)
+    signal.Notify(resized, syscall.SIGWINCH)
+
+    go func() {
+        defer signal.Stop(resized)
+
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-resized:
+                if width, height, err := term.GetSize(fd); err == nil {
+                    onResize(width, height)
+                }
+            }
+        }
+    }()
+}
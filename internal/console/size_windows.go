@@ -0,0 +1,37 @@
+//go:build windows
+
+package console
+
+import (
+    "context"
+    "time"
+
+    "golang.org/x/term"
+)
+
+// WatchSize calls onResize with the new size of the terminal behind fd every time it is resized, until ctx is done.
+// Windows has no resize signal, so the size is polled instead.
+func WatchSize(ctx context.Context, fd int, onResize func(width, height int)) {
+    width, height, _ := term.GetSize(fd)
+
+    go func() {
+        ticker := time.NewTicker(time.Millisecond * 250)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+                w, h, err := term.GetSize(fd)
+
+                if err != nil || (w == width && h == height) {
+                    continue
+                }
+
+                width, height = w, h
+                onResize(width, height)
+            }
+        }
+    }()
+}
@@ -0,0 +1,129 @@
+package console
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "sync"
+)
+
+var (
+    stdinOnce    sync.Once
+    stdinChunks  chan []byte
+    stdinMu      sync.Mutex
+    stdinPending []byte
+)
+
+func startStdinPump() {
+    stdinChunks = make(chan []byte)
+
+    go func() {
+        for {
+            buf := make([]byte, 4096)
+            n, err := os.Stdin.Read(buf)
+
+            if n > 0 {
+                stdinChunks <- buf[:n]
+            }
+
+            if err != nil {
+                close(stdinChunks)
+                return
+            }
+        }
+    }()
+}
+
+type stdinReader struct {
+    ctx context.Context
+}
+
+func (r stdinReader) Read(p []byte) (int, error) {
+    stdinMu.Lock()
+
+    if len(stdinPending) > 0 {
+        n := copy(p, stdinPending)
+        stdinPending = stdinPending[n:]
+        stdinMu.Unlock()
+
+        return n, nil
+    }
+
+    stdinMu.Unlock()
+
+    select {
+    case <-r.ctx.Done():
+        return 0, io.EOF
+    case chunk, ok := <-stdinChunks:
+        if !ok {
+            return 0, io.EOF
+        }
+
+        stdinMu.Lock()
+        defer stdinMu.Unlock()
+
+        n := copy(p, chunk)
+        stdinPending = append(stdinPending, chunk[n:]...)
+
+        return n, nil
+    }
+}
+
+// Stdin returns a reader of os.Stdin that returns io.EOF once the given context is done. Unlike reading os.Stdin
+// directly, a reader that is abandoned (e.g. by a finished ssh session) doesn't swallow input meant for the next reader.
+func Stdin(ctx context.Context) io.Reader {
+    stdinOnce.Do(startStdinPump)
+
+    return stdinReader{ctx: ctx}
+}
+
+// ReadLine reads a single line from Stdin without the trailing line ending.
+func ReadLine(ctx context.Context) (string, error) {
+    var (
+        r    = Stdin(ctx)
+        line strings.Builder
+        b    = make([]byte, 1)
+    )
+
+    for {
+        if _, err := r.Read(b); err != nil {
+            return line.String(), err
+        }
+
+        if b[0] == '\n' {
+            break
+        }
+
+        line.WriteByte(b[0])
+    }
+
+    return strings.TrimSuffix(line.String(), "\r"), nil
+}
+
+// Confirm asks the user the given yes/no question, returning def if they give an empty answer.
+func Confirm(ctx context.Context, prompt string, def bool) (bool, error) {
+    options := "[y/N]"
+
+    if def {
+        options = "[Y/n]"
+    }
+
+    fmt.Printf("%s %s ", prompt, options)
+
+    answer, err := ReadLine(ctx)
+
+    if err != nil {
+        return false, err
+    }
+
+    switch strings.ToLower(strings.TrimSpace(answer)) {
+    case "":
+        return def, nil
+    case "y", "yes":
+        return true, nil
+    default:
+        return false, nil
+    }
+}
@@ -1,8 +1,11 @@
 package files
 
 import (
+    "fmt"
     "os"
     "path"
+    "strings"
+    "time"
 )
 
 func CreateAwsumDataDirectory() (string, error) {
@@ -36,3 +39,13 @@ func OpenAwsumFile(filename string, flag int, perm os.FileMode) (*os.File, error
 
     return os.OpenFile(path.Join(dataDir, filename), flag, perm)
 }
+
+// SessionFilename returns the name of a file belonging to a single awsum session started at the given time, in the
+// format '<prefix>-YYYY-MM-DD__HH-mm-SS'.
+func SessionFilename(prefix string, t time.Time) string {
+    return fmt.Sprintf(
+        "%s-%s",
+        prefix,
+        strings.ReplaceAll(strings.ReplaceAll(t.Format(time.DateTime), " ", "__"), ":", "-"),
+    )
+}
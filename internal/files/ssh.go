@@ -21,6 +21,34 @@ func GetAssumedUserSSHDir() (string, error) {
     return path.Join(homeDir, ".ssh"), nil
 }
 
+func GetKnownHostsFilename() (string, error) {
+    sshDir, err := GetAssumedUserSSHDir()
+
+    if err != nil {
+        return "", err
+    }
+
+    return path.Join(sshDir, "known_hosts"), nil
+}
+
+// CheckKnownHostsKey checks the host key against the known_hosts file without adding unknown hosts to it. The returned
+// error is a *knownhosts.KeyError if the host is unknown (no wanted keys) or its key conflicts (wanted keys).
+func CheckKnownHostsKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+    knownHostsFilename, err := GetKnownHostsFilename()
+
+    if err != nil {
+        return err
+    }
+
+    hostKeyCallback, err := knownhosts.New(knownHostsFilename)
+
+    if err != nil {
+        return fmt.Errorf("failed to read known_hosts '%s': %w", knownHostsFilename, err)
+    }
+
+    return hostKeyCallback(hostname, remote, key)
+}
+
 func GenerateHostKeyCallbackFromKnownHosts() (ssh.HostKeyCallback, error) {
     sshDir, err := GetAssumedUserSSHDir()
 
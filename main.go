@@ -11,16 +11,25 @@ import (
     "strings"
     "sync"
     "syscall"
+    "time"
 
     "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
     "github.com/levelshatter/awsum/commands"
     "github.com/levelshatter/awsum/internal/app"
+    "github.com/levelshatter/awsum/internal/memory"
     "github.com/levelshatter/awsum/service"
     "github.com/urfave/cli/v3"
 )
 
+// version is set by the release build.
+var version = "dev"
+
+// driftExitCode is what awsum exits with when drift was detected, telling it apart from failing to check for it.
+const driftExitCode = 2
+
 func main() {
     resources := app.Setup()
+    service.Version = version
 
     var once sync.Once
 
@@ -42,10 +51,46 @@ func main() {
         cleanup()
     }()
 
+    go func() {
+        interrupts := 0
+
+        for range exit {
+            interrupts++
+
+            // the first signal cancels in-flight work (and any remote sessions), a second one forces an exit
+            if interrupts > 1 {
+                cleanup()
+                os.Exit(1)
+            }
+
+            app.Cancel()
+        }
+    }()
+
     cmd := &cli.Command{
         Name:        "awsum",
         Usage:       "a fun CLI tool for working with AWS infra",
         Description: "awsum allows you to rapidly develop with your own infra via the command line",
+        Version:     version,
+        Flags: []cli.Flag{
+            &cli.StringFlag{
+                Name:     "state",
+                Usage:    "where the resources of services are recorded: a local file, or s3://bucket/key (defaults to the awsum data directory)",
+                Sources:  cli.EnvVars("AWSUM_STATE"),
+                OnlyOnce: true,
+            },
+        },
+        Before: func(ctx context.Context, command *cli.Command) (context.Context, error) {
+            state, err := service.OpenStateBackend(command.String("state"), service.DefaultS3)
+
+            if err != nil {
+                return ctx, err
+            }
+
+            service.DefaultAwsumILB.State = state
+
+            return ctx, nil
+        },
         Commands: []*cli.Command{
             {
                 Name: "configure",
@@ -53,6 +98,394 @@ func main() {
                     return commands.Configure()
                 },
             },
+            {
+                Name:      "replay",
+                Usage:     "play back a shell session recorded with instance shell --record",
+                ArgsUsage: "<recording file>",
+                Flags: []cli.Flag{
+                    &cli.FloatFlag{
+                        Name:     "speed",
+                        Aliases:  []string{"s"},
+                        Usage:    "the playback speed multiplier",
+                        Value:    1,
+                        OnlyOnce: true,
+                        Validator: func(f float64) error {
+                            if f <= 0 {
+                                return errors.New("speed must be greater than 0")
+                            }
+
+                            return nil
+                        },
+                    },
+                    &cli.DurationFlag{
+                        Name:     "max-idle",
+                        Usage:    "the maximum time to wait between two events of the recording. 0 means no limit.",
+                        Value:    time.Second * 2,
+                        OnlyOnce: true,
+                    },
+                },
+                Action: func(ctx context.Context, command *cli.Command) error {
+                    if command.Args().Len() != 1 {
+                        return errors.New("expected exactly one recording file")
+                    }
+
+                    return commands.Replay(commands.ReplayOptions{
+                        Ctx:      ctx,
+                        Filename: command.Args().First(),
+                        Speed:    command.Float("speed"),
+                        MaxIdle:  command.Duration("max-idle"),
+                    })
+                },
+            },
+            {
+                Name:  "apply",
+                Usage: "load balance every service described in a spec file, making each match its spec",
+                Flags: []cli.Flag{
+                    &cli.StringFlag{
+                        Name:     "file",
+                        Aliases:  []string{"f"},
+                        Usage:    "the yaml spec file, one document per service (- reads it from stdin)",
+                        OnlyOnce: true,
+                        Required: true,
+                    },
+                    &cli.BoolFlag{
+                        Name:     "plan",
+                        Usage:    "only print the changes that would be made to each service",
+                        OnlyOnce: true,
+                    },
+                    &cli.BoolFlag{
+                        Name:     "yes",
+                        Aliases:  []string{"y"},
+                        Usage:    "don't ask for confirmation",
+                        OnlyOnce: true,
+                    },
+                    &cli.BoolFlag{
+                        Name:     "wait-healthy",
+                        Usage:    "wait for each service's targets to become healthy and probe its url before moving on",
+                        OnlyOnce: true,
+                    },
+                },
+                Action: func(ctx context.Context, command *cli.Command) error {
+                    return commands.Apply(commands.ApplyOptions{
+                        Ctx:         ctx,
+                        File:        command.String("file"),
+                        Plan:        command.Bool("plan"),
+                        Yes:         command.Bool("yes"),
+                        WaitHealthy: command.Bool("wait-healthy"),
+                    })
+                },
+            },
+            {
+                Name:  "service",
+                Usage: "manage services load-balanced by awsum",
+                Commands: []*cli.Command{
+                    {
+                        Name:  "list",
+                        Usage: "display every service load-balanced by awsum",
+                        Flags: []cli.Flag{
+                            &cli.StringFlag{
+                                Name:     "format",
+                                Usage:    "pretty|json",
+                                Value:    "pretty",
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    if s != "pretty" && s != "json" {
+                                        return fmt.Errorf("invalid format, must be pretty or json")
+                                    }
+
+                                    return nil
+                                },
+                                ValidateDefaults: true,
+                            },
+                        },
+                        Action: func(ctx context.Context, command *cli.Command) error {
+                            return commands.ServiceList(ctx, command.String("format"))
+                        },
+                    },
+                    {
+                        Name:      "describe",
+                        Usage:     "display the load balancer, listeners, targets & their health and domains of a service",
+                        ArgsUsage: "<service name>",
+                        Flags: []cli.Flag{
+                            &cli.StringFlag{
+                                Name:     "format",
+                                Usage:    "pretty|json",
+                                Value:    "pretty",
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    if s != "pretty" && s != "json" {
+                                        return fmt.Errorf("invalid format, must be pretty or json")
+                                    }
+
+                                    return nil
+                                },
+                                ValidateDefaults: true,
+                            },
+                        },
+                        Action: func(ctx context.Context, command *cli.Command) error {
+                            if command.Args().Len() != 1 {
+                                return errors.New("expected exactly one service name")
+                            }
+
+                            return commands.ServiceDescribe(ctx, command.Args().First(), command.String("format"))
+                        },
+                    },
+                    {
+                        Name:      "promote",
+                        Usage:     "gradually shift every request of a service to its canary, health checking the canary between steps, then make the canary instances its only targets",
+                        ArgsUsage: "<service name>",
+                        Flags: []cli.Flag{
+                            &cli.IntFlag{
+                                Name:     "step",
+                                Usage:    "the percentage of requests shifted at a time (1-100)",
+                                Value:    25,
+                                OnlyOnce: true,
+                                Validator: func(i int) error {
+                                    if i < 1 || i > 100 {
+                                        return service.ErrInvalidCanaryStep
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.DurationFlag{
+                                Name:     "interval",
+                                Usage:    "how long requests are left split after each step, the instances gaining them having to stay healthy, before the next",
+                                Value:    time.Minute,
+                                OnlyOnce: true,
+                            },
+                        },
+                        Action: func(ctx context.Context, command *cli.Command) error {
+                            if command.Args().Len() != 1 {
+                                return errors.New("expected exactly one service name")
+                            }
+
+                            return commands.ServicePromote(commands.ServiceShiftCanaryOptions{
+                                Ctx:         ctx,
+                                ServiceName: command.Args().First(),
+                                Step:        int32(command.Int("step")),
+                                Interval:    command.Duration("interval"),
+                            })
+                        },
+                    },
+                    {
+                        Name:      "rollback",
+                        Usage:     "shift every request of a service back from its canary, then remove the canary",
+                        ArgsUsage: "<service name>",
+                        Flags: []cli.Flag{
+                            &cli.IntFlag{
+                                Name:     "step",
+                                Usage:    "the percentage of requests shifted at a time (1-100)",
+                                Value:    100,
+                                OnlyOnce: true,
+                                Validator: func(i int) error {
+                                    if i < 1 || i > 100 {
+                                        return service.ErrInvalidCanaryStep
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.DurationFlag{
+                                Name:     "interval",
+                                Usage:    "how long requests are left split after each step, the instances gaining them having to stay healthy, before the next",
+                                Value:    time.Minute,
+                                OnlyOnce: true,
+                            },
+                        },
+                        Action: func(ctx context.Context, command *cli.Command) error {
+                            if command.Args().Len() != 1 {
+                                return errors.New("expected exactly one service name")
+                            }
+
+                            return commands.ServiceRollback(commands.ServiceShiftCanaryOptions{
+                                Ctx:         ctx,
+                                ServiceName: command.Args().First(),
+                                Step:        int32(command.Int("step")),
+                                Interval:    command.Duration("interval"),
+                            })
+                        },
+                    },
+                    {
+                        Name:      "drift",
+                        Usage:     "display the changes made to a service outside of awsum since it was last applied, exiting with 2 when there are any",
+                        ArgsUsage: "<service name>",
+                        Flags: []cli.Flag{
+                            &cli.BoolFlag{
+                                Name:  "all",
+                                Usage: "check every service in the state that has been applied, instead of one",
+                            },
+                        },
+                        Action: func(ctx context.Context, command *cli.Command) error {
+                            all := command.Bool("all")
+
+                            if all && command.Args().Len() > 0 {
+                                return errors.New("expected either a service name or --all")
+                            }
+
+                            if !all && command.Args().Len() != 1 {
+                                return errors.New("expected exactly one service name")
+                            }
+
+                            return commands.ServiceDrift(commands.ServiceDriftOptions{
+                                Ctx:         ctx,
+                                ServiceName: command.Args().First(),
+                                All:         all,
+                            })
+                        },
+                    },
+                    {
+                        Name:    "delete",
+                        Usage:   "delete every resource load-balance created for a service",
+                        Suggest: true,
+                        Flags: []cli.Flag{
+                            &cli.StringFlag{
+                                Name:      "service",
+                                Usage:     "the name of the service to delete",
+                                OnlyOnce:  true,
+                                Required:  true,
+                                Validator: service.ValidateServiceName,
+                            },
+                            &cli.BoolFlag{
+                                Name:     "keep-dns",
+                                Usage:    "leave the dns records pointing at the service's load balancer in place",
+                                OnlyOnce: true,
+                            },
+                            &cli.BoolFlag{
+                                Name:     "yes",
+                                Aliases:  []string{"y"},
+                                Usage:    "don't ask for confirmation",
+                                OnlyOnce: true,
+                            },
+                        },
+                        Action: func(ctx context.Context, command *cli.Command) error {
+                            return commands.ServiceDelete(commands.ServiceDeleteOptions{
+                                Ctx:         ctx,
+                                ServiceName: command.String("service"),
+                                KeepDNS:     command.Bool("keep-dns"),
+                                Yes:         command.Bool("yes"),
+                            })
+                        },
+                    },
+                },
+            },
+            {
+                Name:  "state",
+                Usage: "manage the record of the resources of every service awsum manages",
+                Commands: []*cli.Command{
+                    {
+                        Name:  "list",
+                        Usage: "display every service in the state",
+                        Flags: []cli.Flag{
+                            &cli.StringFlag{
+                                Name:     "format",
+                                Usage:    "pretty|json",
+                                Value:    "pretty",
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    if s != "pretty" && s != "json" {
+                                        return fmt.Errorf("invalid format, must be pretty or json")
+                                    }
+
+                                    return nil
+                                },
+                                ValidateDefaults: true,
+                            },
+                        },
+                        Action: func(ctx context.Context, command *cli.Command) error {
+                            return commands.StateList(ctx, command.String("format"))
+                        },
+                    },
+                    {
+                        Name:      "show",
+                        Usage:     "display the resources the state records for a service",
+                        ArgsUsage: "<service name>",
+                        Flags: []cli.Flag{
+                            &cli.StringFlag{
+                                Name:     "format",
+                                Usage:    "pretty|json",
+                                Value:    "pretty",
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    if s != "pretty" && s != "json" {
+                                        return fmt.Errorf("invalid format, must be pretty or json")
+                                    }
+
+                                    return nil
+                                },
+                                ValidateDefaults: true,
+                            },
+                        },
+                        Action: func(ctx context.Context, command *cli.Command) error {
+                            if command.Args().Len() != 1 {
+                                return errors.New("expected exactly one service name")
+                            }
+
+                            return commands.StateShow(ctx, command.Args().First(), command.String("format"))
+                        },
+                    },
+                    {
+                        Name:      "import",
+                        Usage:     "record the existing resources of a service in the state, found by awsum's names for them unless given",
+                        ArgsUsage: "<service name>",
+                        Flags: []cli.Flag{
+                            &cli.StringFlag{
+                                Name:      "shared-lb",
+                                Usage:     "the name of the shared load balancer the service is on",
+                                OnlyOnce:  true,
+                                Validator: service.ValidateServiceName,
+                            },
+                            &cli.StringFlag{
+                                Name:     "load-balancer-arn",
+                                Usage:    "the arn of the service's load balancer",
+                                OnlyOnce: true,
+                            },
+                            &cli.StringFlag{
+                                Name:     "target-group-arn",
+                                Usage:    "the arn of the service's target group",
+                                OnlyOnce: true,
+                            },
+                            &cli.StringFlag{
+                                Name:     "security-group-id",
+                                Usage:    "the id of the security group of the service's load balancer",
+                                OnlyOnce: true,
+                            },
+                            &cli.StringFlag{
+                                Name:     "instance-security-group-id",
+                                Usage:    "the id of the security group letting the load balancer reach the service's instances",
+                                OnlyOnce: true,
+                            },
+                        },
+                        Action: func(ctx context.Context, command *cli.Command) error {
+                            if command.Args().Len() != 1 {
+                                return errors.New("expected exactly one service name")
+                            }
+
+                            return commands.StateImport(service.ImportServiceStateOptions{
+                                Ctx:                     ctx,
+                                ServiceName:             command.Args().First(),
+                                SharedLoadBalancer:      command.String("shared-lb"),
+                                LoadBalancerArn:         command.String("load-balancer-arn"),
+                                TargetGroupArn:          command.String("target-group-arn"),
+                                SecurityGroupId:         command.String("security-group-id"),
+                                InstanceSecurityGroupId: command.String("instance-security-group-id"),
+                            })
+                        },
+                    },
+                    {
+                        Name:      "forget",
+                        Usage:     "remove a service from the state, leaving its resources as they are",
+                        ArgsUsage: "<service name>",
+                        Action: func(ctx context.Context, command *cli.Command) error {
+                            if command.Args().Len() != 1 {
+                                return errors.New("expected exactly one service name")
+                            }
+
+                            return commands.StateForget(ctx, command.Args().First())
+                        },
+                    },
+                },
+            },
             {
                 Name: "instance",
                 Commands: []*cli.Command{
@@ -104,6 +537,45 @@ func main() {
                                 Value:    false,
                                 OnlyOnce: true,
                             },
+                            &cli.DurationFlag{
+                                Name:     "timeout",
+                                Aliases:  []string{"t"},
+                                Usage:    "the maximum time a command may run on each instance before it is terminated (e.g. 30s, 5m). 0 means no timeout.",
+                                OnlyOnce: true,
+                            },
+                            &cli.BoolFlag{
+                                Name:     "all",
+                                Usage:    "when starting a shell and several instances match, open a shell on each of them one after another (the default when stdin is not a terminal, otherwise one is picked interactively)",
+                                OnlyOnce: true,
+                            },
+                            &cli.BoolFlag{
+                                Name:     "first",
+                                Usage:    "when starting a shell and several instances match, only open a shell on the first of them",
+                                OnlyOnce: true,
+                            },
+                            &cli.BoolFlag{
+                                Name:     "broadcast",
+                                Aliases:  []string{"b"},
+                                Usage:    "open interactive shells on every matched instance at once and mirror your keystrokes to all of them (ctrl-] l for key bindings)",
+                                OnlyOnce: true,
+                            },
+                            &cli.BoolFlag{
+                                Name:     "record",
+                                Usage:    "record interactive shell sessions as asciicast files in the awsum data directory (see awsum replay)",
+                                OnlyOnce: true,
+                            },
+                            &cli.DurationFlag{
+                                Name:     "keepalive",
+                                Usage:    "the interval between ssh keepalive requests sent to each instance. 0 disables keepalives.",
+                                Value:    time.Second * 30,
+                                OnlyOnce: true,
+                            },
+                            &cli.IntFlag{
+                                Name:     "keepalive-max",
+                                Usage:    "the number of unanswered ssh keepalive requests after which the connection is considered lost",
+                                Value:    3,
+                                OnlyOnce: true,
+                            },
                             &cli.BoolFlag{
                                 Name:     "parallel",
                                 Aliases:  []string{"p"},
@@ -113,6 +585,18 @@ func main() {
                             },
                         },
                         Action: func(ctx context.Context, command *cli.Command) error {
+                            if command.Bool("all") && command.Bool("first") {
+                                return errors.New("--all and --first cannot be used together")
+                            }
+
+                            if command.Bool("record") && command.Args().Len() > 0 {
+                                return errors.New("--record only applies to interactive shells and cannot be given a command")
+                            }
+
+                            if command.Bool("broadcast") && command.Args().Len() > 0 {
+                                return errors.New("--broadcast starts interactive shells and cannot be given a command, use --parallel instead")
+                            }
+
                             return commands.InstanceShell(commands.InstanceShellOptions{
                                 Ctx: ctx,
                                 InstanceFilters: service.InstanceFilters{
@@ -122,6 +606,99 @@ func main() {
                                 Command:  strings.Join(command.Args().Slice(), " "),
                                 Quiet:    command.Bool("quiet"),
                                 Parallel: command.Bool("parallel"),
+                                Timeout:  command.Duration("timeout"),
+                                KeepAlive: service.KeepAliveOptions{
+                                    Interval: command.Duration("keepalive"),
+                                    CountMax: command.Int("keepalive-max"),
+                                },
+                                All:       command.Bool("all"),
+                                First:     command.Bool("first"),
+                                Broadcast: command.Bool("broadcast"),
+                                Record:    command.Bool("record"),
+                            })
+                        },
+                    },
+                    {
+                        Name:    "facts",
+                        Usage:   "gather facts (os, kernel, uptime, cpu, memory, disks, listening ports, containers & packages) from ec2 instance(s) over SSH",
+                        Suggest: true,
+                        Flags: []cli.Flag{
+                            &cli.StringFlag{
+                                Name:     "user",
+                                Aliases:  []string{"as"},
+                                Usage:    "which ssh user to connect as",
+                                Value:    "ec2-user",
+                                OnlyOnce: true,
+                            },
+                            &cli.StringFlag{
+                                Name:     "name",
+                                Aliases:  []string{"n"},
+                                Usage:    "a fuzzy filter that matches against ec2 instance names (from tags)",
+                                OnlyOnce: true,
+                            },
+                            &cli.StringFlag{
+                                Name:     "format",
+                                Usage:    "pretty|json",
+                                Value:    "pretty",
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    if s != "pretty" && s != "json" {
+                                        return fmt.Errorf("invalid format, must be pretty or json")
+                                    }
+
+                                    return nil
+                                },
+                                ValidateDefaults: true,
+                            },
+                            &cli.DurationFlag{
+                                Name:     "max-age",
+                                Usage:    "how old cached facts of an instance may be before they are gathered again",
+                                Value:    time.Hour,
+                                OnlyOnce: true,
+                            },
+                            &cli.BoolFlag{
+                                Name:     "refresh",
+                                Usage:    "ignore cached facts and gather them from every instance",
+                                OnlyOnce: true,
+                            },
+                        },
+                        Action: func(ctx context.Context, command *cli.Command) error {
+                            return commands.InstanceFacts(commands.InstanceFactsOptions{
+                                Ctx: ctx,
+                                InstanceFilters: service.InstanceFilters{
+                                    Name: command.String("name"),
+                                },
+                                User:    command.String("user"),
+                                Format:  command.String("format"),
+                                MaxAge:  command.Duration("max-age"),
+                                Refresh: command.Bool("refresh"),
+                            })
+                        },
+                    },
+                    {
+                        Name:    "doctor",
+                        Usage:   "diagnose why ec2 instance(s) matched by the given filters can't be reached over SSH",
+                        Suggest: true,
+                        Flags: []cli.Flag{
+                            &cli.StringFlag{
+                                Name:     "name",
+                                Aliases:  []string{"n"},
+                                Usage:    "a fuzzy filter that matches against ec2 instance names (from tags)",
+                                OnlyOnce: true,
+                            },
+                            &cli.StringFlag{
+                                Name:     "egress-ip",
+                                Usage:    "the public ip address you connect from, looked up automatically if not given",
+                                OnlyOnce: true,
+                            },
+                        },
+                        Action: func(ctx context.Context, command *cli.Command) error {
+                            return commands.InstanceDoctor(commands.InstanceDoctorOptions{
+                                Ctx: ctx,
+                                InstanceFilters: service.InstanceFilters{
+                                    Name: command.String("name"),
+                                },
+                                EgressIP: command.String("egress-ip"),
                             })
                         },
                     },
@@ -131,10 +708,11 @@ func main() {
                         Suggest: true,
                         Flags: []cli.Flag{
                             &cli.StringFlag{
-                                Name:     "service",
-                                Usage:    "the name of the new or existing service you wish to load-balance",
-                                OnlyOnce: true,
-                                Required: true,
+                                Name:      "service",
+                                Usage:     "the name of the new or existing service you wish to load-balance",
+                                OnlyOnce:  true,
+                                Required:  true,
+                                Validator: service.ValidateServiceName,
                             },
                             &cli.StringFlag{
                                 Name:     "name",
@@ -145,7 +723,6 @@ func main() {
                                 Name:     "port",
                                 Usage:    "the port to create the load-balancer listener on & the target traffic port of your service on each instance",
                                 OnlyOnce: true,
-                                Required: true,
                                 Validator: func(s string) error {
                                     parts := strings.Split(s, ":")
 
@@ -184,6 +761,15 @@ func main() {
                                 },
                                 ValidateDefaults: true,
                             },
+                            &cli.StringSliceFlag{
+                                Name:  "listener",
+                                Usage: "a load-balancer listener in format <load balancer port>:<instance port>:<load balancer protocol>:<instance protocol> (e.g. 443:80:https:http), instead of --port & --protocol. can be repeated, every listener must use the same instance port & protocol.",
+                            },
+                            &cli.BoolFlag{
+                                Name:     "redirect-http",
+                                Usage:    "add a listener on port 80 redirecting http requests to the https listener",
+                                OnlyOnce: true,
+                            },
                             &cli.StringFlag{
                                 Name:        "ip-protocol",
                                 DefaultText: "tcp",
@@ -206,23 +792,357 @@ func main() {
                                 Usage:    "if your load balancer and domain records should be private",
                                 OnlyOnce: true,
                             },
+                            &cli.StringFlag{
+                                Name:     "lb-type",
+                                Usage:    "the type of load balancer, application|network|gateway. picked from the listener protocols by default (network for tcp, udp & tls).",
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    _, err := service.ParseLoadBalancerType(s)
+                                    return err
+                                },
+                            },
+                            &cli.StringFlag{
+                                Name:     "alpn-policy",
+                                Usage:    "the alpn policy of tls listeners, " + strings.Join(service.AlpnPolicies, "|"),
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    if !slices.Contains(service.AlpnPolicies, s) {
+                                        return service.ErrInvalidAlpnPolicy
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.IntFlag{
+                                Name:     "canary",
+                                Usage:    "put newly matched instances in a canary target group getting this percentage of requests (1-99), see service promote & service rollback",
+                                OnlyOnce: true,
+                                Validator: func(i int) error {
+                                    if i < 1 || i > 99 {
+                                        return service.ErrInvalidCanaryWeight
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.StringFlag{
+                                Name:      "shared-lb",
+                                Usage:     "put the service on the shared load balancer with this name, routing to it by --host and --path",
+                                OnlyOnce:  true,
+                                Validator: service.ValidateServiceName,
+                            },
+                            &cli.StringSliceFlag{
+                                Name:  "host",
+                                Usage: "with --shared-lb, a host name (e.g. api.example.com) routed to the service",
+                            },
+                            &cli.StringSliceFlag{
+                                Name:  "path",
+                                Usage: "with --shared-lb, a path pattern (e.g. /api/*) routed to the service",
+                            },
+                            &cli.IntFlag{
+                                Name:     "priority",
+                                Usage:    "with --shared-lb, the priority of the service's listener rules (1-50000). the lowest free one is used by default.",
+                                OnlyOnce: true,
+                                Validator: func(i int) error {
+                                    if i < 1 || i > service.MaxRulePriority {
+                                        return service.ErrInvalidPriority
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.StringSliceFlag{
+                                Name:  "allow-cidr",
+                                Usage: "a range (e.g. 203.0.113.0/24 or 2001:db8::/32) allowed to reach the listener ports, anywhere is allowed by default",
+                                Validator: func(cidrs []string) error {
+                                    _, err := service.ParseAllowCIDRs(cidrs, false)
+
+                                    return err
+                                },
+                            },
+                            &cli.BoolFlag{
+                                Name:  "ipv6",
+                                Usage: "without --allow-cidr, allow ipv6 clients (::/0) to reach the listener ports too (implied by --ip-address-type dualstack)",
+                            },
+                            &cli.StringSliceFlag{
+                                Name:  "tag",
+                                Usage: "a key=value tag put on every resource of the service, on top of the ones awsum puts",
+                                Validator: func(tags []string) error {
+                                    _, err := service.ParseTags(tags)
+
+                                    return err
+                                },
+                            },
+                            &cli.StringFlag{
+                                Name:     "ip-address-type",
+                                Usage:    "the ip address type of the load balancer, ipv4 or dualstack (AAAA records are added for domains). a new load balancer is ipv4 by default, an existing one is left as it is.",
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    _, err := service.ParseIpAddressType(s)
+
+                                    return err
+                                },
+                            },
+                            &cli.StringFlag{
+                                Name:     "health-path",
+                                Usage:    "the path of the health check requests made to each instance (e.g. /healthz)",
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    if !strings.HasPrefix(s, "/") {
+                                        return service.ErrInvalidHealthPath
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.StringFlag{
+                                Name:     "health-port",
+                                Usage:    "the port health checks are made on, a port number or traffic-port",
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    if s == "traffic-port" {
+                                        return nil
+                                    }
+
+                                    if port, err := strconv.Atoi(s); err != nil || port < 1 || port > 65535 {
+                                        return service.ErrInvalidHealthPort
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.StringFlag{
+                                Name:     "health-protocol",
+                                Usage:    "the protocol health checks are made with, http|https|tcp",
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    if !slices.Contains([]string{"http", "https", "tcp"}, strings.ToLower(s)) {
+                                        return service.ErrInvalidHealthProtocol
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.DurationFlag{
+                                Name:     "health-interval",
+                                Usage:    "the time between health checks of each instance, in whole seconds from 5s to 300s",
+                                OnlyOnce: true,
+                                Validator: func(d time.Duration) error {
+                                    if d < time.Second*5 || d > time.Second*300 || d%time.Second != 0 {
+                                        return service.ErrInvalidHealthInterval
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.IntFlag{
+                                Name:     "healthy-threshold",
+                                Usage:    "the number of consecutive successful health checks before an instance is considered healthy (2-10)",
+                                OnlyOnce: true,
+                                Validator: func(i int) error {
+                                    if i < 2 || i > 10 {
+                                        return service.ErrInvalidHealthy
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.IntFlag{
+                                Name:     "unhealthy-threshold",
+                                Usage:    "the number of consecutive failed health checks before an instance is considered unhealthy (2-10)",
+                                OnlyOnce: true,
+                                Validator: func(i int) error {
+                                    if i < 2 || i > 10 {
+                                        return service.ErrInvalidUnhealthy
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.StringFlag{
+                                Name:     "matcher",
+                                Usage:    "the http status codes of a healthy response (e.g. 200, 200,204 or 200-299)",
+                                OnlyOnce: true,
+                            },
+                            &cli.StringFlag{
+                                Name:     "stickiness",
+                                Usage:    "keep sending a client to the same instance, by lb_cookie or app_cookie (application load balancers) or source_ip (network load balancers). none turns it off.",
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    if !slices.Contains(service.StickinessTypes, s) {
+                                        return service.ErrInvalidStickiness
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.DurationFlag{
+                                Name:     "stickiness-duration",
+                                Usage:    "with --stickiness lb_cookie or app_cookie, how long a client sticks to an instance (e.g. 1h)",
+                                OnlyOnce: true,
+                            },
+                            &cli.StringFlag{
+                                Name:     "stickiness-cookie",
+                                Usage:    "with --stickiness app_cookie, the name of the application cookie clients stick by",
+                                OnlyOnce: true,
+                            },
+                            &cli.DurationFlag{
+                                Name:     "deregistration-delay",
+                                Usage:    "how long removed instances are drained for before being deregistered (e.g. 30s, 0s to not wait)",
+                                OnlyOnce: true,
+                            },
+                            &cli.DurationFlag{
+                                Name:     "slow-start",
+                                Usage:    "how long new instances are given to warm up, receiving a growing share of requests (30s to 15m, 0s turns it off)",
+                                OnlyOnce: true,
+                            },
+                            &cli.StringFlag{
+                                Name:     "algorithm",
+                                Usage:    "how requests are spread over the instances: round_robin, least_outstanding_requests or weighted_random",
+                                OnlyOnce: true,
+                                Validator: func(s string) error {
+                                    if !slices.Contains(service.LoadBalancingAlgorithms, s) {
+                                        return service.ErrInvalidLoadBalancingAlgorithm
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.DurationFlag{
+                                Name:     "idle-timeout",
+                                Usage:    "how long the load balancer keeps idle connections open (e.g. 2m)",
+                                OnlyOnce: true,
+                            },
+                            &cli.BoolFlag{
+                                Name:     "http2",
+                                Usage:    "whether the load balancer accepts http/2 requests, --http2=false turns it off",
+                                OnlyOnce: true,
+                            },
+                            &cli.BoolFlag{
+                                Name:     "deletion-protection",
+                                Usage:    "keep the load balancer from being deleted, --deletion-protection=false allows it again",
+                                OnlyOnce: true,
+                            },
+                            &cli.StringFlag{
+                                Name:     "access-logs",
+                                Usage:    "the s3 bucket, optionally followed by /prefix, access logs are written to. off turns them off.",
+                                OnlyOnce: true,
+                            },
+                            &cli.BoolFlag{
+                                Name:     "wait-healthy",
+                                Usage:    "wait for the service's targets to become healthy and probe its url before exiting",
+                                OnlyOnce: true,
+                            },
+                            &cli.IntFlag{
+                                Name:     "min-healthy",
+                                Usage:    "with --wait-healthy, the number of healthy targets to wait for. 0 means all of them.",
+                                OnlyOnce: true,
+                                Validator: func(i int) error {
+                                    if i < 0 {
+                                        return errors.New("min healthy must not be negative")
+                                    }
+
+                                    return nil
+                                },
+                            },
+                            &cli.BoolFlag{
+                                Name:     "plan",
+                                Usage:    "only print the changes that would be made, without making them",
+                                OnlyOnce: true,
+                            },
+                            &cli.BoolFlag{
+                                Name:     "apply",
+                                Usage:    "print the changes that will be made and ask for confirmation before making them",
+                                OnlyOnce: true,
+                            },
+                            &cli.BoolFlag{
+                                Name:     "yes",
+                                Aliases:  []string{"y"},
+                                Usage:    "don't ask for confirmation with --apply",
+                                OnlyOnce: true,
+                            },
                         },
                         Action: func(ctx context.Context, command *cli.Command) error {
-                            portParts := strings.Split(command.String("port"), ":")
+                            if command.Bool("plan") && command.Bool("apply") {
+                                return errors.New("--plan and --apply cannot be used together")
+                            }
 
-                            lbPort, err := strconv.ParseInt(portParts[0], 10, 32)
+                            definitions := command.StringSlice("listener")
+
+                            if command.IsSet("port") && len(definitions) > 0 {
+                                return errors.New("--port and --listener cannot be used together")
+                            }
+
+                            if len(definitions) == 0 {
+                                if !command.IsSet("port") {
+                                    return errors.New("either --port or --listener is required")
+                                }
+
+                                portParts := strings.Split(command.String("port"), ":")
+                                protocolParts := strings.Split(command.String("protocol"), ":")
+
+                                definitions = []string{strings.Join([]string{
+                                    portParts[0],
+                                    portParts[1],
+                                    protocolParts[0],
+                                    protocolParts[1],
+                                }, ":")}
+                            }
+
+                            listeners, trafficPort, trafficProtocol, err := service.ParseListenerDefinitions(
+                                definitions,
+                                command.Bool("redirect-http"),
+                            )
+
+                            if err != nil {
+                                return err
+                            }
+
+                            lbType, err := service.ParseLoadBalancerType(command.String("lb-type"))
+
+                            if err != nil {
+                                return err
+                            }
+
+                            ipAddressType, err := service.ParseIpAddressType(command.String("ip-address-type"))
 
                             if err != nil {
                                 return err
                             }
 
-                            trafficPort, err := strconv.ParseInt(portParts[1], 10, 32)
+                            tags, err := service.ParseTags(command.StringSlice("tag"))
 
                             if err != nil {
                                 return err
                             }
 
-                            protocolParts := strings.Split(command.String("protocol"), ":")
+                            targetGroupAttributes := service.TargetGroupAttributes{
+                                Stickiness:         command.String("stickiness"),
+                                StickinessDuration: command.Duration("stickiness-duration"),
+                                StickinessCookie:   command.String("stickiness-cookie"),
+                                Algorithm:          command.String("algorithm"),
+                            }
+
+                            if command.IsSet("deregistration-delay") {
+                                targetGroupAttributes.DeregistrationDelay = memory.Pointer(command.Duration("deregistration-delay"))
+                            }
+
+                            if command.IsSet("slow-start") {
+                                targetGroupAttributes.SlowStart = memory.Pointer(command.Duration("slow-start"))
+                            }
+
+                            loadBalancerAttributes := service.LoadBalancerAttributes{
+                                IdleTimeout: command.Duration("idle-timeout"),
+                                AccessLogs:  command.String("access-logs"),
+                            }
+
+                            if command.IsSet("http2") {
+                                loadBalancerAttributes.HTTP2 = memory.Pointer(command.Bool("http2"))
+                            }
+
+                            if command.IsSet("deletion-protection") {
+                                loadBalancerAttributes.DeletionProtection = memory.Pointer(command.Bool("deletion-protection"))
+                            }
 
                             return commands.InstanceLoadBalance(commands.InstanceLoadBalanceOptions{
                                 Ctx:         ctx,
@@ -230,14 +1150,40 @@ func main() {
                                 InstanceFilters: service.InstanceFilters{
                                     Name: command.String("name"),
                                 },
-                                LoadBalancerPort:             int32(lbPort),
-                                LoadBalancerIpProtocol:       strings.ToLower(command.String("ip-protocol")),
-                                LoadBalancerListenerProtocol: types.ProtocolEnum(strings.ToUpper(protocolParts[0])),
-                                TrafficPort:                  int32(trafficPort),
-                                TrafficProtocol:              types.ProtocolEnum(strings.ToUpper(protocolParts[1])),
-                                CertificateNames:             command.StringSlice("certificate"),
-                                DomainNames:                  command.StringSlice("domain"),
-                                Private:                      command.Bool("private"),
+                                Listeners:              listeners,
+                                LoadBalancerIpProtocol: strings.ToLower(command.String("ip-protocol")),
+                                TrafficPort:            trafficPort,
+                                TrafficProtocol:        trafficProtocol,
+                                CertificateNames:       command.StringSlice("certificate"),
+                                DomainNames:            command.StringSlice("domain"),
+                                Private:                command.Bool("private"),
+                                Canary:                 int32(command.Int("canary")),
+                                LoadBalancerType:       lbType,
+                                AlpnPolicy:             command.String("alpn-policy"),
+                                SharedLoadBalancer:     command.String("shared-lb"),
+                                Hosts:                  command.StringSlice("host"),
+                                Paths:                  command.StringSlice("path"),
+                                Priority:               int32(command.Int("priority")),
+                                AllowCIDRs:             command.StringSlice("allow-cidr"),
+                                IPv6:                   command.Bool("ipv6"),
+                                IpAddressType:          ipAddressType,
+                                TargetGroupAttributes:  targetGroupAttributes,
+                                LoadBalancerAttributes: loadBalancerAttributes,
+                                Tags:                   tags,
+                                HealthCheck: service.HealthCheckOptions{
+                                    Path:               command.String("health-path"),
+                                    Port:               command.String("health-port"),
+                                    Protocol:           types.ProtocolEnum(strings.ToUpper(command.String("health-protocol"))),
+                                    Interval:           command.Duration("health-interval"),
+                                    HealthyThreshold:   int32(command.Int("healthy-threshold")),
+                                    UnhealthyThreshold: int32(command.Int("unhealthy-threshold")),
+                                    Matcher:            command.String("matcher"),
+                                },
+                                WaitHealthy: command.Bool("wait-healthy") || command.IsSet("min-healthy"),
+                                MinHealthy:  command.Int("min-healthy"),
+                                Plan:        command.Bool("plan"),
+                                Apply:       command.Bool("apply"),
+                                Yes:         command.Bool("yes"),
                             })
                         },
                     },
@@ -247,6 +1193,12 @@ func main() {
     }
 
     if err := cmd.Run(app.Ctx, os.Args); err != nil {
+        if errors.Is(err, service.ErrDriftDetected) {
+            fmt.Println()
+            fmt.Println(err)
+            os.Exit(driftExitCode)
+        }
+
         fmt.Printf("failed to run command: %s\n", err)
         os.Exit(1)
     }
@@ -86,6 +86,23 @@ func (svc *ACM) GenerateLoadBalanceCertificateListFromCertificateNames(
     return certs, nil
 }
 
+// GetCertificateDomainNames returns the domain name of every certificate, keyed by certificate arn.
+func (svc *ACM) GetCertificateDomainNames(ctx context.Context) (map[string]string, error) {
+    summaries, err := svc.getAllCertificateSummaries(ctx)
+
+    if err != nil {
+        return nil, err
+    }
+
+    domainNames := make(map[string]string)
+
+    for _, summary := range summaries {
+        domainNames[memory.Unwrap(summary.CertificateArn)] = memory.Unwrap(summary.DomainName)
+    }
+
+    return domainNames, nil
+}
+
 func NewACM(awsConfig aws.Config) *ACM {
     return &ACM{
         client: acm.NewFromConfig(awsConfig),
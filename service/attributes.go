@@ -0,0 +1,309 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+var (
+    ErrInvalidStickiness             = errors.New("stickiness must be lb_cookie, app_cookie, source_ip or none")
+    ErrInvalidLoadBalancingAlgorithm = errors.New("load balancing algorithm must be round_robin, least_outstanding_requests or weighted_random")
+    ErrAppCookieStickiness           = errors.New("app_cookie stickiness needs a cookie name, which only it uses")
+    ErrStickinessDuration            = errors.New("a stickiness duration needs lb_cookie or app_cookie stickiness")
+    ErrAttributeUnsupported          = errors.New("attribute isn't supported by the load balancer type")
+    ErrAccessLogsNeedBucket          = errors.New("access logs need an s3 bucket, like my-bucket or my-bucket/prefix")
+    ErrGatewayLoadBalancerAttributes = errors.New("a gateway load balancer only supports the deregistration delay and deletion protection attributes")
+)
+
+// StickinessTypes are the kinds of session stickiness a target group can have, none turns stickiness off.
+var StickinessTypes = []string{"lb_cookie", "app_cookie", "source_ip", "none"}
+
+// LoadBalancingAlgorithms are the ways an application load balancer's target group can pick a target for a request.
+var LoadBalancingAlgorithms = []string{"round_robin", "least_outstanding_requests", "weighted_random"}
+
+// TargetGroupAttributes are the target group attributes awsum manages. Like HealthCheckOptions, zero values are left
+// as they are (or to the aws defaults for a new target group).
+type TargetGroupAttributes struct {
+    // Stickiness is one of StickinessTypes, lb_cookie and app_cookie for application load balancers and source_ip for
+    // network ones.
+    Stickiness         string        `json:"stickiness,omitempty"`
+    StickinessDuration time.Duration `json:"stickiness_duration,omitempty"`
+    // StickinessCookie is the name of the application's own cookie, with app_cookie stickiness.
+    StickinessCookie string `json:"stickiness_cookie,omitempty"`
+    // DeregistrationDelay and SlowStart are pointers since zero turns them off.
+    DeregistrationDelay *time.Duration `json:"deregistration_delay,omitempty"`
+    SlowStart           *time.Duration `json:"slow_start,omitempty"`
+    // Algorithm is one of LoadBalancingAlgorithms.
+    Algorithm string `json:"algorithm,omitempty"`
+}
+
+// LoadBalancerAttributes are the load balancer attributes awsum manages, zero values are left as they are.
+type LoadBalancerAttributes struct {
+    IdleTimeout        time.Duration `json:"idle_timeout,omitempty"`
+    HTTP2              *bool         `json:"http2,omitempty"`
+    DeletionProtection *bool         `json:"deletion_protection,omitempty"`
+    // AccessLogs is the s3 bucket, optionally followed by a /prefix, access logs are written to. off turns them off.
+    AccessLogs string `json:"access_logs,omitempty"`
+}
+
+// attribute is a single target group or load balancer attribute.
+type attribute struct {
+    key   string
+    value string
+}
+
+func seconds(d time.Duration) string {
+    return strconv.Itoa(int(d.Seconds()))
+}
+
+func (a TargetGroupAttributes) attributes() []attribute {
+    var attributes []attribute
+
+    switch a.Stickiness {
+    case "":
+    case "none":
+        attributes = append(attributes, attribute{"stickiness.enabled", "false"})
+    default:
+        attributes = append(attributes, attribute{"stickiness.enabled", "true"}, attribute{"stickiness.type", a.Stickiness})
+    }
+
+    if a.StickinessDuration > 0 {
+        attributes = append(attributes, attribute{fmt.Sprintf("stickiness.%s.duration_seconds", a.Stickiness), seconds(a.StickinessDuration)})
+    }
+
+    if len(a.StickinessCookie) > 0 {
+        attributes = append(attributes, attribute{"stickiness.app_cookie.cookie_name", a.StickinessCookie})
+    }
+
+    if a.DeregistrationDelay != nil {
+        attributes = append(attributes, attribute{"deregistration_delay.timeout_seconds", seconds(*a.DeregistrationDelay)})
+    }
+
+    if a.SlowStart != nil {
+        attributes = append(attributes, attribute{"slow_start.duration_seconds", seconds(*a.SlowStart)})
+    }
+
+    if len(a.Algorithm) > 0 {
+        attributes = append(attributes, attribute{"load_balancing.algorithm.type", a.Algorithm})
+    }
+
+    return attributes
+}
+
+func (a LoadBalancerAttributes) attributes() []attribute {
+    var attributes []attribute
+
+    if a.IdleTimeout > 0 {
+        attributes = append(attributes, attribute{"idle_timeout.timeout_seconds", seconds(a.IdleTimeout)})
+    }
+
+    if a.HTTP2 != nil {
+        attributes = append(attributes, attribute{"routing.http2.enabled", strconv.FormatBool(*a.HTTP2)})
+    }
+
+    if a.DeletionProtection != nil {
+        attributes = append(attributes, attribute{"deletion_protection.enabled", strconv.FormatBool(*a.DeletionProtection)})
+    }
+
+    switch a.AccessLogs {
+    case "":
+    case "off":
+        attributes = append(attributes, attribute{"access_logs.s3.enabled", "false"})
+    default:
+        bucket, prefix, _ := strings.Cut(a.AccessLogs, "/")
+
+        attributes = append(
+            attributes,
+            attribute{"access_logs.s3.enabled", "true"},
+            attribute{"access_logs.s3.bucket", bucket},
+            attribute{"access_logs.s3.prefix", strings.Trim(prefix, "/")},
+        )
+    }
+
+    return attributes
+}
+
+// Validate checks the target group attributes are possible behind the type of load balancer.
+func (a TargetGroupAttributes) Validate(lbType types.LoadBalancerTypeEnum) error {
+    if len(a.Stickiness) > 0 && !slices.Contains(StickinessTypes, a.Stickiness) {
+        return fmt.Errorf("%w, got '%s'", ErrInvalidStickiness, a.Stickiness)
+    }
+
+    if len(a.Algorithm) > 0 && !slices.Contains(LoadBalancingAlgorithms, a.Algorithm) {
+        return fmt.Errorf("%w, got '%s'", ErrInvalidLoadBalancingAlgorithm, a.Algorithm)
+    }
+
+    if (a.Stickiness == "app_cookie") != (len(a.StickinessCookie) > 0) {
+        return ErrAppCookieStickiness
+    }
+
+    if a.StickinessDuration > 0 && a.Stickiness != "lb_cookie" && a.Stickiness != "app_cookie" {
+        return ErrStickinessDuration
+    }
+
+    if lbType == types.LoadBalancerTypeEnumGateway &&
+        (len(a.Stickiness) > 0 || a.SlowStart != nil || len(a.Algorithm) > 0) {
+        return ErrGatewayLoadBalancerAttributes
+    }
+
+    var (
+        applicationOnly = a.Stickiness == "lb_cookie" || a.Stickiness == "app_cookie" || a.SlowStart != nil || len(a.Algorithm) > 0
+        networkOnly     = a.Stickiness == "source_ip"
+    )
+
+    if applicationOnly && lbType != types.LoadBalancerTypeEnumApplication ||
+        networkOnly && lbType != types.LoadBalancerTypeEnumNetwork {
+        return fmt.Errorf("%w, target group attributes on a %s load balancer", ErrAttributeUnsupported, lbType)
+    }
+
+    return nil
+}
+
+// Validate checks the attributes are possible on the type of load balancer.
+func (a LoadBalancerAttributes) Validate(lbType types.LoadBalancerTypeEnum) error {
+    if bucket, _, _ := strings.Cut(a.AccessLogs, "/"); len(a.AccessLogs) > 0 && len(bucket) == 0 {
+        return ErrAccessLogsNeedBucket
+    }
+
+    if lbType == types.LoadBalancerTypeEnumGateway && (a.IdleTimeout > 0 || a.HTTP2 != nil || len(a.AccessLogs) > 0) {
+        return ErrGatewayLoadBalancerAttributes
+    }
+
+    if lbType != types.LoadBalancerTypeEnumApplication && (a.IdleTimeout > 0 || a.HTTP2 != nil) {
+        return fmt.Errorf("%w, idle timeout and http2 on a %s load balancer", ErrAttributeUnsupported, lbType)
+    }
+
+    return nil
+}
+
+// describeAttributes returns a line for each attribute.
+func describeAttributes(attributes []attribute) []string {
+    var lines []string
+
+    for _, attribute := range attributes {
+        lines = append(lines, fmt.Sprintf("%s: %s", attribute.key, attribute.value))
+    }
+
+    return lines
+}
+
+// attributeDifferences returns the changes needed for the current attributes to match, along with the attributes to
+// set to make them.
+func attributeDifferences(attributes []attribute, current map[string]string) ([]string, []attribute) {
+    var (
+        changes []string
+        changed []attribute
+    )
+
+    for _, attribute := range attributes {
+        if value := current[attribute.key]; value != attribute.value {
+            changes = append(changes, fmt.Sprintf("%s: %s -> %s", attribute.key, orNone(value), attribute.value))
+            changed = append(changed, attribute)
+        }
+    }
+
+    return changes, changed
+}
+
+func (svc *AwsumILBService) modifyTargetGroupAttributes(ctx context.Context, targetGroupArn string, attributes []attribute) error {
+    if len(attributes) == 0 {
+        return nil
+    }
+
+    input := &elbv2.ModifyTargetGroupAttributesInput{TargetGroupArn: memory.Pointer(targetGroupArn)}
+
+    for _, attribute := range attributes {
+        input.Attributes = append(input.Attributes, types.TargetGroupAttribute{
+            Key:   memory.Pointer(attribute.key),
+            Value: memory.Pointer(attribute.value),
+        })
+    }
+
+    _, err := svc.ELBv2.Client().ModifyTargetGroupAttributes(ctx, input)
+
+    return err
+}
+
+func (svc *AwsumILBService) modifyLoadBalancerAttributes(ctx context.Context, loadBalancerArn string, attributes []attribute) error {
+    if len(attributes) == 0 {
+        return nil
+    }
+
+    input := &elbv2.ModifyLoadBalancerAttributesInput{LoadBalancerArn: memory.Pointer(loadBalancerArn)}
+
+    for _, attribute := range attributes {
+        input.Attributes = append(input.Attributes, types.LoadBalancerAttribute{
+            Key:   memory.Pointer(attribute.key),
+            Value: memory.Pointer(attribute.value),
+        })
+    }
+
+    _, err := svc.ELBv2.Client().ModifyLoadBalancerAttributes(ctx, input)
+
+    return err
+}
+
+// planTargetGroupAttributes makes the attributes of the existing target group match the options.
+func (svc *AwsumILBService) planTargetGroupAttributes(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    name string,
+    targetGroupArn string,
+) error {
+    attributes := opts.TargetGroupAttributes.attributes()
+
+    if len(attributes) == 0 {
+        return nil
+    }
+
+    current, err := svc.ELBv2.GetTargetGroupAttributes(opts.Ctx, targetGroupArn)
+
+    if err != nil {
+        return err
+    }
+
+    if changes, changed := attributeDifferences(attributes, current); len(changes) > 0 {
+        plan.add(PlanActionModify, "target group attributes", name, func(ctx context.Context, _ *ILBServiceResources) error {
+            return svc.modifyTargetGroupAttributes(ctx, targetGroupArn, changed)
+        }, changes...)
+    }
+
+    return nil
+}
+
+// planLoadBalancerAttributes makes the attributes of the existing load balancer match the options.
+func (svc *AwsumILBService) planLoadBalancerAttributes(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    name string,
+    loadBalancerArn string,
+) error {
+    attributes := opts.LoadBalancerAttributes.attributes()
+
+    if len(attributes) == 0 {
+        return nil
+    }
+
+    current, err := svc.ELBv2.GetLoadBalancerAttributes(opts.Ctx, loadBalancerArn)
+
+    if err != nil {
+        return err
+    }
+
+    if changes, changed := attributeDifferences(attributes, current); len(changes) > 0 {
+        plan.add(PlanActionModify, "load balancer attributes", name, func(ctx context.Context, _ *ILBServiceResources) error {
+            return svc.modifyLoadBalancerAttributes(ctx, loadBalancerArn, changed)
+        }, changes...)
+    }
+
+    return nil
+}
@@ -0,0 +1,56 @@
+package service_test
+
+import (
+    "testing"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/internal/memory"
+    "github.com/levelshatter/awsum/service"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestTargetGroupAttributesValidate(t *testing.T) {
+    tests := []struct {
+        attributes service.TargetGroupAttributes
+        lbType     types.LoadBalancerTypeEnum
+        err        error
+    }{
+        {service.TargetGroupAttributes{}, types.LoadBalancerTypeEnumGateway, nil},
+        {service.TargetGroupAttributes{Stickiness: "lb_cookie", StickinessDuration: time.Hour}, types.LoadBalancerTypeEnumApplication, nil},
+        {service.TargetGroupAttributes{Stickiness: "app_cookie", StickinessCookie: "session"}, types.LoadBalancerTypeEnumApplication, nil},
+        {service.TargetGroupAttributes{Stickiness: "source_ip"}, types.LoadBalancerTypeEnumNetwork, nil},
+        {service.TargetGroupAttributes{DeregistrationDelay: memory.Pointer(time.Duration(0))}, types.LoadBalancerTypeEnumNetwork, nil},
+        {service.TargetGroupAttributes{Stickiness: "cookie"}, types.LoadBalancerTypeEnumApplication, service.ErrInvalidStickiness},
+        {service.TargetGroupAttributes{Algorithm: "random"}, types.LoadBalancerTypeEnumApplication, service.ErrInvalidLoadBalancingAlgorithm},
+        {service.TargetGroupAttributes{Stickiness: "app_cookie"}, types.LoadBalancerTypeEnumApplication, service.ErrAppCookieStickiness},
+        {service.TargetGroupAttributes{StickinessCookie: "session"}, types.LoadBalancerTypeEnumApplication, service.ErrAppCookieStickiness},
+        {service.TargetGroupAttributes{StickinessDuration: time.Hour}, types.LoadBalancerTypeEnumApplication, service.ErrStickinessDuration},
+        {service.TargetGroupAttributes{Stickiness: "source_ip"}, types.LoadBalancerTypeEnumApplication, service.ErrAttributeUnsupported},
+        {service.TargetGroupAttributes{SlowStart: memory.Pointer(time.Minute)}, types.LoadBalancerTypeEnumNetwork, service.ErrAttributeUnsupported},
+        {service.TargetGroupAttributes{Algorithm: "round_robin"}, types.LoadBalancerTypeEnumGateway, service.ErrGatewayLoadBalancerAttributes},
+    }
+
+    for _, test := range tests {
+        assert.ErrorIs(t, test.attributes.Validate(test.lbType), test.err, "attributes: %+v", test.attributes)
+    }
+}
+
+func TestLoadBalancerAttributesValidate(t *testing.T) {
+    tests := []struct {
+        attributes service.LoadBalancerAttributes
+        lbType     types.LoadBalancerTypeEnum
+        err        error
+    }{
+        {service.LoadBalancerAttributes{IdleTimeout: time.Minute, HTTP2: memory.Pointer(false)}, types.LoadBalancerTypeEnumApplication, nil},
+        {service.LoadBalancerAttributes{AccessLogs: "my-bucket/lb"}, types.LoadBalancerTypeEnumNetwork, nil},
+        {service.LoadBalancerAttributes{DeletionProtection: memory.Pointer(true)}, types.LoadBalancerTypeEnumGateway, nil},
+        {service.LoadBalancerAttributes{AccessLogs: "/lb"}, types.LoadBalancerTypeEnumApplication, service.ErrAccessLogsNeedBucket},
+        {service.LoadBalancerAttributes{IdleTimeout: time.Minute}, types.LoadBalancerTypeEnumNetwork, service.ErrAttributeUnsupported},
+        {service.LoadBalancerAttributes{AccessLogs: "my-bucket"}, types.LoadBalancerTypeEnumGateway, service.ErrGatewayLoadBalancerAttributes},
+    }
+
+    for _, test := range tests {
+        assert.ErrorIs(t, test.attributes.Validate(test.lbType), test.err, "attributes: %+v", test.attributes)
+    }
+}
@@ -0,0 +1,520 @@
+package service
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "os"
+    "sync"
+    "sync/atomic"
+
+    "github.com/levelshatter/awsum/internal/console"
+    "golang.org/x/crypto/ssh"
+    "golang.org/x/term"
+)
+
+const (
+    // broadcastEscapeKey (ctrl-]) starts a broadcast shell key binding, the key after it selects the action.
+    broadcastEscapeKey = 0x1d
+    // broadcastMaxHeldOutput is the maximum amount of output held back per instance while another one is focused.
+    broadcastMaxHeldOutput = 1024 * 1024
+)
+
+var (
+    ErrNoBroadcastSessions = errors.New("no broadcast shell sessions could be started")
+)
+
+var broadcastTagColors = []string{"36", "33", "35", "32", "34", "31"}
+
+type broadcastHost struct {
+    instance  *Instance
+    number    int
+    tag       string
+    client    *ssh.Client
+    session   *ssh.Session
+    stdin     io.Writer
+    recording *sessionRecording
+    lost      <-chan struct{}
+    held      bytes.Buffer
+    done      bool
+}
+
+// broadcastTerminal multiplexes the output of every broadcast session onto the local terminal, either tagged with the
+// name of the instance it came from or, while an instance is focused, only the raw output of the focused instance.
+type broadcastTerminal struct {
+    mu          sync.Mutex
+    hosts       []*broadcastHost
+    focus       *broadcastHost
+    lastHost    *broadcastHost
+    atLineStart bool
+}
+
+// writeTagged writes the output of the host, prefixing every line with the host's tag. Must be called with t.mu held.
+func (t *broadcastTerminal) writeTagged(out *bytes.Buffer, host *broadcastHost, p []byte) {
+    for len(p) > 0 {
+        // another host's output was cut off mid-line, so start a new line for this host
+        if t.lastHost != host && !t.atLineStart {
+            out.WriteString("\r\n")
+            t.atLineStart = true
+        }
+
+        if t.atLineStart {
+            out.WriteString(host.tag)
+            t.atLineStart = false
+        }
+
+        t.lastHost = host
+
+        i := bytes.IndexByte(p, '\n')
+
+        if i == -1 {
+            out.Write(p)
+            break
+        }
+
+        out.Write(p[:i+1])
+        p = p[i+1:]
+        t.atLineStart = true
+    }
+}
+
+func (t *broadcastTerminal) write(host *broadcastHost, p []byte) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    var out bytes.Buffer
+
+    switch t.focus {
+    case nil:
+        t.writeTagged(&out, host, p)
+    case host:
+        out.Write(p)
+        t.lastHost = host
+        t.atLineStart = bytes.HasSuffix(p, []byte("\n"))
+    default:
+        if host.held.Len()+len(p) <= broadcastMaxHeldOutput {
+            host.held.Write(p)
+        }
+    }
+
+    _, _ = os.Stdout.Write(out.Bytes())
+}
+
+// notice prints a message from awsum itself on its own line. Must be called with t.mu held.
+func (t *broadcastTerminal) notice(format string, args ...any) {
+    prefix := ""
+
+    if !t.atLineStart {
+        prefix = "\r\n"
+    }
+
+    fmt.Printf("%s\x1b[1m--- %s ---\x1b[0m\r\n", prefix, fmt.Sprintf(format, args...))
+
+    t.atLineStart = true
+    t.lastHost = nil
+}
+
+func (t *broadcastTerminal) setFocus(host *broadcastHost) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    if host != nil {
+        if host.done {
+            t.notice("'%s' has already disconnected", host.instance.GetName())
+            return
+        }
+
+        t.focus = host
+        t.notice("focused on '%s', ctrl-] b to broadcast to all instances again", host.instance.GetName())
+
+        return
+    }
+
+    if t.focus == nil {
+        return
+    }
+
+    t.focus = nil
+    t.notice("broadcasting to all instances")
+
+    // catch up on whatever the other instances printed in the meantime
+    var out bytes.Buffer
+
+    for _, h := range t.hosts {
+        if h.held.Len() > 0 {
+            t.writeTagged(&out, h, h.held.Bytes())
+            h.held.Reset()
+        }
+    }
+
+    _, _ = os.Stdout.Write(out.Bytes())
+}
+
+func (t *broadcastTerminal) listHosts() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    t.notice("instances (ctrl-] then the number to focus one)")
+
+    for _, host := range t.hosts {
+        status := "connected"
+
+        if host.done {
+            status = "disconnected"
+        } else if host == t.focus {
+            status = "focused"
+        }
+
+        fmt.Printf("  %d) %s (%s)\r\n", host.number, host.instance.GetName(), status)
+    }
+}
+
+// targets returns the stdin of every session keystrokes should currently be sent to.
+func (t *broadcastTerminal) targets() []io.Writer {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    if t.focus != nil {
+        return []io.Writer{t.focus.stdin}
+    }
+
+    var writers []io.Writer
+
+    for _, host := range t.hosts {
+        if !host.done {
+            writers = append(writers, host.stdin)
+        }
+    }
+
+    return writers
+}
+
+// hostAt returns the host shown with the given number, or nil if there is none.
+func (t *broadcastTerminal) hostAt(number int) *broadcastHost {
+    if number < 1 || number > len(t.hosts) {
+        return nil
+    }
+
+    return t.hosts[number-1]
+}
+
+// nextHost returns the host after the focused one (or the first connected host when broadcasting).
+func (t *broadcastTerminal) nextHost() *broadcastHost {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    start := 0
+
+    if t.focus != nil {
+        start = t.focus.number
+    }
+
+    for i := range t.hosts {
+        if host := t.hosts[(start+i)%len(t.hosts)]; !host.done {
+            return host
+        }
+    }
+
+    return nil
+}
+
+type broadcastOutput struct {
+    terminal *broadcastTerminal
+    host     *broadcastHost
+}
+
+func (o broadcastOutput) Write(p []byte) (int, error) {
+    o.terminal.write(o.host, p)
+
+    if o.host.recording != nil {
+        _, _ = o.host.recording.Output().Write(p)
+    }
+
+    return len(p), nil
+}
+
+type BroadcastShellOptions struct {
+    Ctx       context.Context
+    User      string
+    KeepAlive KeepAliveOptions
+    Instances []*Instance
+    // Record writes an asciicast recording of each instance's session to the awsum data directory.
+    Record bool
+}
+
+// connectBroadcastHost opens an interactive shell session on the host's instance with its output going to the terminal.
+func connectBroadcastHost(
+    ctx context.Context,
+    opts BroadcastShellOptions,
+    terminal *broadcastTerminal,
+    host *broadcastHost,
+    width int,
+    height int,
+) error {
+    client, err := host.instance.DialSSH(ctx, opts.User)
+
+    if err != nil {
+        return fmt.Errorf("failed to create ssh client while connecting to instance: %w", err)
+    }
+
+    session, err := newSession(ctx, client)
+
+    if err != nil {
+        _ = client.Close()
+
+        return fmt.Errorf("failed to create ssh session while connecting to instance: %w", err)
+    }
+
+    stdin, err := session.StdinPipe()
+
+    if err != nil {
+        _ = client.Close()
+
+        return fmt.Errorf("failed to open ssh session stdin while connecting to instance: %w", err)
+    }
+
+    session.Stdout = broadcastOutput{terminal: terminal, host: host}
+    session.Stderr = session.Stdout
+
+    if err = requestShellPty(session, width, height); err != nil {
+        _ = client.Close()
+
+        return err
+    }
+
+    host.stdin = stdin
+
+    if opts.Record {
+        if host.recording, err = host.instance.newSessionRecording(width, height); err != nil {
+            _ = client.Close()
+
+            return err
+        }
+
+        host.stdin = io.MultiWriter(stdin, host.recording.Input())
+    }
+
+    if err = session.Shell(); err != nil {
+        _ = client.Close()
+
+        if host.recording != nil {
+            host.recording.discard()
+            host.recording = nil
+        }
+
+        return fmt.Errorf("failed to open shell to instance: %w", err)
+    }
+
+    host.client = client
+    host.session = session
+    host.lost = keepAlive(ctx, client, opts.KeepAlive)
+
+    return nil
+}
+
+// BroadcastShell opens interactive shells on all the given instances at once and mirrors the local terminal's input to
+// every one of them, printing their output tagged with the instance it came from (cluster-ssh style).
+//
+// Key bindings start with ctrl-]: a number focuses the instance with that number (input only goes to it and only its
+// output is shown), n focuses the next instance, b goes back to broadcasting, l lists the instances, q disconnects
+// from every instance and ctrl-] sends a literal ctrl-].
+func BroadcastShell(opts BroadcastShellOptions) error {
+    ctx, cancel := context.WithCancel(opts.Ctx)
+    defer cancel()
+
+    fd := int(os.Stdin.Fd())
+
+    if !term.IsTerminal(fd) {
+        return fmt.Errorf("broadcast shells require a terminal: %w", console.ErrNotATerminal)
+    }
+
+    width, height, err := term.GetSize(fd)
+
+    if err != nil {
+        width, height = 80, 24
+    }
+
+    terminal := &broadcastTerminal{atLineStart: true}
+
+    for i, instance := range opts.Instances {
+        terminal.hosts = append(terminal.hosts, &broadcastHost{
+            instance: instance,
+            number:   i + 1,
+            tag: fmt.Sprintf(
+                "\x1b[%sm[%s]\x1b[0m ",
+                broadcastTagColors[i%len(broadcastTagColors)],
+                instance.GetName(),
+            ),
+        })
+    }
+
+    var (
+        wg       sync.WaitGroup
+        mu       sync.Mutex
+        errs     []error
+        addError = func(host *broadcastHost, err error) {
+            mu.Lock()
+            defer mu.Unlock()
+
+            errs = append(errs, fmt.Errorf("'%s': %w", host.instance.GetName(), err))
+        }
+    )
+
+    for _, host := range terminal.hosts {
+        wg.Go(func() {
+            if err := connectBroadcastHost(ctx, opts, terminal, host, width, height); err != nil {
+                host.done = true
+                addError(host, err)
+            }
+        })
+    }
+
+    wg.Wait()
+
+    var connected []*broadcastHost
+
+    for _, host := range terminal.hosts {
+        if !host.done {
+            connected = append(connected, host)
+        }
+    }
+
+    if len(connected) == 0 {
+        return errors.Join(append([]error{ErrNoBroadcastSessions}, errs...)...)
+    }
+
+    for _, err := range errs {
+        fmt.Printf("failed to start broadcast shell: %s\n", err)
+    }
+
+    defer func() {
+        for _, host := range connected {
+            if host.recording != nil {
+                host.recording.close()
+            }
+
+            if err := host.client.Close(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+                fmt.Printf("failed to properly close ssh client connection to instance: %s\n", err)
+            }
+        }
+    }()
+
+    oldState, err := term.MakeRaw(fd)
+
+    if err == nil && oldState != nil {
+        defer func() {
+            if err := term.Restore(fd, oldState); err != nil {
+                fmt.Printf("failed to restore old local terminal state while disconnecting from instances: %s", err)
+            }
+        }()
+    }
+
+    terminal.mu.Lock()
+    terminal.notice(
+        "broadcasting to %d instance(s), ctrl-] then: 1-9 focus an instance, n next, b broadcast, l list, q quit",
+        len(connected),
+    )
+    terminal.mu.Unlock()
+
+    console.WatchSize(ctx, fd, func(width, height int) {
+        for _, host := range connected {
+            _ = host.session.WindowChange(height, width)
+
+            if host.recording != nil {
+                host.recording.Resize(width, height)
+            }
+        }
+    })
+
+    var quitting atomic.Bool
+
+    for _, host := range connected {
+        wg.Go(func() {
+            err := waitSession(ctx, host.session)
+
+            terminal.mu.Lock()
+            host.done = true
+
+            if terminal.focus == host {
+                terminal.focus = nil
+            }
+
+            terminal.notice("'%s' disconnected", host.instance.GetName())
+            terminal.mu.Unlock()
+
+            if err = shellSessionError(err, host.lost); err != nil && !quitting.Load() {
+                addError(host, err)
+            }
+        })
+    }
+
+    // stop reading input once every session has ended
+    go func() {
+        wg.Wait()
+        cancel()
+    }()
+
+    var (
+        stdin   = console.Stdin(ctx)
+        buf     = make([]byte, 4096)
+        escaped bool
+    )
+
+    for {
+        n, err := stdin.Read(buf)
+
+        if err != nil {
+            break
+        }
+
+        var input []byte
+
+        for _, b := range buf[:n] {
+            if !escaped {
+                if b == broadcastEscapeKey {
+                    escaped = true
+                } else {
+                    input = append(input, b)
+                }
+
+                continue
+            }
+
+            escaped = false
+
+            switch {
+            case b == broadcastEscapeKey:
+                input = append(input, b)
+            case b >= '1' && b <= '9':
+                if host := terminal.hostAt(int(b - '0')); host != nil {
+                    terminal.setFocus(host)
+                }
+            case b == 'n':
+                terminal.setFocus(terminal.nextHost())
+            case b == 'b':
+                terminal.setFocus(nil)
+            case b == 'l':
+                terminal.listHosts()
+            case b == 'q':
+                quitting.Store(true)
+
+                for _, host := range connected {
+                    _ = host.session.Close()
+                }
+            }
+        }
+
+        if len(input) > 0 {
+            for _, w := range terminal.targets() {
+                _, _ = w.Write(input)
+            }
+        }
+    }
+
+    wg.Wait()
+
+    return errors.Join(errs...)
+}
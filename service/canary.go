@@ -0,0 +1,434 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "slices"
+    "time"
+
+    elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+var (
+    ErrCanaryInProgress           = errors.New("service has a canary in progress, promote or roll it back first")
+    ErrCanaryNeedsExistingService = errors.New("a canary can only be added to a service that already exists")
+    ErrNoCanaryInstances          = errors.New("no newly matched instances to put in the canary")
+    ErrCanaryTargetGroupMismatch  = errors.New("canary target group doesn't match the service, roll the canary back first")
+    ErrNoCanary                   = errors.New("service has no canary")
+    ErrInvalidCanaryWeight        = errors.New("canary percentage must be from 1 to 99")
+    ErrInvalidCanaryStep          = errors.New("canary step must be from 1 to 100")
+)
+
+// CanaryResourceName returns the name of the service's canary target group.
+func (opts SetupNewILBServiceOptions) CanaryResourceName() string {
+    return AwsumILBCanaryResourceName(opts.ServiceName)
+}
+
+// AwsumILBCanaryResourceName returns the name of the target group holding the canary instances of the service.
+func AwsumILBCanaryResourceName(serviceName string) string {
+    return resourceName(fmt.Sprintf("awsum-ilb-svc-%s-canary", serviceName))
+}
+
+// canaryWeight returns the percentage of requests the actions forward to the canary target group.
+func canaryWeight(actions []types.Action, canaryTargetGroupArn string) (int32, bool) {
+    for _, targetGroup := range forwardTargetGroups(actions) {
+        if memory.Unwrap(targetGroup.TargetGroupArn) == canaryTargetGroupArn {
+            return memory.Unwrap(targetGroup.Weight), true
+        }
+    }
+
+    return 0, false
+}
+
+// planCanary plans the canary target group holding the newly matched instances, which the listeners then split
+// requests with.
+func (svc *AwsumILBService) planCanary(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    vpcId string,
+    canaryTargetGroup *types.TargetGroup,
+    targetInstances []*Instance,
+    newTargets []types.TargetDescription,
+    instances []*Instance,
+) error {
+    name := opts.CanaryResourceName()
+
+    if len(newTargets) == 0 {
+        return ErrNoCanaryInstances
+    }
+
+    canaryInstances := slices.DeleteFunc(slices.Clone(targetInstances), func(instance *Instance) bool {
+        return !slices.ContainsFunc(newTargets, func(target types.TargetDescription) bool {
+            return memory.Unwrap(target.Id) == memory.Unwrap(instance.Info.InstanceId)
+        })
+    })
+
+    var registered []types.TargetHealthDescription
+
+    if canaryTargetGroup != nil {
+        if differences := targetGroupDifferences(canaryTargetGroup, opts, vpcId); len(differences) > 0 {
+            return fmt.Errorf("%w (%v)", ErrCanaryTargetGroupMismatch, differences)
+        }
+
+        plan.Resources.CanaryTargetGroupArn = memory.Unwrap(canaryTargetGroup.TargetGroupArn)
+
+        if changes, input := opts.HealthCheck.differences(canaryTargetGroup); len(changes) > 0 {
+            plan.add(PlanActionModify, "target group", name, func(ctx context.Context, _ *ILBServiceResources) error {
+                _, err := svc.ELBv2.Client().ModifyTargetGroup(ctx, input)
+                return err
+            }, changes...)
+        }
+
+        if err := svc.planTargetGroupAttributes(opts, plan, name, plan.Resources.CanaryTargetGroupArn); err != nil {
+            return err
+        }
+
+        if err := svc.planELBTags(opts, plan, "target group", name, plan.Resources.CanaryTargetGroupArn, opts.serviceTags()); err != nil {
+            return err
+        }
+
+        var err error
+
+        if registered, err = svc.ELBv2.GetTargetHealth(opts.Ctx, plan.Resources.CanaryTargetGroupArn); err != nil {
+            return err
+        }
+    } else {
+        svc.planTargetGroupCreation(opts, plan, name, vpcId, func(resources *ILBServiceResources, targetGroupArn string) {
+            resources.CanaryTargetGroupArn = targetGroupArn
+        })
+    }
+
+    toRegister, toDeregister := diffTargets(opts, canaryInstances, registered)
+
+    if len(toRegister) > 0 {
+        plan.add(PlanActionCreate, "targets", name, func(ctx context.Context, resources *ILBServiceResources) error {
+            _, err := svc.ELBv2.Client().RegisterTargets(ctx, &elbv2.RegisterTargetsInput{
+                TargetGroupArn: memory.Pointer(resources.CanaryTargetGroupArn),
+                Targets:        toRegister,
+            })
+
+            return err
+        }, describeTargets(toRegister, instances)...)
+    }
+
+    if len(toDeregister) > 0 {
+        plan.add(PlanActionDelete, "targets", name, func(ctx context.Context, resources *ILBServiceResources) error {
+            return svc.ELBv2.DeregisterTargetsAndWait(ctx, resources.CanaryTargetGroupArn, toDeregister)
+        }, describeTargets(toDeregister, instances)...)
+    }
+
+    return nil
+}
+
+// canaryForwarder is a listener, or a listener rule, splitting requests between a service and its canary.
+type canaryForwarder struct {
+    listenerArn string
+    ruleArn     string
+    weight      int32
+}
+
+// serviceCanary is a service's canary, along with everything sending requests to it.
+type serviceCanary struct {
+    targetGroupArn       string
+    canaryTargetGroupArn string
+    forwarders           []canaryForwarder
+}
+
+func (svc *AwsumILBService) findServiceCanary(ctx context.Context, serviceName string) (*serviceCanary, error) {
+    recorded, err := svc.serviceState(ctx, serviceName)
+
+    if err != nil {
+        return nil, err
+    }
+
+    targetGroup, err := svc.findTargetGroup(ctx, recorded.TargetGroupArn, AwsumILBResourceName(serviceName))
+
+    if err != nil {
+        return nil, err
+    }
+
+    canaryTargetGroup, err := svc.findTargetGroup(ctx, recorded.CanaryTargetGroupArn, AwsumILBCanaryResourceName(serviceName))
+
+    if err != nil {
+        return nil, err
+    }
+
+    if targetGroup == nil || canaryTargetGroup == nil {
+        return nil, fmt.Errorf("%w '%s'", ErrNoCanary, serviceName)
+    }
+
+    canary := &serviceCanary{
+        targetGroupArn:       memory.Unwrap(targetGroup.TargetGroupArn),
+        canaryTargetGroupArn: memory.Unwrap(canaryTargetGroup.TargetGroupArn),
+    }
+
+    for _, loadBalancerArn := range canaryTargetGroup.LoadBalancerArns {
+        listeners, err := svc.ELBv2.GetAllListenersInLoadBalancer(ctx, loadBalancerArn)
+
+        if err != nil {
+            return nil, err
+        }
+
+        for _, listener := range listeners {
+            if weight, ok := canaryWeight(listener.DefaultActions, canary.canaryTargetGroupArn); ok {
+                canary.forwarders = append(canary.forwarders, canaryForwarder{
+                    listenerArn: memory.Unwrap(listener.ListenerArn),
+                    weight:      weight,
+                })
+
+                continue
+            }
+
+            rules, err := svc.ELBv2.GetAllRulesInListener(ctx, memory.Unwrap(listener.ListenerArn))
+
+            if err != nil {
+                return nil, err
+            }
+
+            for _, rule := range rules {
+                if weight, ok := canaryWeight(rule.Actions, canary.canaryTargetGroupArn); ok {
+                    canary.forwarders = append(canary.forwarders, canaryForwarder{
+                        ruleArn: memory.Unwrap(rule.RuleArn),
+                        weight:  weight,
+                    })
+                }
+            }
+        }
+    }
+
+    return canary, nil
+}
+
+func (svc *AwsumILBService) setForwarderActions(ctx context.Context, forwarder canaryForwarder, actions []types.Action) error {
+    var err error
+
+    if len(forwarder.ruleArn) > 0 {
+        _, err = svc.ELBv2.Client().ModifyRule(ctx, &elbv2.ModifyRuleInput{
+            RuleArn: memory.Pointer(forwarder.ruleArn),
+            Actions: actions,
+        })
+    } else {
+        _, err = svc.ELBv2.Client().ModifyListener(ctx, &elbv2.ModifyListenerInput{
+            ListenerArn:    memory.Pointer(forwarder.listenerArn),
+            DefaultActions: actions,
+        })
+    }
+
+    return err
+}
+
+type ShiftCanaryOptions struct {
+    Ctx         context.Context
+    ServiceName string
+    // Step is how many percent of requests are shifted at a time.
+    Step int32
+    // Interval is how long requests are left split after each step before the next, the target group gaining them
+    // having to stay healthy in the meantime.
+    Interval time.Duration
+    // OnStep is called after each step with the percentage of requests now going to the canary.
+    OnStep func(canaryWeight int32)
+}
+
+// shiftCanary gradually shifts requests until the given percentage of them go to the canary, waiting for every target
+// of the target group gaining requests to be healthy before each step.
+func (svc *AwsumILBService) shiftCanary(opts ShiftCanaryOptions, canary *serviceCanary, target int32) error {
+    if opts.Step < 1 || opts.Step > 100 {
+        return ErrInvalidCanaryStep
+    }
+
+    gaining := canary.canaryTargetGroupArn
+
+    if target == 0 {
+        gaining = canary.targetGroupArn
+    }
+
+    weight := target
+
+    // the listeners may have been left at different weights by an interrupted shift, start from the furthest behind
+    for _, forwarder := range canary.forwarders {
+        if (target == 100 && forwarder.weight < weight) || (target == 0 && forwarder.weight > weight) {
+            weight = forwarder.weight
+        }
+    }
+
+    for first := true; first || weight != target; first = false {
+        if !first {
+            select {
+            case <-opts.Ctx.Done():
+                return opts.Ctx.Err()
+            case <-time.After(opts.Interval):
+            }
+        }
+
+        if err := svc.ELBv2.WaitForHealthyTargets(WaitForHealthyTargetsOptions{
+            Ctx:            opts.Ctx,
+            TargetGroupArn: gaining,
+            Timeout:        TargetHealthyTimeout,
+        }); err != nil {
+            return fmt.Errorf("stopped shifting requests at %d%% to the canary: %w", weight, err)
+        }
+
+        if target == 100 {
+            weight = min(weight+opts.Step, 100)
+        } else {
+            weight = max(weight-opts.Step, 0)
+        }
+
+        for _, forwarder := range canary.forwarders {
+            if err := svc.setForwarderActions(
+                opts.Ctx,
+                forwarder,
+                weightedForwardActions(canary.targetGroupArn, canary.canaryTargetGroupArn, weight),
+            ); err != nil {
+                return err
+            }
+        }
+
+        if opts.OnStep != nil {
+            opts.OnStep(weight)
+        }
+    }
+
+    return nil
+}
+
+// finishCanary points every forwarder back at the service's own target group alone and deletes the canary target group,
+// which the state then no longer records.
+func (svc *AwsumILBService) finishCanary(ctx context.Context, serviceName string, canary *serviceCanary) error {
+    for _, forwarder := range canary.forwarders {
+        if err := svc.setForwarderActions(ctx, forwarder, forwardActions(canary.targetGroupArn)); err != nil {
+            return err
+        }
+    }
+
+    if _, err := svc.ELBv2.Client().DeleteTargetGroup(ctx, &elbv2.DeleteTargetGroupInput{
+        TargetGroupArn: memory.Pointer(canary.canaryTargetGroupArn),
+    }); err != nil {
+        return err
+    }
+
+    return svc.updateState(ctx, func(state *State) error {
+        if recorded, ok := state.Services[serviceName]; ok {
+            recorded.CanaryTargetGroupArn = ""
+
+            // the service is back to a single target group, as it would be if applied without a canary
+            if recorded.Inputs != nil {
+                recorded.Inputs.Canary = 0
+            }
+        }
+
+        return nil
+    })
+}
+
+// activeTargets returns the targets of the target group that aren't on their way out.
+func (svc *AwsumILBService) activeTargets(ctx context.Context, targetGroupArn string) ([]types.TargetDescription, error) {
+    health, err := svc.ELBv2.GetTargetHealth(ctx, targetGroupArn)
+
+    if err != nil {
+        return nil, err
+    }
+
+    var targets []types.TargetDescription
+
+    for _, description := range health {
+        if description.Target == nil {
+            continue
+        }
+
+        if description.TargetHealth != nil && description.TargetHealth.State == types.TargetHealthStateEnumDraining {
+            continue
+        }
+
+        targets = append(targets, *description.Target)
+    }
+
+    return targets, nil
+}
+
+// PromoteCanary gradually shifts every request of the service to its canary, then makes the canary instances the
+// service's only targets and removes the canary target group.
+func (svc *AwsumILBService) PromoteCanary(opts ShiftCanaryOptions) error {
+    canary, err := svc.findServiceCanary(opts.Ctx, opts.ServiceName)
+
+    if err != nil {
+        return err
+    }
+
+    if err = svc.shiftCanary(opts, canary, 100); err != nil {
+        return err
+    }
+
+    canaryTargets, err := svc.activeTargets(opts.Ctx, canary.canaryTargetGroupArn)
+
+    if err != nil {
+        return err
+    }
+
+    targets, err := svc.activeTargets(opts.Ctx, canary.targetGroupArn)
+
+    if err != nil {
+        return err
+    }
+
+    sameTarget := func(a types.TargetDescription) func(types.TargetDescription) bool {
+        return func(b types.TargetDescription) bool {
+            return memory.Unwrap(a.Id) == memory.Unwrap(b.Id) && memory.Unwrap(a.Port) == memory.Unwrap(b.Port)
+        }
+    }
+
+    var toRegister, toDeregister []types.TargetDescription
+
+    for _, target := range targets {
+        if !slices.ContainsFunc(canaryTargets, sameTarget(target)) {
+            toDeregister = append(toDeregister, target)
+        }
+    }
+
+    for _, target := range canaryTargets {
+        if !slices.ContainsFunc(targets, sameTarget(target)) {
+            toRegister = append(toRegister, target)
+        }
+    }
+
+    // the service's own target group gets no requests at this point, so its old targets can go straight away
+    if len(toDeregister) > 0 {
+        if err = svc.ELBv2.DeregisterTargetsAndWait(opts.Ctx, canary.targetGroupArn, toDeregister); err != nil {
+            return err
+        }
+    }
+
+    if len(toRegister) > 0 {
+        if _, err = svc.ELBv2.Client().RegisterTargets(opts.Ctx, &elbv2.RegisterTargetsInput{
+            TargetGroupArn: memory.Pointer(canary.targetGroupArn),
+            Targets:        toRegister,
+        }); err != nil {
+            return err
+        }
+
+        if err = svc.ELBv2.WaitForTargetsInService(opts.Ctx, canary.targetGroupArn, toRegister, TargetHealthyTimeout); err != nil {
+            return fmt.Errorf("canary targets did not become healthy in the service's target group: %w", err)
+        }
+    }
+
+    return svc.finishCanary(opts.Ctx, opts.ServiceName, canary)
+}
+
+// RollbackCanary gradually shifts every request of the service back to its own target group, then removes the canary
+// target group.
+func (svc *AwsumILBService) RollbackCanary(opts ShiftCanaryOptions) error {
+    canary, err := svc.findServiceCanary(opts.Ctx, opts.ServiceName)
+
+    if err != nil {
+        return err
+    }
+
+    if err = svc.shiftCanary(opts, canary, 0); err != nil {
+        return err
+    }
+
+    return svc.finishCanary(opts.Ctx, opts.ServiceName, canary)
+}
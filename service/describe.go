@@ -0,0 +1,472 @@
+package service
+
+import (
+    "context"
+    "fmt"
+    "slices"
+    "strings"
+
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+var (
+    // awsumILBResourcePrefix is what every resource name returned by AwsumILBResourceName starts with.
+    awsumILBResourcePrefix = AwsumILBResourceName("")
+    // awsumSharedLBResourcePrefix is what every resource name returned by AwsumSharedLBResourceName starts with.
+    awsumSharedLBResourcePrefix = AwsumSharedLBResourceName("")
+)
+
+type ILBServiceListener struct {
+    Port     int32
+    Protocol string
+    // Certificates holds the domain name of every certificate on the listener, the default one first.
+    Certificates []string
+    // Routes holds the hosts and paths the listener routes to the service, when it is on a shared load balancer.
+    Routes []string
+}
+
+type ILBServiceTarget struct {
+    InstanceId   string
+    InstanceName string
+    Port         int32
+    State        string
+    Reason       string
+    Description  string
+    // Canary is set for targets in the service's canary target group.
+    Canary bool
+}
+
+// ILBServiceDescription is what awsum reads back about a load-balanced service it created.
+type ILBServiceDescription struct {
+    Name string
+    // SharedLoadBalancer is the name of the shared load balancer the service is on, if it doesn't have its own.
+    SharedLoadBalancer  string
+    LoadBalancerArn     string
+    LoadBalancerDNSName string
+    Scheme              string
+    IpAddressType       string
+    State               string
+    SecurityGroupIds    []string
+    TargetGroupArn      string
+    // CanaryTargetGroupArn is set while the service has a canary, which gets CanaryWeight percent of its requests.
+    CanaryTargetGroupArn string
+    CanaryWeight         int32
+    Listeners            []ILBServiceListener
+    Targets              []ILBServiceTarget
+    Domains              []string
+}
+
+// TargetCounts returns how many of the service's targets are healthy, and how many aren't.
+func (d *ILBServiceDescription) TargetCounts() (int, int) {
+    var healthy, unhealthy int
+
+    for _, target := range d.Targets {
+        if target.State == string(types.TargetHealthStateEnumHealthy) {
+            healthy++
+        } else {
+            unhealthy++
+        }
+    }
+
+    return healthy, unhealthy
+}
+
+// Certificates returns the domain names of the certificates on every listener of the service, without duplicates.
+func (d *ILBServiceDescription) Certificates() []string {
+    var certs []string
+
+    for _, listener := range d.Listeners {
+        for _, cert := range listener.Certificates {
+            if !slices.Contains(certs, cert) {
+                certs = append(certs, cert)
+            }
+        }
+    }
+
+    return certs
+}
+
+// ilbServiceLookups holds everything shared between describing several services, so it is only fetched once.
+type ilbServiceLookups struct {
+    instanceNames      map[string]string
+    certificateDomains map[string]string
+    aliases            []HostedZoneRecord
+    // tags holds the tags of the load balancers and target groups being described, keyed by arn.
+    tags map[string]map[string]string
+}
+
+func (svc *AwsumILBService) newILBServiceLookups(ctx context.Context) (*ilbServiceLookups, error) {
+    lookups := &ilbServiceLookups{instanceNames: make(map[string]string)}
+
+    instances, err := svc.EC2.GetAllInstances(ctx)
+
+    if err != nil {
+        return nil, err
+    }
+
+    for _, instance := range instances {
+        lookups.instanceNames[memory.Unwrap(instance.Info.InstanceId)] = instance.GetName()
+    }
+
+    if lookups.certificateDomains, err = svc.ACM.GetCertificateDomainNames(ctx); err != nil {
+        return nil, err
+    }
+
+    if lookups.aliases, err = svc.Route53.GetAllAliasRecords(ctx); err != nil {
+        return nil, err
+    }
+
+    return lookups, nil
+}
+
+func (svc *AwsumILBService) describeILBService(
+    ctx context.Context,
+    serviceName string,
+    loadBalancer *types.LoadBalancer,
+    targetGroup *types.TargetGroup,
+    canaryTargetGroup *types.TargetGroup,
+    lookups *ilbServiceLookups,
+) (*ILBServiceDescription, error) {
+    description := &ILBServiceDescription{Name: serviceName}
+
+    if targetGroup != nil {
+        description.TargetGroupArn = memory.Unwrap(targetGroup.TargetGroupArn)
+    }
+
+    if canaryTargetGroup != nil {
+        description.CanaryTargetGroupArn = memory.Unwrap(canaryTargetGroup.TargetGroupArn)
+    }
+
+    if loadBalancer != nil {
+        description.LoadBalancerArn = memory.Unwrap(loadBalancer.LoadBalancerArn)
+        description.LoadBalancerDNSName = memory.Unwrap(loadBalancer.DNSName)
+        description.Scheme = string(loadBalancer.Scheme)
+        description.IpAddressType = string(loadBalancer.IpAddressType)
+        description.SecurityGroupIds = loadBalancer.SecurityGroups
+
+        if loadBalancer.State != nil {
+            description.State = string(loadBalancer.State.Code)
+        }
+
+        // the name of a shared load balancer may be cut short, its tag has it in full
+        if sharedLoadBalancer, ok := lookups.tags[description.LoadBalancerArn][SharedLoadBalancerTagKey]; ok {
+            description.SharedLoadBalancer = sharedLoadBalancer
+        } else if name := memory.Unwrap(loadBalancer.LoadBalancerName); strings.HasPrefix(name, awsumSharedLBResourcePrefix) {
+            description.SharedLoadBalancer = strings.TrimPrefix(name, awsumSharedLBResourcePrefix)
+        }
+    }
+
+    if len(description.LoadBalancerArn) > 0 {
+        listeners, err := svc.ELBv2.GetAllListenersInLoadBalancer(ctx, description.LoadBalancerArn)
+
+        if err != nil {
+            return nil, err
+        }
+
+        for _, listener := range listeners {
+            serviceListener := ILBServiceListener{
+                Port:     existingListenerPort(listener),
+                Protocol: string(listener.Protocol),
+            }
+
+            actions := listener.DefaultActions
+
+            // only the listeners with a rule for the service are part of it on a shared load balancer
+            if len(description.SharedLoadBalancer) > 0 {
+                rules, err := svc.serviceRules(ctx, memory.Unwrap(listener.ListenerArn), description.TargetGroupArn)
+
+                if err != nil {
+                    return nil, err
+                }
+
+                if len(rules) == 0 {
+                    continue
+                }
+
+                for _, rule := range rules {
+                    serviceListener.Routes = append(serviceListener.Routes, describeRuleConditions(rule.Conditions)...)
+                }
+
+                actions = rules[0].Actions
+            }
+
+            if weight, ok := canaryWeight(actions, description.CanaryTargetGroupArn); ok {
+                description.CanaryWeight = weight
+            }
+
+            if len(listener.Certificates) > 0 {
+                certs, err := svc.ELBv2.GetAllListenerCertificates(ctx, memory.Unwrap(listener.ListenerArn))
+
+                if err != nil {
+                    return nil, err
+                }
+
+                // default certificate first
+                slices.SortStableFunc(certs, func(a, b types.Certificate) int {
+                    if memory.Unwrap(a.IsDefault) == memory.Unwrap(b.IsDefault) {
+                        return 0
+                    }
+
+                    if memory.Unwrap(a.IsDefault) {
+                        return -1
+                    }
+
+                    return 1
+                })
+
+                for _, cert := range certs {
+                    arn := memory.Unwrap(cert.CertificateArn)
+
+                    if domainName, ok := lookups.certificateDomains[arn]; ok {
+                        arn = domainName
+                    }
+
+                    serviceListener.Certificates = append(serviceListener.Certificates, arn)
+                }
+            }
+
+            description.Listeners = append(description.Listeners, serviceListener)
+        }
+
+        slices.SortFunc(description.Listeners, func(a, b ILBServiceListener) int {
+            return int(a.Port - b.Port)
+        })
+    }
+
+    for _, targetGroupArn := range []string{description.TargetGroupArn, description.CanaryTargetGroupArn} {
+        if len(targetGroupArn) == 0 {
+            continue
+        }
+
+        health, err := svc.ELBv2.GetTargetHealth(ctx, targetGroupArn)
+
+        if err != nil {
+            return nil, err
+        }
+
+        for _, targetHealth := range health {
+            if targetHealth.Target == nil {
+                continue
+            }
+
+            target := ILBServiceTarget{
+                InstanceId:   memory.Unwrap(targetHealth.Target.Id),
+                InstanceName: lookups.instanceNames[memory.Unwrap(targetHealth.Target.Id)],
+                Port:         memory.Unwrap(targetHealth.Target.Port),
+                Canary:       targetGroupArn == description.CanaryTargetGroupArn,
+            }
+
+            if targetHealth.TargetHealth != nil {
+                target.State = string(targetHealth.TargetHealth.State)
+                target.Reason = string(targetHealth.TargetHealth.Reason)
+                target.Description = memory.Unwrap(targetHealth.TargetHealth.Description)
+            }
+
+            description.Targets = append(description.Targets, target)
+        }
+    }
+
+    for _, alias := range lookups.aliases {
+        if len(description.LoadBalancerDNSName) == 0 || !aliasPointsTo(&alias.Record, description.LoadBalancerDNSName) {
+            continue
+        }
+
+        domain := strings.TrimSuffix(memory.Unwrap(alias.Record.Name), ".")
+
+        // the other domains on a shared load balancer belong to other services
+        if len(description.SharedLoadBalancer) > 0 && !slices.ContainsFunc(description.Listeners, func(listener ILBServiceListener) bool {
+            return slices.Contains(listener.Routes, fmt.Sprintf("host-header: %s", domain))
+        }) {
+            continue
+        }
+
+        if !slices.Contains(description.Domains, domain) {
+            description.Domains = append(description.Domains, domain)
+        }
+    }
+
+    return description, nil
+}
+
+// ListILBServices describes every load-balanced service awsum created, including the ones on shared load balancers.
+func (svc *AwsumILBService) ListILBServices(ctx context.Context) ([]*ILBServiceDescription, error) {
+    loadBalancers, err := svc.ELBv2.GetAllLoadBalancers(ctx)
+
+    if err != nil {
+        return nil, err
+    }
+
+    targetGroups, err := svc.ELBv2.GetAllTargetGroups(ctx)
+
+    if err != nil {
+        return nil, err
+    }
+
+    var (
+        services            = make(map[string]*types.TargetGroup)
+        canaries            = make(map[string]*types.TargetGroup)
+        loadBalancersByArn  = make(map[string]*types.LoadBalancer)
+        loadBalancersByName = make(map[string]*types.LoadBalancer)
+        awsumArns           []string
+    )
+
+    for _, loadBalancer := range loadBalancers {
+        name := memory.Unwrap(loadBalancer.LoadBalancerName)
+
+        loadBalancersByArn[memory.Unwrap(loadBalancer.LoadBalancerArn)] = &loadBalancer
+
+        if strings.HasPrefix(name, awsumILBResourcePrefix) || strings.HasPrefix(name, awsumSharedLBResourcePrefix) {
+            awsumArns = append(awsumArns, memory.Unwrap(loadBalancer.LoadBalancerArn))
+        }
+    }
+
+    for _, targetGroup := range targetGroups {
+        if strings.HasPrefix(memory.Unwrap(targetGroup.TargetGroupName), awsumILBResourcePrefix) {
+            awsumArns = append(awsumArns, memory.Unwrap(targetGroup.TargetGroupArn))
+        }
+    }
+
+    tags, err := svc.ELBv2.GetTags(ctx, awsumArns...)
+
+    if err != nil {
+        return nil, err
+    }
+
+    // the names of resources may be cut short, their tag has the full name of their service (resources awsum created
+    // before tagging them have it in their name instead)
+    serviceNameOf := func(arn string, name string) string {
+        if serviceName, ok := tags[arn][ServiceTagKey]; ok {
+            return serviceName
+        }
+
+        return strings.TrimPrefix(name, awsumILBResourcePrefix)
+    }
+
+    for _, loadBalancer := range loadBalancers {
+        if name := memory.Unwrap(loadBalancer.LoadBalancerName); strings.HasPrefix(name, awsumILBResourcePrefix) {
+            loadBalancersByName[name] = &loadBalancer
+            services[serviceNameOf(memory.Unwrap(loadBalancer.LoadBalancerArn), name)] = nil
+        }
+    }
+
+    for _, targetGroup := range targetGroups {
+        name := memory.Unwrap(targetGroup.TargetGroupName)
+
+        if !strings.HasPrefix(name, awsumILBResourcePrefix) {
+            continue
+        }
+
+        serviceName := serviceNameOf(memory.Unwrap(targetGroup.TargetGroupArn), name)
+
+        if _, ok := tags[memory.Unwrap(targetGroup.TargetGroupArn)][ServiceTagKey]; ok && name == AwsumILBCanaryResourceName(serviceName) {
+            canaries[serviceName] = &targetGroup
+            continue
+        }
+
+        services[serviceName] = &targetGroup
+    }
+
+    // untagged canary target groups are told apart by being named after another service
+    for serviceName, targetGroup := range services {
+        if targetGroup == nil {
+            continue
+        }
+
+        for other := range services {
+            if memory.Unwrap(targetGroup.TargetGroupName) == AwsumILBCanaryResourceName(other) {
+                canaries[other] = targetGroup
+                delete(services, serviceName)
+            }
+        }
+    }
+
+    if len(services) == 0 {
+        return nil, nil
+    }
+
+    lookups, err := svc.newILBServiceLookups(ctx)
+
+    if err != nil {
+        return nil, err
+    }
+
+    lookups.tags = tags
+
+    var descriptions []*ILBServiceDescription
+
+    for serviceName, targetGroup := range services {
+        loadBalancer := loadBalancersByName[AwsumILBResourceName(serviceName)]
+
+        if loadBalancer == nil && targetGroup != nil && len(targetGroup.LoadBalancerArns) > 0 {
+            loadBalancer = loadBalancersByArn[targetGroup.LoadBalancerArns[0]]
+        }
+
+        description, err := svc.describeILBService(ctx, serviceName, loadBalancer, targetGroup, canaries[serviceName], lookups)
+
+        if err != nil {
+            return nil, err
+        }
+
+        descriptions = append(descriptions, description)
+    }
+
+    slices.SortFunc(descriptions, func(a, b *ILBServiceDescription) int {
+        return strings.Compare(a.Name, b.Name)
+    })
+
+    return descriptions, nil
+}
+
+// DescribeILBService describes the load-balanced service awsum created with the given name.
+func (svc *AwsumILBService) DescribeILBService(ctx context.Context, serviceName string) (*ILBServiceDescription, error) {
+    name := AwsumILBResourceName(serviceName)
+
+    recorded, err := svc.serviceState(ctx, serviceName)
+
+    if err != nil {
+        return nil, err
+    }
+
+    loadBalancer, err := svc.findLoadBalancer(ctx, recorded.loadBalancerArn(""), name)
+
+    if err != nil {
+        return nil, err
+    }
+
+    targetGroup, err := svc.findTargetGroup(ctx, recorded.TargetGroupArn, name)
+
+    if err != nil {
+        return nil, err
+    }
+
+    canaryTargetGroup, err := svc.findTargetGroup(ctx, recorded.CanaryTargetGroupArn, AwsumILBCanaryResourceName(serviceName))
+
+    if err != nil {
+        return nil, err
+    }
+
+    if loadBalancer == nil && targetGroup == nil {
+        return nil, fmt.Errorf("%w '%s'", ErrServiceNotFound, serviceName)
+    }
+
+    if loadBalancer == nil && len(targetGroup.LoadBalancerArns) > 0 {
+        if loadBalancer, err = svc.ELBv2.GetLoadBalancerByArn(ctx, targetGroup.LoadBalancerArns[0]); err != nil {
+            return nil, err
+        }
+    }
+
+    lookups, err := svc.newILBServiceLookups(ctx)
+
+    if err != nil {
+        return nil, err
+    }
+
+    if loadBalancer != nil {
+        if lookups.tags, err = svc.ELBv2.GetTags(ctx, memory.Unwrap(loadBalancer.LoadBalancerArn)); err != nil {
+            return nil, err
+        }
+    }
+
+    return svc.describeILBService(ctx, serviceName, loadBalancer, targetGroup, canaryTargetGroup, lookups)
+}
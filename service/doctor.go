@@ -0,0 +1,556 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "os"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+    "github.com/levelshatter/awsum/internal/files"
+    "github.com/levelshatter/awsum/internal/memory"
+    "golang.org/x/crypto/ssh"
+    "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// egressIPLookupURL returns the public ip address requests are made from, as plain text.
+const egressIPLookupURL = "https://checkip.amazonaws.com"
+
+const sshPort = 22
+
+// ephemeralPorts are representative client-side ports of ssh connections (the edges of the linux and windows
+// ephemeral port ranges), which network ACLs must allow return traffic to since they are stateless.
+var ephemeralPorts = []int32{32768, 49152, 60999, 65535}
+
+var (
+    ErrDiagnosticsFailed = errors.New("one or more diagnostics failed")
+    errHostKeyCaptured   = errors.New("host key captured")
+)
+
+type DiagnosticStatus string
+
+const (
+    DiagnosticPass DiagnosticStatus = "PASS"
+    DiagnosticWarn DiagnosticStatus = "WARN"
+    DiagnosticFail DiagnosticStatus = "FAIL"
+    DiagnosticSkip DiagnosticStatus = "SKIP"
+)
+
+// Diagnostic is the result of a single reachability check, with a hint on how to fix it when it doesn't pass.
+type Diagnostic struct {
+    Check       string
+    Status      DiagnosticStatus
+    Detail      string
+    Remediation string
+}
+
+// LookupEgressIP returns the public ip address traffic from this machine appears to come from.
+func LookupEgressIP(ctx context.Context) (net.IP, error) {
+    ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+    defer cancel()
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, egressIPLookupURL, nil)
+
+    if err != nil {
+        return nil, err
+    }
+
+    res, err := http.DefaultClient.Do(req)
+
+    if err != nil {
+        return nil, fmt.Errorf("failed to look up egress ip: %w", err)
+    }
+
+    defer func() {
+        _ = res.Body.Close()
+    }()
+
+    buf, err := io.ReadAll(io.LimitReader(res.Body, 256))
+
+    if err != nil {
+        return nil, fmt.Errorf("failed to read egress ip: %w", err)
+    }
+
+    ip := net.ParseIP(strings.TrimSpace(string(buf)))
+
+    if ip == nil {
+        return nil, fmt.Errorf("failed to parse egress ip '%s'", strings.TrimSpace(string(buf)))
+    }
+
+    return ip, nil
+}
+
+func cidrContains(cidr string, ip net.IP) bool {
+    _, network, err := net.ParseCIDR(cidr)
+
+    return err == nil && network.Contains(ip)
+}
+
+// ipProtocolMatchesTCP reports whether a rule's ip protocol ("-1" meaning all, or a name or number) covers tcp.
+func ipProtocolMatchesTCP(protocol string) bool {
+    return protocol == "-1" || protocol == "tcp" || protocol == "6"
+}
+
+// SecurityGroupsAllowIngress returns the id of the first security group with a rule allowing tcp traffic on the port
+// from the given ip address, or an empty string if none does. Rules referencing prefix lists or other security groups
+// are not evaluated.
+func SecurityGroupsAllowIngress(securityGroups []types.SecurityGroup, ip net.IP, port int32) string {
+    for _, securityGroup := range securityGroups {
+        for _, permission := range securityGroup.IpPermissions {
+            if !ipProtocolMatchesTCP(memory.Unwrap(permission.IpProtocol)) {
+                continue
+            }
+
+            // "-1" rules have no port range
+            if permission.FromPort != nil && permission.ToPort != nil && memory.Unwrap(permission.FromPort) != -1 &&
+                (port < memory.Unwrap(permission.FromPort) || port > memory.Unwrap(permission.ToPort)) {
+                continue
+            }
+
+            for _, ipRange := range permission.IpRanges {
+                if cidrContains(memory.Unwrap(ipRange.CidrIp), ip) {
+                    return memory.Unwrap(securityGroup.GroupId)
+                }
+            }
+
+            for _, ipRange := range permission.Ipv6Ranges {
+                if cidrContains(memory.Unwrap(ipRange.CidrIpv6), ip) {
+                    return memory.Unwrap(securityGroup.GroupId)
+                }
+            }
+        }
+    }
+
+    return ""
+}
+
+// NetworkAclAllows evaluates the network ACL's entries in rule number order like AWS does, returning whether tcp
+// traffic on the port to (egress) or from (ingress) the ip address is allowed, and the number of the deciding rule.
+func NetworkAclAllows(acl types.NetworkAcl, egress bool, ip net.IP, port int32) (bool, int32) {
+    entries := slices.Clone(acl.Entries)
+
+    slices.SortFunc(entries, func(a, b types.NetworkAclEntry) int {
+        return int(memory.Unwrap(a.RuleNumber) - memory.Unwrap(b.RuleNumber))
+    })
+
+    for _, entry := range entries {
+        if memory.Unwrap(entry.Egress) != egress || !ipProtocolMatchesTCP(memory.Unwrap(entry.Protocol)) {
+            continue
+        }
+
+        if entry.PortRange != nil &&
+            (port < memory.Unwrap(entry.PortRange.From) || port > memory.Unwrap(entry.PortRange.To)) {
+            continue
+        }
+
+        if !cidrContains(memory.Unwrap(entry.CidrBlock), ip) && !cidrContains(memory.Unwrap(entry.Ipv6CidrBlock), ip) {
+            continue
+        }
+
+        return entry.RuleAction == types.RuleActionAllow, memory.Unwrap(entry.RuleNumber)
+    }
+
+    // nothing matched, which the implicit "*" rule denies
+    return false, -1
+}
+
+// RouteTableHasInternetRoute returns the id of the internet gateway the route table routes traffic for the ip
+// address through, or an empty string if there is no such route.
+func RouteTableHasInternetRoute(routeTable types.RouteTable, ip net.IP) string {
+    for _, route := range routeTable.Routes {
+        if route.State != types.RouteStateActive || !strings.HasPrefix(memory.Unwrap(route.GatewayId), "igw-") {
+            continue
+        }
+
+        if cidrContains(memory.Unwrap(route.DestinationCidrBlock), ip) ||
+            cidrContains(memory.Unwrap(route.DestinationIpv6CidrBlock), ip) {
+            return memory.Unwrap(route.GatewayId)
+        }
+    }
+
+    return ""
+}
+
+type DiagnoseSSHOptions struct {
+    Ctx context.Context
+    // EgressIP is the public ip address ssh connections are made from, it is looked up if nil.
+    EgressIP net.IP
+}
+
+func diagnosis(check string, status DiagnosticStatus, detail string, remediation string) Diagnostic {
+    return Diagnostic{
+        Check:       check,
+        Status:      status,
+        Detail:      detail,
+        Remediation: remediation,
+    }
+}
+
+func (i *Instance) diagnoseSecurityGroups(ctx context.Context, egressIP net.IP) Diagnostic {
+    var groupIds []string
+
+    for _, group := range i.Info.SecurityGroups {
+        groupIds = append(groupIds, memory.Unwrap(group.GroupId))
+    }
+
+    securityGroups, err := i.Service.GetSecurityGroups(ctx, groupIds...)
+
+    if err != nil {
+        return diagnosis("security groups", DiagnosticWarn, err.Error(), "")
+    }
+
+    if groupId := SecurityGroupsAllowIngress(securityGroups, egressIP, sshPort); len(groupId) > 0 {
+        return diagnosis(
+            "security groups",
+            DiagnosticPass,
+            fmt.Sprintf("%s allows tcp/%d from %s", groupId, sshPort, egressIP),
+            "",
+        )
+    }
+
+    exampleGroupId := "<group id>"
+
+    if len(groupIds) > 0 {
+        exampleGroupId = groupIds[0]
+    }
+
+    return diagnosis(
+        "security groups",
+        DiagnosticFail,
+        fmt.Sprintf("no rule in %s allows tcp/%d from %s", strings.Join(groupIds, ", "), sshPort, egressIP),
+        fmt.Sprintf(
+            "aws ec2 authorize-security-group-ingress --group-id %s --protocol tcp --port %d --cidr %s/32 "+
+                "(rules referencing prefix lists or other security groups are not evaluated)",
+            exampleGroupId,
+            sshPort,
+            egressIP,
+        ),
+    )
+}
+
+func (i *Instance) diagnoseNetworkAcl(ctx context.Context, egressIP net.IP) Diagnostic {
+    subnetId := memory.Unwrap(i.Info.SubnetId)
+
+    acl, err := i.Service.GetNetworkAclForSubnet(ctx, memory.Unwrap(i.Info.VpcId), subnetId)
+
+    if err != nil || acl == nil {
+        return diagnosis(
+            "network acl",
+            DiagnosticWarn,
+            fmt.Sprintf("failed to find the network acl of %s: %v", subnetId, err),
+            "",
+        )
+    }
+
+    aclId := memory.Unwrap(acl.NetworkAclId)
+    ingressAllowed, ingressRule := NetworkAclAllows(*acl, false, egressIP, sshPort)
+
+    if !ingressAllowed {
+        return diagnosis(
+            "network acl",
+            DiagnosticFail,
+            fmt.Sprintf(
+                "%s denies inbound tcp/%d from %s (rule %s)",
+                aclId,
+                sshPort,
+                egressIP,
+                formatAclRule(ingressRule),
+            ),
+            fmt.Sprintf(
+                "add an inbound allow rule for tcp/%d from %s/32 to %s before rule %s",
+                sshPort,
+                egressIP,
+                aclId,
+                formatAclRule(ingressRule),
+            ),
+        )
+    }
+
+    // network acls are stateless, so the return traffic has to be allowed too
+    var deniedPorts []string
+
+    for _, port := range ephemeralPorts {
+        if allowed, _ := NetworkAclAllows(*acl, true, egressIP, port); !allowed {
+            deniedPorts = append(deniedPorts, fmt.Sprint(port))
+        }
+    }
+
+    if len(deniedPorts) > 0 {
+        status := DiagnosticWarn
+
+        if len(deniedPorts) == len(ephemeralPorts) {
+            status = DiagnosticFail
+        }
+
+        return diagnosis(
+            "network acl",
+            status,
+            fmt.Sprintf(
+                "%s denies outbound return traffic to %s on port(s) %s",
+                aclId,
+                egressIP,
+                strings.Join(deniedPorts, ", "),
+            ),
+            fmt.Sprintf("add an outbound allow rule for tcp/1024-65535 to %s/32 to %s", egressIP, aclId),
+        )
+    }
+
+    return diagnosis(
+        "network acl",
+        DiagnosticPass,
+        fmt.Sprintf("%s allows tcp/%d from %s (rule %s)", aclId, sshPort, egressIP, formatAclRule(ingressRule)),
+        "",
+    )
+}
+
+func (i *Instance) diagnoseRouteTable(ctx context.Context, egressIP net.IP) Diagnostic {
+    subnetId := memory.Unwrap(i.Info.SubnetId)
+
+    routeTable, err := i.Service.GetRouteTableForSubnet(ctx, memory.Unwrap(i.Info.VpcId), subnetId)
+
+    if err != nil || routeTable == nil {
+        return diagnosis(
+            "route table",
+            DiagnosticWarn,
+            fmt.Sprintf("failed to find the route table of %s: %v", subnetId, err),
+            "",
+        )
+    }
+
+    routeTableId := memory.Unwrap(routeTable.RouteTableId)
+
+    if gatewayId := RouteTableHasInternetRoute(*routeTable, egressIP); len(gatewayId) > 0 {
+        return diagnosis("route table", DiagnosticPass, fmt.Sprintf("%s routes to %s", routeTableId, gatewayId), "")
+    }
+
+    return diagnosis(
+        "route table",
+        DiagnosticFail,
+        fmt.Sprintf("%s has no route to an internet gateway for %s", routeTableId, egressIP),
+        "the instance is in a private subnet, add a 0.0.0.0/0 route to an internet gateway or connect from inside the vpc",
+    )
+}
+
+func (i *Instance) diagnoseKeyPair() Diagnostic {
+    keyName := memory.Unwrap(i.Info.KeyName)
+
+    if len(keyName) == 0 {
+        return diagnosis(
+            "key pair",
+            DiagnosticFail,
+            "the instance was launched without a key pair",
+            "add a public key to the instance's authorized_keys (e.g. via ec2 instance connect or ssm)",
+        )
+    }
+
+    keyFilename, err := i.AssumedPrivateKeyFilename()
+
+    if err != nil {
+        return diagnosis("key pair", DiagnosticFail, err.Error(), "")
+    }
+
+    buf, err := files.ReadFileFull(keyFilename)
+
+    if err != nil {
+        return diagnosis(
+            "key pair",
+            DiagnosticFail,
+            fmt.Sprintf("'%s' not found", keyFilename),
+            fmt.Sprintf("save the private key of key pair '%s' to '%s'", keyName, keyFilename),
+        )
+    }
+
+    if _, err = ssh.ParsePrivateKey(buf); err != nil {
+        return diagnosis(
+            "key pair",
+            DiagnosticFail,
+            fmt.Sprintf("'%s' is not a valid private key: %s", keyFilename, err),
+            "",
+        )
+    }
+
+    if info, err := os.Stat(keyFilename); err == nil && info.Mode().Perm()&0077 != 0 {
+        return diagnosis(
+            "key pair",
+            DiagnosticWarn,
+            fmt.Sprintf("'%s' is accessible by other users (%s)", keyFilename, info.Mode().Perm()),
+            fmt.Sprintf("chmod 600 %s", keyFilename),
+        )
+    }
+
+    return diagnosis("key pair", DiagnosticPass, keyFilename, "")
+}
+
+// diagnoseHostKey checks the host key the instance presents (over the already open connection) against known_hosts.
+func diagnoseHostKey(conn net.Conn, addr string) Diagnostic {
+    var hostKey ssh.PublicKey
+
+    _ = conn.SetDeadline(time.Now().Add(time.Second * 10))
+
+    // only the handshake is needed to get the host key, so abort right after it
+    _, _, _, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+        User: "awsum-doctor",
+        HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+            hostKey = key
+            return errHostKeyCaptured
+        },
+    })
+
+    if hostKey == nil {
+        return diagnosis(
+            "known_hosts",
+            DiagnosticFail,
+            fmt.Sprintf("ssh handshake failed: %s", err),
+            "check that sshd (and not another service) is listening on port 22",
+        )
+    }
+
+    var keyErr *knownhosts.KeyError
+
+    err = files.CheckKnownHostsKey(addr, conn.RemoteAddr(), hostKey)
+
+    switch {
+    case err == nil:
+        return diagnosis("known_hosts", DiagnosticPass, "host key matches", "")
+    case errors.As(err, &keyErr) && len(keyErr.Want) == 0:
+        return diagnosis("known_hosts", DiagnosticPass, "host unknown, its key will be added on first connection", "")
+    case errors.As(err, &keyErr):
+        knownHostsFilename, _ := files.GetKnownHostsFilename()
+
+        return diagnosis(
+            "known_hosts",
+            DiagnosticFail,
+            fmt.Sprintf("host key conflicts with %s:%d", keyErr.Want[0].Filename, keyErr.Want[0].Line),
+            fmt.Sprintf(
+                "if the instance was replaced, remove the old key with: ssh-keygen -f %s -R %s",
+                knownHostsFilename,
+                knownhosts.Normalize(addr),
+            ),
+        )
+    default:
+        return diagnosis("known_hosts", DiagnosticWarn, err.Error(), "")
+    }
+}
+
+// DiagnoseSSH checks everything standing between this machine and an ssh session on the instance: its state and
+// address, its security groups, network ACL and route table, the local key pair and known_hosts entry, and finally
+// whether the ssh port can actually be reached.
+func (i *Instance) DiagnoseSSH(opts DiagnoseSSHOptions) []Diagnostic {
+    var diagnostics []Diagnostic
+
+    if state := memory.Unwrap(i.Info.State).Name; state == types.InstanceStateNameRunning {
+        diagnostics = append(diagnostics, diagnosis("instance state", DiagnosticPass, "running", ""))
+    } else {
+        diagnostics = append(diagnostics, diagnosis(
+            "instance state",
+            DiagnosticFail,
+            string(state),
+            fmt.Sprintf("aws ec2 start-instances --instance-ids %s", memory.Unwrap(i.Info.InstanceId)),
+        ))
+    }
+
+    publicDNS := memory.Unwrap(i.Info.PublicDnsName)
+
+    if len(publicDNS) > 0 {
+        diagnostics = append(diagnostics, diagnosis(
+            "public address",
+            DiagnosticPass,
+            fmt.Sprintf("%s (%s)", publicDNS, memory.Unwrap(i.Info.PublicIpAddress)),
+            "",
+        ))
+    } else {
+        diagnostics = append(diagnostics, diagnosis(
+            "public address",
+            DiagnosticFail,
+            fmt.Sprintf("none (private ip %s)", memory.Unwrap(i.Info.PrivateIpAddress)),
+            "awsum connects to the public dns name of instances, associate a public or elastic ip with the instance",
+        ))
+    }
+
+    // the network checks all depend on where we connect from
+
+    egressIP := opts.EgressIP
+
+    if egressIP == nil {
+        var err error
+
+        if egressIP, err = LookupEgressIP(opts.Ctx); err != nil {
+            diagnostics = append(diagnostics, diagnosis(
+                "egress ip",
+                DiagnosticWarn,
+                err.Error(),
+                "pass your public ip address with --egress-ip",
+            ))
+        }
+    }
+
+    if egressIP != nil {
+        diagnostics = append(
+            diagnostics,
+            i.diagnoseSecurityGroups(opts.Ctx, egressIP),
+            i.diagnoseNetworkAcl(opts.Ctx, egressIP),
+            i.diagnoseRouteTable(opts.Ctx, egressIP),
+        )
+    } else {
+        diagnostics = append(
+            diagnostics,
+            diagnosis("security groups", DiagnosticSkip, "egress ip unknown", ""),
+            diagnosis("network acl", DiagnosticSkip, "egress ip unknown", ""),
+            diagnosis("route table", DiagnosticSkip, "egress ip unknown", ""),
+        )
+    }
+
+    diagnostics = append(diagnostics, i.diagnoseKeyPair())
+
+    if len(publicDNS) == 0 {
+        return append(
+            diagnostics,
+            diagnosis("tcp probe", DiagnosticSkip, "no public address", ""),
+            diagnosis("known_hosts", DiagnosticSkip, "no public address", ""),
+        )
+    }
+
+    addr := i.SSHAddress()
+    dialer := net.Dialer{Timeout: time.Second * 5}
+    start := time.Now()
+
+    conn, err := dialer.DialContext(opts.Ctx, "tcp", addr)
+
+    if err != nil {
+        return append(
+            diagnostics,
+            diagnosis("tcp probe", DiagnosticFail, err.Error(), "fix the failed checks above and make sure sshd is running"),
+            diagnosis("known_hosts", DiagnosticSkip, "port unreachable", ""),
+        )
+    }
+
+    defer func() {
+        _ = conn.Close()
+    }()
+
+    return append(
+        diagnostics,
+        diagnosis(
+            "tcp probe",
+            DiagnosticPass,
+            fmt.Sprintf("connected to %s in %s", addr, time.Since(start).Round(time.Millisecond)),
+            "",
+        ),
+        diagnoseHostKey(conn, addr),
+    )
+}
+
+// formatAclRule formats a network ACL rule number, showing the catch-all rule (32767) as "*" like the console does.
+func formatAclRule(ruleNumber int32) string {
+    if ruleNumber == -1 || ruleNumber == 32767 {
+        return "*"
+    }
+
+    return fmt.Sprint(ruleNumber)
+}
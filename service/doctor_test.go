@@ -0,0 +1,118 @@
+package service_test
+
+import (
+    "net"
+    "testing"
+
+    "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+    "github.com/levelshatter/awsum/internal/memory"
+    "github.com/levelshatter/awsum/service"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestSecurityGroupsAllowIngress(t *testing.T) {
+    ip := net.ParseIP("203.0.113.7")
+
+    securityGroups := []types.SecurityGroup{
+        {
+            GroupId: memory.Pointer("sg-web"),
+            IpPermissions: []types.IpPermission{{
+                IpProtocol: memory.Pointer("tcp"),
+                FromPort:   memory.Pointer[int32](443),
+                ToPort:     memory.Pointer[int32](443),
+                IpRanges:   []types.IpRange{{CidrIp: memory.Pointer("0.0.0.0/0")}},
+            }},
+        },
+        {
+            GroupId: memory.Pointer("sg-ssh"),
+            IpPermissions: []types.IpPermission{{
+                IpProtocol: memory.Pointer("tcp"),
+                FromPort:   memory.Pointer[int32](22),
+                ToPort:     memory.Pointer[int32](22),
+                IpRanges:   []types.IpRange{{CidrIp: memory.Pointer("203.0.113.0/24")}},
+            }},
+        },
+    }
+
+    assert.Equal(t, "sg-ssh", service.SecurityGroupsAllowIngress(securityGroups, ip, 22))
+    assert.Equal(t, "", service.SecurityGroupsAllowIngress(securityGroups, net.ParseIP("198.51.100.1"), 22))
+
+    allTraffic := []types.SecurityGroup{{
+        GroupId: memory.Pointer("sg-all"),
+        IpPermissions: []types.IpPermission{{
+            IpProtocol: memory.Pointer("-1"),
+            IpRanges:   []types.IpRange{{CidrIp: memory.Pointer("0.0.0.0/0")}},
+        }},
+    }}
+
+    assert.Equal(t, "sg-all", service.SecurityGroupsAllowIngress(allTraffic, ip, 22))
+}
+
+func TestNetworkAclAllows(t *testing.T) {
+    ip := net.ParseIP("203.0.113.7")
+
+    acl := types.NetworkAcl{
+        Entries: []types.NetworkAclEntry{
+            {
+                RuleNumber: memory.Pointer[int32](32767),
+                Protocol:   memory.Pointer("-1"),
+                CidrBlock:  memory.Pointer("0.0.0.0/0"),
+                RuleAction: types.RuleActionDeny,
+                Egress:     memory.Pointer(false),
+            },
+            {
+                RuleNumber: memory.Pointer[int32](100),
+                Protocol:   memory.Pointer("6"),
+                PortRange:  &types.PortRange{From: memory.Pointer[int32](22), To: memory.Pointer[int32](22)},
+                CidrBlock:  memory.Pointer("0.0.0.0/0"),
+                RuleAction: types.RuleActionAllow,
+                Egress:     memory.Pointer(false),
+            },
+            {
+                RuleNumber: memory.Pointer[int32](50),
+                Protocol:   memory.Pointer("6"),
+                CidrBlock:  memory.Pointer("203.0.113.0/24"),
+                RuleAction: types.RuleActionDeny,
+                Egress:     memory.Pointer(false),
+            },
+            {
+                RuleNumber: memory.Pointer[int32](100),
+                Protocol:   memory.Pointer("-1"),
+                CidrBlock:  memory.Pointer("0.0.0.0/0"),
+                RuleAction: types.RuleActionAllow,
+                Egress:     memory.Pointer(true),
+            },
+        },
+    }
+
+    // the lower numbered deny rule wins for our ip
+    allowed, rule := service.NetworkAclAllows(acl, false, ip, 22)
+    assert.False(t, allowed)
+    assert.Equal(t, int32(50), rule)
+
+    allowed, rule = service.NetworkAclAllows(acl, false, net.ParseIP("198.51.100.1"), 22)
+    assert.True(t, allowed)
+    assert.Equal(t, int32(100), rule)
+
+    allowed, _ = service.NetworkAclAllows(acl, false, net.ParseIP("198.51.100.1"), 80)
+    assert.False(t, allowed)
+
+    allowed, _ = service.NetworkAclAllows(acl, true, ip, 49152)
+    assert.True(t, allowed)
+}
+
+func TestRouteTableHasInternetRoute(t *testing.T) {
+    ip := net.ParseIP("203.0.113.7")
+
+    public := types.RouteTable{Routes: []types.Route{
+        {DestinationCidrBlock: memory.Pointer("10.0.0.0/16"), GatewayId: memory.Pointer("local"), State: types.RouteStateActive},
+        {DestinationCidrBlock: memory.Pointer("0.0.0.0/0"), GatewayId: memory.Pointer("igw-123"), State: types.RouteStateActive},
+    }}
+
+    private := types.RouteTable{Routes: []types.Route{
+        {DestinationCidrBlock: memory.Pointer("0.0.0.0/0"), NatGatewayId: memory.Pointer("nat-123"), State: types.RouteStateActive},
+    }}
+
+    assert.Equal(t, "igw-123", service.RouteTableHasInternetRoute(public, ip))
+    assert.Equal(t, "", service.RouteTableHasInternetRoute(private, ip))
+}
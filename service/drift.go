@@ -0,0 +1,269 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "maps"
+    "slices"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+var (
+    ErrNoAppliedInputs = errors.New("the state has no applied inputs for the service, it has to be applied with awsum first")
+    ErrDriftDetected   = errors.New("drift detected")
+)
+
+var (
+    // managedTargetGroupAttributeKeys are the target group attributes awsum can set, which are checked for drift even
+    // when the inputs left them as they were.
+    managedTargetGroupAttributeKeys = []string{
+        "stickiness.enabled",
+        "stickiness.type",
+        "stickiness.lb_cookie.duration_seconds",
+        "stickiness.app_cookie.duration_seconds",
+        "stickiness.app_cookie.cookie_name",
+        "deregistration_delay.timeout_seconds",
+        "slow_start.duration_seconds",
+        "load_balancing.algorithm.type",
+    }
+    // managedLoadBalancerAttributeKeys are the load balancer attributes awsum can set, like
+    // managedTargetGroupAttributeKeys.
+    managedLoadBalancerAttributeKeys = []string{
+        "idle_timeout.timeout_seconds",
+        "routing.http2.enabled",
+        "deletion_protection.enabled",
+        "access_logs.s3.enabled",
+        "access_logs.s3.bucket",
+        "access_logs.s3.prefix",
+    }
+)
+
+// membershipResources are the resources whose actions follow the instances that started or stopped matching the
+// service, rather than undoing changes made outside of awsum.
+var membershipResources = []string{"targets", "instance security groups"}
+
+// changesMembership reports whether the action adds or removes instances of the service.
+func (a *PlanAction) changesMembership() bool {
+    return slices.Contains(membershipResources, a.Resource)
+}
+
+// appliedHealthCheck returns the whole of the target group's health check, the aws defaults included.
+func appliedHealthCheck(targetGroup *types.TargetGroup) *HealthCheckOptions {
+    healthCheck := &HealthCheckOptions{
+        Path:               memory.Unwrap(targetGroup.HealthCheckPath),
+        Port:               memory.Unwrap(targetGroup.HealthCheckPort),
+        Protocol:           targetGroup.HealthCheckProtocol,
+        Interval:           time.Duration(memory.Unwrap(targetGroup.HealthCheckIntervalSeconds)) * time.Second,
+        HealthyThreshold:   memory.Unwrap(targetGroup.HealthyThresholdCount),
+        UnhealthyThreshold: memory.Unwrap(targetGroup.UnhealthyThresholdCount),
+    }
+
+    if targetGroup.Matcher != nil {
+        healthCheck.Matcher = memory.Unwrap(targetGroup.Matcher.HttpCode)
+    }
+
+    return healthCheck
+}
+
+// appliedAttributes returns the current values of the given attributes that have one.
+func appliedAttributes(current map[string]string, keys []string) map[string]string {
+    applied := make(map[string]string)
+
+    for _, key := range keys {
+        if value := current[key]; len(value) > 0 {
+            applied[key] = value
+        }
+    }
+
+    return applied
+}
+
+// appliedInputs returns the inputs the service was just applied with, along with its health check and the attributes
+// awsum manages as they are right after, since the ones the inputs leave out are left as they were (or to the aws
+// defaults) and changes to them are drift all the same.
+func (svc *AwsumILBService) appliedInputs(
+    ctx context.Context,
+    opts SetupNewILBServiceOptions,
+    resources *ILBServiceResources,
+) (*ServiceInputs, error) {
+    inputs := newServiceInputs(opts)
+
+    targetGroup, err := svc.ELBv2.GetTargetGroupByArn(ctx, resources.TargetGroupArn)
+
+    if err != nil {
+        return nil, err
+    }
+
+    if targetGroup != nil {
+        inputs.AppliedHealthCheck = appliedHealthCheck(targetGroup)
+
+        current, err := svc.ELBv2.GetTargetGroupAttributes(ctx, resources.TargetGroupArn)
+
+        if err != nil {
+            return nil, err
+        }
+
+        inputs.AppliedTargetGroupAttributes = appliedAttributes(current, managedTargetGroupAttributeKeys)
+    }
+
+    // a shared load balancer's attributes are any of its services' to change
+    if len(opts.SharedLoadBalancer) == 0 && len(resources.LoadBalancerArn) > 0 {
+        current, err := svc.ELBv2.GetLoadBalancerAttributes(ctx, resources.LoadBalancerArn)
+
+        if err != nil {
+            return nil, err
+        }
+
+        inputs.AppliedLoadBalancerAttributes = appliedAttributes(current, managedLoadBalancerAttributeKeys)
+    }
+
+    return inputs, nil
+}
+
+// unsetAttributes returns the applied attributes the options leave as they are.
+func unsetAttributes(applied map[string]string, set []attribute) []attribute {
+    var attributes []attribute
+
+    for _, key := range slices.Sorted(maps.Keys(applied)) {
+        if !slices.ContainsFunc(set, func(attribute attribute) bool {
+            return attribute.key == key
+        }) {
+            attributes = append(attributes, attribute{key, applied[key]})
+        }
+    }
+
+    return attributes
+}
+
+// planAppliedAttributes makes the attributes the inputs left as they were match their values right after the service
+// was applied, which the plan of the inputs alone wouldn't check.
+func (svc *AwsumILBService) planAppliedAttributes(opts SetupNewILBServiceOptions, plan *ILBServicePlan, inputs *ServiceInputs) error {
+    if targetGroupArn := plan.Resources.TargetGroupArn; len(targetGroupArn) > 0 {
+        attributes := unsetAttributes(inputs.AppliedTargetGroupAttributes, opts.TargetGroupAttributes.attributes())
+
+        current, err := svc.ELBv2.GetTargetGroupAttributes(opts.Ctx, targetGroupArn)
+
+        if err != nil {
+            return err
+        }
+
+        if changes, changed := attributeDifferences(attributes, current); len(changes) > 0 {
+            plan.add(PlanActionModify, "target group attributes", opts.AwsumResourceName(), func(ctx context.Context, _ *ILBServiceResources) error {
+                return svc.modifyTargetGroupAttributes(ctx, targetGroupArn, changed)
+            }, changes...)
+        }
+    }
+
+    if loadBalancerArn := plan.Resources.LoadBalancerArn; len(loadBalancerArn) > 0 && len(opts.SharedLoadBalancer) == 0 {
+        attributes := unsetAttributes(inputs.AppliedLoadBalancerAttributes, opts.LoadBalancerAttributes.attributes())
+
+        current, err := svc.ELBv2.GetLoadBalancerAttributes(opts.Ctx, loadBalancerArn)
+
+        if err != nil {
+            return err
+        }
+
+        if changes, changed := attributeDifferences(attributes, current); len(changes) > 0 {
+            plan.add(PlanActionModify, "load balancer attributes", opts.LoadBalancerResourceName(), func(ctx context.Context, _ *ILBServiceResources) error {
+                return svc.modifyLoadBalancerAttributes(ctx, loadBalancerArn, changed)
+            }, changes...)
+        }
+    }
+
+    return nil
+}
+
+// PlanILBServiceDrift plans making the service match the inputs it was last applied with, along with the health check
+// and attributes they left as they were. Nothing would need changing for a service left as awsum made it, so every
+// action the plan has either undoes a change made outside of awsum, like in the console, or follows instances that
+// started or stopped matching the service since (which isn't drift, see HasDrift).
+func (svc *AwsumILBService) PlanILBServiceDrift(ctx context.Context, serviceName string) (*ILBServicePlan, error) {
+    state, err := svc.LoadState(ctx)
+
+    if err != nil {
+        return nil, err
+    }
+
+    recorded, ok := state.Services[serviceName]
+
+    if !ok {
+        return nil, fmt.Errorf("%w '%s'", ErrServiceNotInState, serviceName)
+    }
+
+    if recorded.Inputs == nil {
+        return nil, fmt.Errorf("%w '%s'", ErrNoAppliedInputs, serviceName)
+    }
+
+    opts := recorded.Inputs.options(ctx, serviceName)
+
+    if recorded.Inputs.AppliedHealthCheck != nil {
+        opts.HealthCheck = *recorded.Inputs.AppliedHealthCheck
+    }
+
+    plan, err := svc.PlanILBService(opts)
+
+    if err != nil {
+        return nil, err
+    }
+
+    if err = svc.planAppliedAttributes(plan.Options, plan, recorded.Inputs); err != nil {
+        return nil, err
+    }
+
+    return plan, nil
+}
+
+// driftActions splits the plan's actions into the ones undoing changes made outside of awsum and the ones following
+// instances that started or stopped matching the service.
+func (p *ILBServicePlan) driftActions() (drift []*PlanAction, membership []*PlanAction) {
+    for _, action := range p.Actions {
+        if action.changesMembership() {
+            membership = append(membership, action)
+        } else {
+            drift = append(drift, action)
+        }
+    }
+
+    return drift, membership
+}
+
+// HasDrift reports whether the plan undoes changes made outside of awsum. Instances that started or stopped matching
+// the service, like when its auto scaling group scales, aren't drift.
+func (p *ILBServicePlan) HasDrift() bool {
+    drift, _ := p.driftActions()
+
+    return len(drift) > 0
+}
+
+// PrintDrift writes the drift the plan undoes, in the format of Print, followed by the instances that started or
+// stopped matching the service.
+func (p *ILBServicePlan) PrintDrift(w io.Writer) {
+    drift, membership := p.driftActions()
+
+    if len(drift) == 0 {
+        _, _ = fmt.Fprintf(w, "no drift, service '%s' matches its last applied inputs\n", p.Options.ServiceName)
+    } else {
+        _, _ = fmt.Fprintf(w, "service '%s' has drifted, applying its last inputs again would make the following changes:\n\n", p.Options.ServiceName)
+
+        printActions(w, drift)
+
+        _, _ = fmt.Fprintf(
+            w,
+            "\ndrift: %d to create, %d to modify, %d to delete\n",
+            countActions(drift, PlanActionCreate),
+            countActions(drift, PlanActionModify),
+            countActions(drift, PlanActionDelete),
+        )
+    }
+
+    if len(membership) > 0 {
+        _, _ = fmt.Fprintf(w, "\ninstances started or stopped matching service '%s' since, which applying it again would follow:\n\n", p.Options.ServiceName)
+
+        printActions(w, membership)
+    }
+}
@@ -1,26 +1,41 @@
 package service
 
 import (
+    "bytes"
     "context"
     "errors"
     "fmt"
     "io"
+    "net"
     "os"
     "os/signal"
     "path"
+    "slices"
     "strings"
+    "sync/atomic"
     "syscall"
     "time"
 
     "github.com/aws/aws-sdk-go-v2/aws"
     "github.com/aws/aws-sdk-go-v2/service/ec2"
     "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+    "github.com/aws/smithy-go"
+    "github.com/levelshatter/awsum/internal/console"
     "github.com/levelshatter/awsum/internal/files"
     "github.com/levelshatter/awsum/internal/memory"
     "golang.org/x/crypto/ssh"
     "golang.org/x/term"
 )
 
+// SessionTerminateGracePeriod is how long a remote session is given to exit after being sent SIGTERM (and then SIGKILL)
+// when its context is done.
+const SessionTerminateGracePeriod = time.Second * 5
+
+var (
+    ErrSessionInterrupted = errors.New("session interrupted")
+    ErrConnectionLost     = errors.New("connection lost")
+)
+
 type EC2 struct {
     client *ec2.Client
 }
@@ -40,7 +55,8 @@ func (svc *EC2) Client() *ec2.Client {
     return svc.client
 }
 
-func (svc *EC2) GetAllRunningInstances(ctx context.Context) ([]*Instance, error) {
+// GetAllInstances returns every instance that isn't terminated, regardless of its state.
+func (svc *EC2) GetAllInstances(ctx context.Context) ([]*Instance, error) {
     var (
         instances []*Instance
         nextToken *string
@@ -57,8 +73,7 @@ func (svc *EC2) GetAllRunningInstances(ctx context.Context) ([]*Instance, error)
 
         for _, reservation := range output.Reservations {
             for _, instance := range reservation.Instances {
-                // make sure the instance is absolutely running (16 is the instance state code for running)
-                if instance.State != nil && memory.Unwrap(instance.State.Code) == 16 {
+                if instance.State != nil && instance.State.Name != types.InstanceStateNameTerminated {
                     instances = append(instances, NewInstanceFromEC2(instance))
                 }
             }
@@ -74,6 +89,25 @@ func (svc *EC2) GetAllRunningInstances(ctx context.Context) ([]*Instance, error)
     return instances, nil
 }
 
+func (svc *EC2) GetAllRunningInstances(ctx context.Context) ([]*Instance, error) {
+    instances, err := svc.GetAllInstances(ctx)
+
+    if err != nil {
+        return nil, err
+    }
+
+    var running []*Instance
+
+    for _, instance := range instances {
+        // make sure the instance is absolutely running (16 is the instance state code for running)
+        if memory.Unwrap(instance.Info.State.Code) == 16 {
+            running = append(running, instance)
+        }
+    }
+
+    return running, nil
+}
+
 func (svc *EC2) GetAllVPCs(ctx context.Context, vpcIds ...string) ([]types.Vpc, error) {
     var (
         vpcs      []types.Vpc
@@ -127,27 +161,29 @@ func (svc *EC2) GetAllSubnets(ctx context.Context) ([]types.Subnet, error) {
     return subnets, nil
 }
 
-// SearchForSecurityGroupByName will return a security group matching the name given, if there are no matches then it will
-// return a nil pointer and a nil error.
+// SearchForSecurityGroupByName will return a security group matching the name given, in any vpc, if there are no matches
+// then it will return a nil pointer and a nil error.
 func (svc *EC2) SearchForSecurityGroupByName(ctx context.Context, name string) (*types.SecurityGroup, error) {
     var (
         nextToken *string
     )
 
     for {
+        // looking up by GroupNames only works in the default vpc, filtering by name works in all of them
         sgOutput, err := svc.Client().DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
-            GroupNames: []string{name},
-            NextToken:  nextToken,
+            Filters: []types.Filter{
+                {
+                    Name:   memory.Pointer("group-name"),
+                    Values: []string{name},
+                },
+            },
+            NextToken: nextToken,
         })
 
-        if err != nil && !strings.Contains(err.Error(), "InvalidGroup.NotFound") {
+        if err != nil {
             return nil, err
         }
 
-        if sgOutput == nil {
-            break
-        }
-
         for _, securityGroup := range sgOutput.SecurityGroups {
             if memory.Unwrap(securityGroup.GroupName) == name {
                 return &securityGroup, nil
@@ -164,25 +200,80 @@ func (svc *EC2) SearchForSecurityGroupByName(ctx context.Context, name string) (
     return nil, nil
 }
 
-func (svc *EC2) CreateEmptySecurityGroup(ctx context.Context, name string) (*ec2.CreateSecurityGroupOutput, error) {
+// SearchForSecurityGroupById returns the security group with the given id, or nil if it doesn't exist.
+func (svc *EC2) SearchForSecurityGroupById(ctx context.Context, groupId string) (*types.SecurityGroup, error) {
+    sgOutput, err := svc.Client().DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+        GroupIds: []string{groupId},
+    })
+
+    var apiErr smithy.APIError
+
+    if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidGroup.NotFound" {
+        return nil, nil
+    }
+
+    if err != nil {
+        return nil, err
+    }
+
+    if len(sgOutput.SecurityGroups) == 0 {
+        return nil, nil
+    }
+
+    return &sgOutput.SecurityGroups[0], nil
+}
+
+// CreateEmptySecurityGroup creates a security group without any ingress rules, tagged with the given tags.
+func (svc *EC2) CreateEmptySecurityGroup(
+    ctx context.Context,
+    name string,
+    vpcId string,
+    tags map[string]string,
+) (*ec2.CreateSecurityGroupOutput, error) {
     return svc.Client().CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
         GroupName:   memory.Pointer(name),
         Description: memory.Pointer("managed by awsum"),
+        VpcId:       memory.Pointer(vpcId),
         TagSpecifications: []types.TagSpecification{
             {
                 ResourceType: types.ResourceTypeSecurityGroup,
-                Tags: []types.Tag{
-                    {
-                        Key:   memory.Pointer("managed-by"),
-                        Value: memory.Pointer("awsum"),
-                    },
-                },
+                Tags:         ec2Tags(tags),
             },
         },
     })
 }
 
-func (svc *EC2) GetAllSecurityGroupRules(ctx context.Context) ([]types.SecurityGroupRule, error) {
+// DeleteSecurityGroupWhenUnused deletes the security group, retrying for up to maxWait while it is still in use (like
+// by the network interfaces of a load balancer that is being deleted).
+func (svc *EC2) DeleteSecurityGroupWhenUnused(ctx context.Context, groupId string, maxWait time.Duration) error {
+    deadline := time.Now().Add(maxWait)
+
+    for {
+        _, err := svc.Client().DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{
+            GroupId: memory.Pointer(groupId),
+        })
+
+        var apiErr smithy.APIError
+
+        if err == nil || !errors.As(err, &apiErr) || apiErr.ErrorCode() != "DependencyViolation" {
+            return err
+        }
+
+        if time.Now().After(deadline) {
+            return fmt.Errorf("security group is still in use: %w", err)
+        }
+
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(time.Second * 10):
+        }
+    }
+}
+
+// GetAllSecurityGroupRules returns every rule of the given security groups, and none without any groups given (rather
+// than the rules of every group in the account).
+func (svc *EC2) GetAllSecurityGroupRules(ctx context.Context, groupIds ...string) ([]types.SecurityGroupRule, error) {
     var (
         output    *ec2.DescribeSecurityGroupRulesOutput
         rules     []types.SecurityGroupRule
@@ -190,8 +281,18 @@ func (svc *EC2) GetAllSecurityGroupRules(ctx context.Context) ([]types.SecurityG
         err       error
     )
 
+    if len(groupIds) == 0 {
+        return nil, nil
+    }
+
     for {
         output, err = svc.Client().DescribeSecurityGroupRules(ctx, &ec2.DescribeSecurityGroupRulesInput{
+            Filters: []types.Filter{
+                {
+                    Name:   memory.Pointer("group-id"),
+                    Values: groupIds,
+                },
+            },
             NextToken: nextToken,
         })
 
@@ -199,8 +300,100 @@ func (svc *EC2) GetAllSecurityGroupRules(ctx context.Context) ([]types.SecurityG
             return nil, err
         }
 
-        rules = append(rules, output.SecurityGroupRules...)
+        for _, rule := range output.SecurityGroupRules {
+            if slices.Contains(groupIds, memory.Unwrap(rule.GroupId)) {
+                rules = append(rules, rule)
+            }
+        }
+
+        nextToken = output.NextToken
+
+        if nextToken == nil {
+            break
+        }
+    }
+
+    return rules, nil
+}
+
+// GetSecurityGroupRulesReferencing returns the rules of other security groups that allow traffic from or to the given
+// security group, which keep it from being deleted.
+func (svc *EC2) GetSecurityGroupRulesReferencing(ctx context.Context, groupId string) ([]types.SecurityGroupRule, error) {
+    var (
+        groupIds  []string
+        nextToken *string
+    )
+
+    for _, filter := range []string{"ip-permission.group-id", "egress.ip-permission.group-id"} {
+        for {
+            output, err := svc.Client().DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+                Filters: []types.Filter{
+                    {
+                        Name:   memory.Pointer(filter),
+                        Values: []string{groupId},
+                    },
+                },
+                NextToken: nextToken,
+            })
+
+            if err != nil {
+                return nil, fmt.Errorf("failed to get security groups: %w", err)
+            }
+
+            for _, securityGroup := range output.SecurityGroups {
+                id := memory.Unwrap(securityGroup.GroupId)
+
+                if id != groupId && !slices.Contains(groupIds, id) {
+                    groupIds = append(groupIds, id)
+                }
+            }
+
+            nextToken = output.NextToken
+
+            if nextToken == nil {
+                break
+            }
+        }
+    }
+
+    rules, err := svc.GetAllSecurityGroupRules(ctx, groupIds...)
+
+    if err != nil {
+        return nil, err
+    }
+
+    return slices.DeleteFunc(rules, func(rule types.SecurityGroupRule) bool {
+        return rule.ReferencedGroupInfo == nil || memory.Unwrap(rule.ReferencedGroupInfo.GroupId) != groupId
+    }), nil
+}
+
+// SetInstanceSecurityGroups replaces the security groups of the instance('s primary network interface).
+func (svc *EC2) SetInstanceSecurityGroups(ctx context.Context, instanceId string, groupIds []string) error {
+    _, err := svc.Client().ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
+        InstanceId: memory.Pointer(instanceId),
+        Groups:     groupIds,
+    })
+
+    return err
+}
+
+func (svc *EC2) GetSecurityGroups(ctx context.Context, groupIds ...string) ([]types.SecurityGroup, error) {
+    var (
+        securityGroups []types.SecurityGroup
+        nextToken      *string
+    )
+
+    for {
+        output, err := svc.Client().DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+            GroupIds:  groupIds,
+            NextToken: nextToken,
+        })
 
+        if err != nil {
+            return nil, fmt.Errorf("failed to get security groups: %w", err)
+        }
+
+        securityGroups = append(securityGroups, output.SecurityGroups...)
         nextToken = output.NextToken
 
         if nextToken == nil {
@@ -208,6 +401,62 @@ func (svc *EC2) GetAllSecurityGroupRules(ctx context.Context) ([]types.SecurityG
         }
     }
 
+    return securityGroups, nil
+}
+
+// GetNetworkAclForSubnet returns the network ACL associated with the subnet, falling back to the default network ACL of
+// the vpc (which applies to subnets without an explicit association).
+func (svc *EC2) GetNetworkAclForSubnet(ctx context.Context, vpcId string, subnetId string) (*types.NetworkAcl, error) {
+    for _, filters := range [][]types.Filter{
+        {
+            {Name: memory.Pointer("association.subnet-id"), Values: []string{subnetId}},
+        },
+        {
+            {Name: memory.Pointer("vpc-id"), Values: []string{vpcId}},
+            {Name: memory.Pointer("default"), Values: []string{"true"}},
+        },
+    } {
+        output, err := svc.Client().DescribeNetworkAcls(ctx, &ec2.DescribeNetworkAclsInput{
+            Filters: filters,
+        })
+
+        if err != nil {
+            return nil, fmt.Errorf("failed to get network acls: %w", err)
+        }
+
+        if len(output.NetworkAcls) > 0 {
+            return &output.NetworkAcls[0], nil
+        }
+    }
+
+    return nil, nil
+}
+
+// GetRouteTableForSubnet returns the route table associated with the subnet, falling back to the main route table of
+// the vpc (which applies to subnets without an explicit association).
+func (svc *EC2) GetRouteTableForSubnet(ctx context.Context, vpcId string, subnetId string) (*types.RouteTable, error) {
+    for _, filters := range [][]types.Filter{
+        {
+            {Name: memory.Pointer("association.subnet-id"), Values: []string{subnetId}},
+        },
+        {
+            {Name: memory.Pointer("vpc-id"), Values: []string{vpcId}},
+            {Name: memory.Pointer("association.main"), Values: []string{"true"}},
+        },
+    } {
+        output, err := svc.Client().DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+            Filters: filters,
+        })
+
+        if err != nil {
+            return nil, fmt.Errorf("failed to get route tables: %w", err)
+        }
+
+        if len(output.RouteTables) > 0 {
+            return &output.RouteTables[0], nil
+        }
+    }
+
     return nil, nil
 }
 
@@ -245,18 +494,32 @@ func (i *Instance) GetFormattedType() string {
     return fmt.Sprintf("%s (%s %s)", i.Info.InstanceType, i.Info.Architecture, memory.Unwrap(i.Info.PlatformDetails))
 }
 
+// SSHAddress returns the address awsum connects to for ssh sessions on the instance.
+func (i *Instance) SSHAddress() string {
+    return fmt.Sprintf("%s:22", memory.Unwrap(i.Info.PublicDnsName))
+}
+
+// AssumedPrivateKeyFilename returns where the private key of the instance's key pair is assumed to be:
+// '~/.ssh/<key pair name>.pem'.
+func (i *Instance) AssumedPrivateKeyFilename() (string, error) {
+    sshDir, err := files.GetAssumedUserSSHDir()
+
+    if err != nil {
+        return "", fmt.Errorf("failed to get user ssh dir while searching for private key: %w", err)
+    }
+
+    return path.Join(sshDir, fmt.Sprintf("%s.pem", memory.Unwrap(i.Info.KeyName))), nil
+}
+
 // GenerateSSHClientConfigFromAssumedUserKey generates an ssh client config with keys from the user's ssh directory.
 // Assumed to be '~/.ssh'. The given user will be used in authentication.
 func (i *Instance) GenerateSSHClientConfigFromAssumedUserKey(user string) (*ssh.ClientConfig, error) {
-    homeDir, err := os.UserHomeDir()
+    assumedKeyFilename, err := i.AssumedPrivateKeyFilename()
 
     if err != nil {
-        return nil, fmt.Errorf("failed to get user home dir while searching for private key: %w", err)
+        return nil, err
     }
 
-    assumedSSHDirName := path.Join(homeDir, ".ssh")
-    assumedKeyFilename := path.Join(assumedSSHDirName, fmt.Sprintf("%s.pem", memory.Unwrap(i.Info.KeyName)))
-
     privateKeyBuf, err := files.ReadFileFull(assumedKeyFilename)
 
     if err != nil {
@@ -285,36 +548,222 @@ func (i *Instance) GenerateSSHClientConfigFromAssumedUserKey(user string) (*ssh.
     }, nil
 }
 
-func (i *Instance) DialSSH(user string) (*ssh.Client, error) {
+func (i *Instance) DialSSH(ctx context.Context, user string) (*ssh.Client, error) {
     config, err := i.GenerateSSHClientConfigFromAssumedUserKey(user)
 
     if err != nil {
         return nil, fmt.Errorf("failed to dial ssh: %w", err)
     }
 
-    client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", memory.Unwrap(i.Info.PublicDnsName)), config)
+    addr := i.SSHAddress()
+    dialer := net.Dialer{Timeout: config.Timeout}
+
+    conn, err := dialer.DialContext(ctx, "tcp", addr)
 
     if err != nil {
-        return nil, fmt.Errorf("failed to start ssh connection: %w", err)
+        return nil, fmt.Errorf(
+            "failed to start ssh connection (try 'awsum instance doctor --name \"%s\"'): %w",
+            i.GetName(),
+            err,
+        )
     }
 
-    return client, nil
+    // the ssh handshake doesn't take a context, so abort it by closing the underlying connection
+    stop := context.AfterFunc(ctx, func() {
+        _ = conn.Close()
+    })
+
+    clientConn, channels, requests, err := ssh.NewClientConn(conn, addr, config)
+
+    if !stop() {
+        if err == nil {
+            _ = clientConn.Close()
+        }
+
+        return nil, fmt.Errorf("failed to start ssh connection: %w", context.Cause(ctx))
+    }
+
+    if err != nil {
+        _ = conn.Close()
+
+        return nil, fmt.Errorf(
+            "failed to start ssh connection (try 'awsum instance doctor --name \"%s\"'): %w",
+            i.GetName(),
+            err,
+        )
+    }
+
+    return ssh.NewClient(clientConn, channels, requests), nil
+}
+
+// newSession opens a new session on the given client, closing the client if the context is done before the session
+// could be opened.
+func newSession(ctx context.Context, client *ssh.Client) (*ssh.Session, error) {
+    stop := context.AfterFunc(ctx, func() {
+        _ = client.Close()
+    })
+
+    session, err := client.NewSession()
+
+    if !stop() {
+        return nil, context.Cause(ctx)
+    }
+
+    return session, err
+}
+
+// waitSession waits for the given session to exit. If the context is done first, the remote process is sent SIGTERM,
+// then SIGKILL after SessionTerminateGracePeriod, and ErrSessionInterrupted is returned.
+func waitSession(ctx context.Context, session *ssh.Session) error {
+    done := make(chan error, 1)
+
+    go func() {
+        done <- session.Wait()
+    }()
+
+    select {
+    case err := <-done:
+        return err
+    case <-ctx.Done():
+    }
+
+    interruptErr := fmt.Errorf("%w: %w", ErrSessionInterrupted, context.Cause(ctx))
+
+    for _, sig := range []ssh.Signal{ssh.SIGTERM, ssh.SIGKILL} {
+        _ = session.Signal(sig)
+
+        select {
+        case <-done:
+            return interruptErr
+        case <-time.After(SessionTerminateGracePeriod):
+        }
+    }
+
+    // the server ignored both signals, so drop the session entirely
+    _ = session.Close()
+
+    return interruptErr
+}
+
+// KeepAliveOptions configures the keepalive requests sent over an ssh connection, similar to OpenSSH's
+// ServerAliveInterval and ServerAliveCountMax.
+type KeepAliveOptions struct {
+    // Interval is the time between keepalive requests, keepalives are disabled if it is zero.
+    Interval time.Duration
+    // CountMax is the number of unanswered keepalive requests after which the connection is considered lost.
+    CountMax int
+}
+
+// keepAlive sends keepalive requests over the client until the context is done. The returned channel is closed (and the
+// client with it) if the connection is considered lost.
+func keepAlive(ctx context.Context, client *ssh.Client, opts KeepAliveOptions) <-chan struct{} {
+    lost := make(chan struct{})
+
+    if opts.Interval <= 0 {
+        return lost
+    }
+
+    var missed atomic.Int32
+
+    go func() {
+        ticker := time.NewTicker(opts.Interval)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+            }
+
+            if int(missed.Add(1)) > max(opts.CountMax, 1) {
+                close(lost)
+                _ = client.Close()
+
+                return
+            }
+
+            go func() {
+                // any reply (even a failure) means the server is still there
+                if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+                    missed.Store(0)
+                }
+            }()
+        }
+    }()
+
+    return lost
+}
+
+// requestShellPty requests a pty of the given size for an interactive shell on the session, using the local TERM.
+func requestShellPty(session *ssh.Session, width int, height int) error {
+    desiredTerm := os.Getenv("TERM")
+
+    if len(desiredTerm) == 0 {
+        desiredTerm = "xterm-256color"
+    }
+
+    if err := session.RequestPty(desiredTerm, height, width, ssh.TerminalModes{
+        ssh.ECHO:          1,
+        ssh.IUTF8:         1,
+        ssh.TTY_OP_ISPEED: 115_200,
+        ssh.TTY_OP_OSPEED: 115_200,
+    }); err != nil {
+        return fmt.Errorf("failed to request pty while connecting to instance: %w", err)
+    }
+
+    return nil
 }
 
-func (i *Instance) AttachShell(sshUser string) error {
-    client, err := i.DialSSH(sshUser)
+// shellSessionError wraps the error returned from waiting on a shell session, reporting ErrConnectionLost if the
+// connection dropped (or stopped answering keepalives) before the shell exited.
+func shellSessionError(err error, lost <-chan struct{}) error {
+    if err == nil {
+        return nil
+    }
+
+    var exitMissingErr *ssh.ExitMissingError
+
+    select {
+    case <-lost:
+        return fmt.Errorf("%w: no response to keepalive requests", ErrConnectionLost)
+    default:
+    }
+
+    if errors.As(err, &exitMissingErr) || errors.Is(err, io.EOF) {
+        return fmt.Errorf("%w: %w", ErrConnectionLost, err)
+    }
+
+    return fmt.Errorf("failed to wait on session to instance: %w", err)
+}
+
+type AttachShellOptions struct {
+    Ctx       context.Context
+    User      string
+    KeepAlive KeepAliveOptions
+    // Record writes an asciicast recording of the session to the awsum data directory.
+    Record bool
+}
+
+// AttachShell starts an interactive shell on the instance, attached to the local terminal. If the connection drops
+// during the session, ErrConnectionLost is returned.
+func (i *Instance) AttachShell(opts AttachShellOptions) error {
+    ctx, cancel := context.WithCancel(opts.Ctx)
+    defer cancel()
+
+    client, err := i.DialSSH(ctx, opts.User)
 
     if err != nil {
         return fmt.Errorf("failed to create ssh client while connecting to instance: %w", err)
     }
 
     defer func() {
-        if err = client.Close(); err != nil && !errors.Is(err, io.EOF) {
+        if err = client.Close(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
             fmt.Printf("failed to properly close ssh client connection to instance: %s\n", err)
         }
     }()
 
-    session, err := client.NewSession()
+    session, err := newSession(ctx, client)
 
     if err != nil {
         return fmt.Errorf("failed to create ssh session while connecting to instance: %w", err)
@@ -326,9 +775,11 @@ func (i *Instance) AttachShell(sshUser string) error {
         }
     }()
 
-    session.Stdout = os.Stdout
-    session.Stderr = os.Stderr
-    session.Stdin = os.Stdin
+    stdin, err := session.StdinPipe()
+
+    if err != nil {
+        return fmt.Errorf("failed to open ssh session stdin while connecting to instance: %w", err)
+    }
 
     fd := int(os.Stdin.Fd())
 
@@ -346,12 +797,32 @@ func (i *Instance) AttachShell(sshUser string) error {
         }
     }
 
-    desiredTerm := os.Getenv("TERM")
+    var (
+        stdout    io.Writer = os.Stdout
+        stderr    io.Writer = os.Stderr
+        input     io.Reader = console.Stdin(ctx)
+        recording *sessionRecording
+    )
 
-    if len(desiredTerm) == 0 {
-        desiredTerm = "xterm-256color"
+    if opts.Record {
+        if recording, err = i.newSessionRecording(width, height); err != nil {
+            return err
+        }
+
+        defer recording.close()
+
+        stdout = io.MultiWriter(stdout, recording.Output())
+        stderr = io.MultiWriter(stderr, recording.Output())
+        input = io.TeeReader(input, recording.Input())
     }
 
+    session.Stdout = stdout
+    session.Stderr = stderr
+
+    go func() {
+        _, _ = io.Copy(stdin, input)
+    }()
+
     if inTerm {
         oldState, err := term.MakeRaw(fd)
 
@@ -366,6 +837,7 @@ func (i *Instance) AttachShell(sshUser string) error {
 
     quitSignals := make(chan os.Signal, 1)
     signal.Notify(quitSignals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+    defer signal.Stop(quitSignals)
 
     go func() {
         for s := range quitSignals {
@@ -380,28 +852,31 @@ func (i *Instance) AttachShell(sshUser string) error {
         }
     }()
 
-    if err = session.RequestPty(desiredTerm, height, width, ssh.TerminalModes{
-        ssh.ECHO:          1,
-        ssh.IUTF8:         1,
-        ssh.TTY_OP_ISPEED: 115_200,
-        ssh.TTY_OP_OSPEED: 115_200,
-    }); err != nil {
-        return fmt.Errorf("failed to request pty while connecting to instance: %w", err)
+    if err = requestShellPty(session, width, height); err != nil {
+        return err
     }
 
-    if err = session.Shell(); err != nil {
-        return fmt.Errorf("failed to open shell to instance: %w", err)
+    if inTerm {
+        console.WatchSize(ctx, fd, func(width, height int) {
+            _ = session.WindowChange(height, width)
+
+            if recording != nil {
+                recording.Resize(width, height)
+            }
+        })
     }
 
-    if err = session.Wait(); err != nil {
-        return fmt.Errorf("failed to wait on session to instance: %w", err)
+    lost := keepAlive(ctx, client, opts.KeepAlive)
+
+    if err = session.Shell(); err != nil {
+        return fmt.Errorf("failed to open shell to instance: %w", err)
     }
 
-    return nil
+    return shellSessionError(waitSession(ctx, session), lost)
 }
 
-func (i *Instance) RunInteractiveCommand(sshUser string, command string, quiet bool) error {
-    client, err := i.DialSSH(sshUser)
+func (i *Instance) RunInteractiveCommand(ctx context.Context, sshUser string, command string, quiet bool) error {
+    client, err := i.DialSSH(ctx, sshUser)
 
     if err != nil {
         return fmt.Errorf("failed to create ssh client while connecting to instance: %w", err)
@@ -413,7 +888,7 @@ func (i *Instance) RunInteractiveCommand(sshUser string, command string, quiet b
         }
     }()
 
-    session, err := client.NewSession()
+    session, err := newSession(ctx, client)
 
     if err != nil {
         return fmt.Errorf("failed to create ssh session while connecting to instance: %w", err)
@@ -431,13 +906,63 @@ func (i *Instance) RunInteractiveCommand(sshUser string, command string, quiet b
         session.Stdin = os.Stdin
     }
 
-    if err = session.Run(command); err != nil {
+    if err = session.Start(command); err != nil {
+        return fmt.Errorf("failed to start command on instance: %w", err)
+    }
+
+    if err = waitSession(ctx, session); err != nil {
         return fmt.Errorf("failed to run command on instance: %w", err)
     }
 
     return nil
 }
 
+// RunCommandOutput runs the command on the instance and returns its standard output.
+func (i *Instance) RunCommandOutput(ctx context.Context, sshUser string, command string) ([]byte, error) {
+    client, err := i.DialSSH(ctx, sshUser)
+
+    if err != nil {
+        return nil, fmt.Errorf("failed to create ssh client while connecting to instance: %w", err)
+    }
+
+    defer func() {
+        if err = client.Close(); err != nil && !errors.Is(err, io.EOF) {
+            fmt.Printf("failed to properly close ssh client connection to instance: %s\n", err)
+        }
+    }()
+
+    session, err := newSession(ctx, client)
+
+    if err != nil {
+        return nil, fmt.Errorf("failed to create ssh session while connecting to instance: %w", err)
+    }
+
+    defer func() {
+        if err = session.Close(); err != nil && !errors.Is(err, io.EOF) {
+            fmt.Printf("failed to properly close ssh client session to instance: %s\n", err)
+        }
+    }()
+
+    var stdout, stderr bytes.Buffer
+
+    session.Stdout = &stdout
+    session.Stderr = &stderr
+
+    if err = session.Start(command); err != nil {
+        return nil, fmt.Errorf("failed to start command on instance: %w", err)
+    }
+
+    if err = waitSession(ctx, session); err != nil {
+        if stderr.Len() > 0 {
+            return nil, fmt.Errorf("failed to run command on instance: %w: %s", err, strings.TrimSpace(stderr.String()))
+        }
+
+        return nil, fmt.Errorf("failed to run command on instance: %w", err)
+    }
+
+    return stdout.Bytes(), nil
+}
+
 func NewInstanceFromEC2(ec2Instance types.Instance) *Instance {
     return &Instance{
         Info:    ec2Instance,
@@ -2,9 +2,13 @@ package service
 
 import (
     "context"
+    "errors"
     "fmt"
     "os"
+    "slices"
+    "strconv"
     "strings"
+    "time"
 
     "github.com/aws/aws-sdk-go-v2/aws"
     elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
@@ -12,6 +16,11 @@ import (
     "github.com/levelshatter/awsum/internal/memory"
 )
 
+// TargetHealthPollInterval is how often target health is checked while waiting for targets to become healthy.
+const TargetHealthPollInterval = time.Second * 5
+
+var ErrTargetsNotHealthy = errors.New("targets did not become healthy")
+
 type ELBv2 struct {
     client *elbv2.Client
 }
@@ -115,6 +124,34 @@ func (svc *ELBv2) DeleteAllListenersInLoadBalancer(ctx context.Context, loadBala
     return nil
 }
 
+func (svc *ELBv2) GetAllLoadBalancers(ctx context.Context) ([]types.LoadBalancer, error) {
+    var (
+        dlbOutput     *elbv2.DescribeLoadBalancersOutput
+        loadBalancers []types.LoadBalancer
+        marker        *string
+        err           error
+    )
+
+    for {
+        dlbOutput, err = svc.Client().DescribeLoadBalancers(ctx, &elbv2.DescribeLoadBalancersInput{
+            Marker: marker,
+        })
+
+        if err != nil {
+            return nil, err
+        }
+
+        loadBalancers = append(loadBalancers, dlbOutput.LoadBalancers...)
+        marker = dlbOutput.NextMarker
+
+        if marker == nil {
+            break
+        }
+    }
+
+    return loadBalancers, nil
+}
+
 func (svc *ELBv2) SearchForLoadBalancerByName(ctx context.Context, name string) (*types.LoadBalancer, error) {
     dlbOutput, err := svc.Client().DescribeLoadBalancers(ctx, &elbv2.DescribeLoadBalancersInput{
         Names: []string{name},
@@ -165,3 +202,346 @@ func (svc *ELBv2) SearchForTargetGroupByName(ctx context.Context, name string) (
 
     return nil, err
 }
+
+func (svc *ELBv2) GetAllTargetGroups(ctx context.Context) ([]types.TargetGroup, error) {
+    var (
+        dtgOutput    *elbv2.DescribeTargetGroupsOutput
+        targetGroups []types.TargetGroup
+        marker       *string
+        err          error
+    )
+
+    for {
+        dtgOutput, err = svc.Client().DescribeTargetGroups(ctx, &elbv2.DescribeTargetGroupsInput{
+            Marker: marker,
+        })
+
+        if err != nil {
+            return nil, err
+        }
+
+        targetGroups = append(targetGroups, dtgOutput.TargetGroups...)
+        marker = dtgOutput.NextMarker
+
+        if marker == nil {
+            break
+        }
+    }
+
+    return targetGroups, nil
+}
+
+// GetLoadBalancerByArn returns the load balancer with the given arn, or nil if it doesn't exist.
+func (svc *ELBv2) GetLoadBalancerByArn(ctx context.Context, loadBalancerArn string) (*types.LoadBalancer, error) {
+    dlbOutput, err := svc.Client().DescribeLoadBalancers(ctx, &elbv2.DescribeLoadBalancersInput{
+        LoadBalancerArns: []string{loadBalancerArn},
+    })
+
+    if err != nil {
+        if strings.Contains(err.Error(), "LoadBalancerNotFound") {
+            return nil, nil
+        }
+
+        return nil, err
+    }
+
+    if len(dlbOutput.LoadBalancers) == 0 {
+        return nil, nil
+    }
+
+    return &dlbOutput.LoadBalancers[0], nil
+}
+
+// GetTargetGroupByArn returns the target group with the given arn, or nil if it doesn't exist.
+func (svc *ELBv2) GetTargetGroupByArn(ctx context.Context, targetGroupArn string) (*types.TargetGroup, error) {
+    dtgOutput, err := svc.Client().DescribeTargetGroups(ctx, &elbv2.DescribeTargetGroupsInput{
+        TargetGroupArns: []string{targetGroupArn},
+    })
+
+    if err != nil {
+        if strings.Contains(err.Error(), "TargetGroupNotFound") {
+            return nil, nil
+        }
+
+        return nil, err
+    }
+
+    if len(dtgOutput.TargetGroups) == 0 {
+        return nil, nil
+    }
+
+    return &dtgOutput.TargetGroups[0], nil
+}
+
+// MaxDescribeTagsResources is how many resources the tags of can be described at once.
+const MaxDescribeTagsResources = 20
+
+// GetTags returns the tags of each of the load balancers, target groups, listeners or rules with the given arns, keyed
+// by arn.
+func (svc *ELBv2) GetTags(ctx context.Context, arns ...string) (map[string]map[string]string, error) {
+    tags := make(map[string]map[string]string)
+
+    for batch := range slices.Chunk(arns, MaxDescribeTagsResources) {
+        output, err := svc.Client().DescribeTags(ctx, &elbv2.DescribeTagsInput{
+            ResourceArns: batch,
+        })
+
+        if err != nil {
+            return nil, err
+        }
+
+        for _, description := range output.TagDescriptions {
+            resourceTags := make(map[string]string)
+
+            for _, tag := range description.Tags {
+                resourceTags[memory.Unwrap(tag.Key)] = memory.Unwrap(tag.Value)
+            }
+
+            tags[memory.Unwrap(description.ResourceArn)] = resourceTags
+        }
+    }
+
+    return tags, nil
+}
+
+func (svc *ELBv2) GetAllRulesInListener(ctx context.Context, listenerArn string) ([]types.Rule, error) {
+    var (
+        drOutput *elbv2.DescribeRulesOutput
+        rules    []types.Rule
+        marker   *string
+        err      error
+    )
+
+    for {
+        drOutput, err = svc.Client().DescribeRules(ctx, &elbv2.DescribeRulesInput{
+            ListenerArn: memory.Pointer(listenerArn),
+            Marker:      marker,
+        })
+
+        if err != nil {
+            return nil, err
+        }
+
+        rules = append(rules, drOutput.Rules...)
+        marker = drOutput.NextMarker
+
+        if marker == nil {
+            break
+        }
+    }
+
+    return rules, nil
+}
+
+func (svc *ELBv2) GetTargetHealth(ctx context.Context, targetGroupArn string) ([]types.TargetHealthDescription, error) {
+    dthOutput, err := svc.Client().DescribeTargetHealth(ctx, &elbv2.DescribeTargetHealthInput{
+        TargetGroupArn: memory.Pointer(targetGroupArn),
+    })
+
+    if err != nil {
+        return nil, err
+    }
+
+    return dthOutput.TargetHealthDescriptions, nil
+}
+
+func (svc *ELBv2) GetAllListenerCertificates(ctx context.Context, listenerArn string) ([]types.Certificate, error) {
+    var (
+        dlcOutput *elbv2.DescribeListenerCertificatesOutput
+        certs     []types.Certificate
+        marker    *string
+        err       error
+    )
+
+    for {
+        dlcOutput, err = svc.Client().DescribeListenerCertificates(ctx, &elbv2.DescribeListenerCertificatesInput{
+            ListenerArn: memory.Pointer(listenerArn),
+            Marker:      marker,
+        })
+
+        if err != nil {
+            return nil, err
+        }
+
+        certs = append(certs, dlcOutput.Certificates...)
+        marker = dlcOutput.NextMarker
+
+        if marker == nil {
+            break
+        }
+    }
+
+    return certs, nil
+}
+
+func (svc *ELBv2) GetTargetGroupAttributes(ctx context.Context, targetGroupArn string) (map[string]string, error) {
+    dtgaOutput, err := svc.Client().DescribeTargetGroupAttributes(ctx, &elbv2.DescribeTargetGroupAttributesInput{
+        TargetGroupArn: memory.Pointer(targetGroupArn),
+    })
+
+    if err != nil {
+        return nil, err
+    }
+
+    attributes := make(map[string]string)
+
+    for _, attribute := range dtgaOutput.Attributes {
+        attributes[memory.Unwrap(attribute.Key)] = memory.Unwrap(attribute.Value)
+    }
+
+    return attributes, nil
+}
+
+func (svc *ELBv2) GetLoadBalancerAttributes(ctx context.Context, loadBalancerArn string) (map[string]string, error) {
+    dlbaOutput, err := svc.Client().DescribeLoadBalancerAttributes(ctx, &elbv2.DescribeLoadBalancerAttributesInput{
+        LoadBalancerArn: memory.Pointer(loadBalancerArn),
+    })
+
+    if err != nil {
+        return nil, err
+    }
+
+    attributes := make(map[string]string)
+
+    for _, attribute := range dlbaOutput.Attributes {
+        attributes[memory.Unwrap(attribute.Key)] = memory.Unwrap(attribute.Value)
+    }
+
+    return attributes, nil
+}
+
+// WaitForTargetsInService waits until all the given targets are healthy in the target group, up to maxWait.
+func (svc *ELBv2) WaitForTargetsInService(
+    ctx context.Context,
+    targetGroupArn string,
+    targets []types.TargetDescription,
+    maxWait time.Duration,
+) error {
+    if len(targets) == 0 {
+        return nil
+    }
+
+    return elbv2.NewTargetInServiceWaiter(svc.Client()).Wait(ctx, &elbv2.DescribeTargetHealthInput{
+        TargetGroupArn: memory.Pointer(targetGroupArn),
+        Targets:        targets,
+    }, maxWait)
+}
+
+// DeregisterTargetsAndWait deregisters the targets from the target group and waits for them to finish draining, which
+// takes as long as the target group's deregistration delay.
+func (svc *ELBv2) DeregisterTargetsAndWait(
+    ctx context.Context,
+    targetGroupArn string,
+    targets []types.TargetDescription,
+) error {
+    if len(targets) == 0 {
+        return nil
+    }
+
+    // 300 seconds is the default deregistration delay
+    delay := time.Second * 300
+
+    attributes, err := svc.GetTargetGroupAttributes(ctx, targetGroupArn)
+
+    if err != nil {
+        return err
+    }
+
+    if seconds, err := strconv.Atoi(attributes["deregistration_delay.timeout_seconds"]); err == nil {
+        delay = time.Duration(seconds) * time.Second
+    }
+
+    if _, err = svc.Client().DeregisterTargets(ctx, &elbv2.DeregisterTargetsInput{
+        TargetGroupArn: memory.Pointer(targetGroupArn),
+        Targets:        targets,
+    }); err != nil {
+        return err
+    }
+
+    return elbv2.NewTargetDeregisteredWaiter(svc.Client()).Wait(ctx, &elbv2.DescribeTargetHealthInput{
+        TargetGroupArn: memory.Pointer(targetGroupArn),
+        Targets:        targets,
+    }, delay+time.Minute)
+}
+
+// CountHealthyTargets returns how many of the targets are healthy.
+func CountHealthyTargets(health []types.TargetHealthDescription) int {
+    var healthy int
+
+    for _, description := range health {
+        if description.TargetHealth != nil && description.TargetHealth.State == types.TargetHealthStateEnumHealthy {
+            healthy++
+        }
+    }
+
+    return healthy
+}
+
+type WaitForHealthyTargetsOptions struct {
+    Ctx            context.Context
+    TargetGroupArn string
+    // MinHealthy is how many targets have to be healthy, 0 means all of them.
+    MinHealthy int
+    Timeout    time.Duration
+    // OnPoll is called with the health of every target each time it is checked.
+    OnPoll func(health []types.TargetHealthDescription)
+}
+
+// WaitForHealthyTargets polls the health of the target group's targets until enough of them are healthy. When that
+// doesn't happen within the timeout, the returned error lists why each remaining target isn't healthy.
+func (svc *ELBv2) WaitForHealthyTargets(opts WaitForHealthyTargetsOptions) error {
+    deadline := time.Now().Add(opts.Timeout)
+
+    for {
+        health, err := svc.GetTargetHealth(opts.Ctx, opts.TargetGroupArn)
+
+        if err != nil {
+            return err
+        }
+
+        if opts.OnPoll != nil {
+            opts.OnPoll(health)
+        }
+
+        required := opts.MinHealthy
+
+        if required == 0 {
+            required = len(health)
+        }
+
+        if len(health) > 0 && CountHealthyTargets(health) >= required {
+            return nil
+        }
+
+        if time.Now().After(deadline) {
+            var reasons []string
+
+            for _, description := range health {
+                if description.Target == nil || description.TargetHealth == nil {
+                    continue
+                }
+
+                if description.TargetHealth.State == types.TargetHealthStateEnumHealthy {
+                    continue
+                }
+
+                reasons = append(reasons, fmt.Sprintf(
+                    "  %s port %d: %s (%s: %s)",
+                    memory.Unwrap(description.Target.Id),
+                    memory.Unwrap(description.Target.Port),
+                    description.TargetHealth.State,
+                    description.TargetHealth.Reason,
+                    memory.Unwrap(description.TargetHealth.Description),
+                ))
+            }
+
+            return fmt.Errorf("%w within %s:\n%s", ErrTargetsNotHealthy, opts.Timeout, strings.Join(reasons, "\n"))
+        }
+
+        select {
+        case <-opts.Ctx.Done():
+            return opts.Ctx.Err()
+        case <-time.After(TargetHealthPollInterval):
+        }
+    }
+}
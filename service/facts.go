@@ -0,0 +1,307 @@
+package service
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "net"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+// factsSectionPrefix marks the start of each section in the output of factsScript.
+const factsSectionPrefix = "@@awsum:"
+
+// factsScript gathers everything in HostFacts in a single ssh session. Each section is introduced by a marker line so
+// that missing tools only leave their own section empty.
+const factsScript = `
+echo "@@awsum:os-release"; cat /etc/os-release 2>/dev/null
+echo "@@awsum:kernel"; uname -srm 2>/dev/null
+echo "@@awsum:uptime"; cat /proc/uptime 2>/dev/null
+echo "@@awsum:cpu-count"; nproc 2>/dev/null
+echo "@@awsum:cpu-model"; grep -m1 '^model name' /proc/cpuinfo 2>/dev/null | cut -d: -f2-
+echo "@@awsum:loadavg"; cat /proc/loadavg 2>/dev/null
+echo "@@awsum:meminfo"; cat /proc/meminfo 2>/dev/null
+echo "@@awsum:disks"; df -P -k -x tmpfs -x devtmpfs -x overlay -x squashfs 2>/dev/null || df -P -k 2>/dev/null
+echo "@@awsum:ports"; ss -H -tuln 2>/dev/null || sudo -n ss -H -tuln 2>/dev/null
+echo "@@awsum:containers"; docker ps --format '{{.ID}}\t{{.Image}}\t{{.Names}}\t{{.Status}}' 2>/dev/null || sudo -n docker ps --format '{{.ID}}\t{{.Image}}\t{{.Names}}\t{{.Status}}' 2>/dev/null
+echo "@@awsum:package-manager"; for m in dnf yum apt-get apk zypper pacman; do if command -v $m >/dev/null 2>&1; then echo $m; break; fi; done
+echo "@@awsum:package-count"; (rpm -qa 2>/dev/null || dpkg-query -f '.\n' -W 2>/dev/null || apk info 2>/dev/null || pacman -Q 2>/dev/null) | wc -l
+exit 0
+`
+
+type OSFacts struct {
+    ID         string `json:"id"`
+    Name       string `json:"name"`
+    Version    string `json:"version"`
+    PrettyName string `json:"pretty_name"`
+}
+
+type CPUFacts struct {
+    Count       int        `json:"count"`
+    Model       string     `json:"model"`
+    LoadAverage [3]float64 `json:"load_average"`
+}
+
+type MemoryFacts struct {
+    TotalBytes     uint64 `json:"total_bytes"`
+    AvailableBytes uint64 `json:"available_bytes"`
+    SwapTotalBytes uint64 `json:"swap_total_bytes"`
+    SwapFreeBytes  uint64 `json:"swap_free_bytes"`
+}
+
+type DiskFacts struct {
+    Filesystem     string `json:"filesystem"`
+    MountPoint     string `json:"mount_point"`
+    SizeBytes      uint64 `json:"size_bytes"`
+    UsedBytes      uint64 `json:"used_bytes"`
+    AvailableBytes uint64 `json:"available_bytes"`
+}
+
+type ListeningPort struct {
+    Protocol string `json:"protocol"`
+    Address  string `json:"address"`
+    Port     int    `json:"port"`
+}
+
+type ContainerFacts struct {
+    ID     string `json:"id"`
+    Image  string `json:"image"`
+    Name   string `json:"name"`
+    Status string `json:"status"`
+}
+
+type PackageFacts struct {
+    Manager string `json:"manager"`
+    Count   int    `json:"count"`
+}
+
+// HostFacts describes the operating system and current state of an instance, as seen from inside of it.
+type HostFacts struct {
+    InstanceId     string           `json:"instance_id"`
+    Name           string           `json:"name"`
+    GatheredAt     time.Time        `json:"gathered_at"`
+    OS             OSFacts          `json:"os"`
+    Kernel         string           `json:"kernel"`
+    UptimeSeconds  int64            `json:"uptime_seconds"`
+    CPU            CPUFacts         `json:"cpu"`
+    Memory         MemoryFacts      `json:"memory"`
+    Disks          []DiskFacts      `json:"disks"`
+    ListeningPorts []ListeningPort  `json:"listening_ports"`
+    Containers     []ContainerFacts `json:"containers"`
+    Packages       PackageFacts     `json:"packages"`
+}
+
+// GatherFacts connects to the instance over ssh and collects its HostFacts.
+func (i *Instance) GatherFacts(ctx context.Context, sshUser string) (*HostFacts, error) {
+    output, err := i.RunCommandOutput(ctx, sshUser, factsScript)
+
+    if err != nil {
+        return nil, fmt.Errorf("failed to gather facts: %w", err)
+    }
+
+    facts := ParseHostFacts(string(output))
+
+    facts.InstanceId = memory.Unwrap(i.Info.InstanceId)
+    facts.Name = i.GetName()
+    facts.GatheredAt = time.Now()
+
+    return facts, nil
+}
+
+// splitFactsSections splits the output of factsScript into its sections' lines, keyed by section name.
+func splitFactsSections(output string) map[string][]string {
+    var (
+        sections = make(map[string][]string)
+        current  string
+        scanner  = bufio.NewScanner(strings.NewReader(output))
+    )
+
+    for scanner.Scan() {
+        line := scanner.Text()
+
+        if name, ok := strings.CutPrefix(line, factsSectionPrefix); ok {
+            current = name
+            sections[current] = nil
+
+            continue
+        }
+
+        if len(current) > 0 && len(strings.TrimSpace(line)) > 0 {
+            sections[current] = append(sections[current], line)
+        }
+    }
+
+    return sections
+}
+
+// ParseHostFacts parses the output of the facts gathering script. Sections that are missing or malformed are left as
+// zero values, since not every host has every tool the script uses.
+func ParseHostFacts(output string) *HostFacts {
+    var (
+        facts    HostFacts
+        sections = splitFactsSections(output)
+    )
+
+    for _, line := range sections["os-release"] {
+        key, value, ok := strings.Cut(line, "=")
+
+        if !ok {
+            continue
+        }
+
+        value = strings.Trim(value, `"'`)
+
+        switch key {
+        case "ID":
+            facts.OS.ID = value
+        case "NAME":
+            facts.OS.Name = value
+        case "VERSION_ID":
+            facts.OS.Version = value
+        case "PRETTY_NAME":
+            facts.OS.PrettyName = value
+        }
+    }
+
+    if lines := sections["kernel"]; len(lines) > 0 {
+        facts.Kernel = strings.TrimSpace(lines[0])
+    }
+
+    if lines := sections["uptime"]; len(lines) > 0 {
+        if fields := strings.Fields(lines[0]); len(fields) > 0 {
+            if uptime, err := strconv.ParseFloat(fields[0], 64); err == nil {
+                facts.UptimeSeconds = int64(uptime)
+            }
+        }
+    }
+
+    if lines := sections["cpu-count"]; len(lines) > 0 {
+        facts.CPU.Count, _ = strconv.Atoi(strings.TrimSpace(lines[0]))
+    }
+
+    if lines := sections["cpu-model"]; len(lines) > 0 {
+        facts.CPU.Model = strings.TrimSpace(lines[0])
+    }
+
+    if lines := sections["loadavg"]; len(lines) > 0 {
+        fields := strings.Fields(lines[0])
+
+        for i := 0; i < len(fields) && i < len(facts.CPU.LoadAverage); i++ {
+            facts.CPU.LoadAverage[i], _ = strconv.ParseFloat(fields[i], 64)
+        }
+    }
+
+    for _, line := range sections["meminfo"] {
+        fields := strings.Fields(line)
+
+        if len(fields) < 2 {
+            continue
+        }
+
+        // /proc/meminfo values are in kB
+        kb, err := strconv.ParseUint(fields[1], 10, 64)
+
+        if err != nil {
+            continue
+        }
+
+        switch strings.TrimSuffix(fields[0], ":") {
+        case "MemTotal":
+            facts.Memory.TotalBytes = kb * 1024
+        case "MemAvailable":
+            facts.Memory.AvailableBytes = kb * 1024
+        case "SwapTotal":
+            facts.Memory.SwapTotalBytes = kb * 1024
+        case "SwapFree":
+            facts.Memory.SwapFreeBytes = kb * 1024
+        }
+    }
+
+    for _, line := range sections["disks"] {
+        fields := strings.Fields(line)
+
+        // skip the header and anything we can't make sense of
+        if len(fields) < 6 || fields[0] == "Filesystem" {
+            continue
+        }
+
+        size, sizeErr := strconv.ParseUint(fields[1], 10, 64)
+        used, usedErr := strconv.ParseUint(fields[2], 10, 64)
+        available, availableErr := strconv.ParseUint(fields[3], 10, 64)
+
+        if sizeErr != nil || usedErr != nil || availableErr != nil {
+            continue
+        }
+
+        facts.Disks = append(facts.Disks, DiskFacts{
+            Filesystem:     fields[0],
+            MountPoint:     strings.Join(fields[5:], " "),
+            SizeBytes:      size * 1024,
+            UsedBytes:      used * 1024,
+            AvailableBytes: available * 1024,
+        })
+    }
+
+    for _, line := range sections["ports"] {
+        // Netid State Recv-Q Send-Q Local-Address:Port Peer-Address:Port ...
+        fields := strings.Fields(line)
+
+        if len(fields) < 5 {
+            continue
+        }
+
+        host, portStr, err := net.SplitHostPort(fields[4])
+
+        if err != nil {
+            // addresses with an interface look like 127.0.0.53%lo:53
+            idx := strings.LastIndex(fields[4], ":")
+
+            if idx == -1 {
+                continue
+            }
+
+            host, portStr = fields[4][:idx], fields[4][idx+1:]
+        }
+
+        port, err := strconv.Atoi(portStr)
+
+        if err != nil {
+            continue
+        }
+
+        facts.ListeningPorts = append(facts.ListeningPorts, ListeningPort{
+            Protocol: fields[0],
+            Address:  host,
+            Port:     port,
+        })
+    }
+
+    for _, line := range sections["containers"] {
+        fields := strings.Split(line, "\t")
+
+        if len(fields) < 4 {
+            continue
+        }
+
+        facts.Containers = append(facts.Containers, ContainerFacts{
+            ID:     fields[0],
+            Image:  fields[1],
+            Name:   fields[2],
+            Status: fields[3],
+        })
+    }
+
+    if lines := sections["package-manager"]; len(lines) > 0 {
+        facts.Packages.Manager = strings.TrimSpace(lines[0])
+    }
+
+    if lines := sections["package-count"]; len(lines) > 0 {
+        facts.Packages.Count, _ = strconv.Atoi(strings.TrimSpace(lines[0]))
+    }
+
+    return &facts
+}
@@ -0,0 +1,91 @@
+package service_test
+
+import (
+    "testing"
+
+    "github.com/levelshatter/awsum/service"
+    "github.com/stretchr/testify/assert"
+)
+
+const sampleFactsOutput = `@@awsum:os-release
+NAME="Amazon Linux"
+VERSION_ID="2023"
+ID="amzn"
+PRETTY_NAME="Amazon Linux 2023.5.20240624"
+@@awsum:kernel
+Linux 6.1.94-99.176.amzn2023.x86_64 x86_64
+@@awsum:uptime
+86461.52 170000.10
+@@awsum:cpu-count
+2
+@@awsum:cpu-model
+ Intel(R) Xeon(R) CPU E5-2676 v3 @ 2.40GHz
+@@awsum:loadavg
+0.08 0.03 0.01 1/123 4567
+@@awsum:meminfo
+MemTotal:         980000 kB
+MemFree:          100000 kB
+MemAvailable:     500000 kB
+SwapTotal:             0 kB
+SwapFree:              0 kB
+@@awsum:disks
+Filesystem     1024-blocks    Used Available Capacity Mounted on
+/dev/xvda1         8310764 2516212   5794552      31% /
+@@awsum:ports
+tcp   LISTEN 0      128          0.0.0.0:22        0.0.0.0:*
+tcp   LISTEN 0      4096            [::]:80           [::]:*
+udp   UNCONN 0      0      127.0.0.53%lo:53        0.0.0.0:*
+@@awsum:containers
+1a2b3c4d	traefik:v3.1	traefik	Up 2 hours
+@@awsum:package-manager
+dnf
+@@awsum:package-count
+412
+`
+
+func TestParseHostFacts(t *testing.T) {
+    facts := service.ParseHostFacts(sampleFactsOutput)
+
+    assert.Equal(t, "amzn", facts.OS.ID)
+    assert.Equal(t, "2023", facts.OS.Version)
+    assert.Equal(t, "Amazon Linux 2023.5.20240624", facts.OS.PrettyName)
+    assert.Equal(t, "Linux 6.1.94-99.176.amzn2023.x86_64 x86_64", facts.Kernel)
+    assert.Equal(t, int64(86461), facts.UptimeSeconds)
+    assert.Equal(t, 2, facts.CPU.Count)
+    assert.Equal(t, "Intel(R) Xeon(R) CPU E5-2676 v3 @ 2.40GHz", facts.CPU.Model)
+    assert.Equal(t, [3]float64{0.08, 0.03, 0.01}, facts.CPU.LoadAverage)
+    assert.Equal(t, uint64(980000*1024), facts.Memory.TotalBytes)
+    assert.Equal(t, uint64(500000*1024), facts.Memory.AvailableBytes)
+
+    assert.Equal(t, []service.DiskFacts{{
+        Filesystem:     "/dev/xvda1",
+        MountPoint:     "/",
+        SizeBytes:      8310764 * 1024,
+        UsedBytes:      2516212 * 1024,
+        AvailableBytes: 5794552 * 1024,
+    }}, facts.Disks)
+
+    assert.Equal(t, []service.ListeningPort{
+        {Protocol: "tcp", Address: "0.0.0.0", Port: 22},
+        {Protocol: "tcp", Address: "::", Port: 80},
+        {Protocol: "udp", Address: "127.0.0.53%lo", Port: 53},
+    }, facts.ListeningPorts)
+
+    assert.Equal(t, []service.ContainerFacts{{
+        ID:     "1a2b3c4d",
+        Image:  "traefik:v3.1",
+        Name:   "traefik",
+        Status: "Up 2 hours",
+    }}, facts.Containers)
+
+    assert.Equal(t, service.PackageFacts{Manager: "dnf", Count: 412}, facts.Packages)
+}
+
+func TestParseHostFacts_MissingSections(t *testing.T) {
+    facts := service.ParseHostFacts("@@awsum:kernel\nLinux 5.10 aarch64\n@@awsum:containers\n")
+
+    assert.Equal(t, "Linux 5.10 aarch64", facts.Kernel)
+    assert.Empty(t, facts.Containers)
+    assert.Empty(t, facts.Disks)
+    assert.Zero(t, facts.CPU.Count)
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+    "errors"
+    "fmt"
+    "slices"
+    "strings"
+
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+var (
+    ErrInvalidLoadBalancerType            = errors.New("load balancer type must be application, network or gateway")
+    ErrLoadBalancerTypeProtocol           = errors.New("protocol isn't supported by the load balancer type")
+    ErrListenersNeedSeveralTypes          = errors.New("listeners use protocols that need different load balancer types")
+    ErrLoadBalancerTypeChanged            = errors.New("load balancer type can't be changed, delete the service first")
+    ErrGatewayLoadBalancerUnsupported     = errors.New("a gateway load balancer can't have domains, certificates or be shared")
+    ErrSharedLoadBalancerNeedsRules       = errors.New("only application load balancers can be shared between services")
+    ErrCanaryNeedsApplicationLoadBalancer = errors.New("only application load balancers can split requests with a canary")
+    ErrInvalidAlpnPolicy                  = errors.New("alpn policy must be one of HTTP1Only, HTTP2Only, HTTP2Optional, HTTP2Preferred or None")
+    ErrAlpnPolicyNeedsTLSListener         = errors.New("an alpn policy requires a tls listener")
+    ErrGatewayLoadBalancerListener        = errors.New("a gateway load balancer takes exactly one geneve listener on port 6081")
+    ErrInvalidIpAddressType               = errors.New("ip address type must be ipv4 or dualstack")
+)
+
+// GatewayListenerPort is the port gateway load balancers receive (geneve) traffic on. Their listeners have no port of
+// their own, so this is the port a gateway listener is given.
+const GatewayListenerPort = 6081
+
+// AlpnPolicies are the application-layer protocol negotiation policies a tls listener can have.
+var AlpnPolicies = []string{"HTTP1Only", "HTTP2Only", "HTTP2Optional", "HTTP2Preferred", "None"}
+
+// loadBalancerProtocols holds the listener (and target group) protocols each type of load balancer supports.
+var loadBalancerProtocols = map[types.LoadBalancerTypeEnum][]types.ProtocolEnum{
+    types.LoadBalancerTypeEnumApplication: {types.ProtocolEnumHttp, types.ProtocolEnumHttps},
+    types.LoadBalancerTypeEnumNetwork: {
+        types.ProtocolEnumTcp,
+        types.ProtocolEnumUdp,
+        types.ProtocolEnumTcpUdp,
+        types.ProtocolEnumTls,
+    },
+    types.LoadBalancerTypeEnumGateway: {types.ProtocolEnumGeneve},
+}
+
+// ParseLoadBalancerType parses a load balancer type, an empty string leaves it to be picked from the listeners.
+func ParseLoadBalancerType(s string) (types.LoadBalancerTypeEnum, error) {
+    lbType := types.LoadBalancerTypeEnum(strings.ToLower(s))
+
+    if len(lbType) > 0 && !slices.Contains(lbType.Values(), lbType) {
+        return "", fmt.Errorf("%w, got '%s'", ErrInvalidLoadBalancerType, s)
+    }
+
+    return lbType, nil
+}
+
+// ParseIpAddressType parses the ip address type of a load balancer, an empty string leaves it to the existing load
+// balancer (or ipv4 for a new one).
+func ParseIpAddressType(s string) (types.IpAddressType, error) {
+    ipAddressType := types.IpAddressType(strings.ToLower(s))
+
+    if len(ipAddressType) > 0 && ipAddressType != types.IpAddressTypeIpv4 && ipAddressType != types.IpAddressTypeDualstack {
+        return "", fmt.Errorf("%w, got '%s'", ErrInvalidIpAddressType, s)
+    }
+
+    return ipAddressType, nil
+}
+
+// loadBalancerTypeFor returns the type of load balancer supporting the protocol.
+func loadBalancerTypeFor(protocol types.ProtocolEnum) types.LoadBalancerTypeEnum {
+    for lbType, protocols := range loadBalancerProtocols {
+        if slices.Contains(protocols, protocol) {
+            return lbType
+        }
+    }
+
+    return ""
+}
+
+// ResolveLoadBalancerType returns the type of load balancer for the listeners and the instance traffic protocol: the
+// requested one if it supports them, or the one supporting their protocols when none is requested.
+func ResolveLoadBalancerType(
+    requested types.LoadBalancerTypeEnum,
+    listeners []ListenerOptions,
+    trafficProtocol types.ProtocolEnum,
+) (types.LoadBalancerTypeEnum, error) {
+    lbType := requested
+
+    if len(lbType) == 0 {
+        for _, listener := range listeners {
+            listenerType := loadBalancerTypeFor(listener.Protocol)
+
+            if len(lbType) > 0 && listenerType != lbType {
+                return "", ErrListenersNeedSeveralTypes
+            }
+
+            lbType = listenerType
+        }
+    }
+
+    if len(lbType) == 0 {
+        lbType = types.LoadBalancerTypeEnumApplication
+    }
+
+    protocols := loadBalancerProtocols[lbType]
+
+    for _, listener := range listeners {
+        if !slices.Contains(protocols, listener.Protocol) {
+            return "", fmt.Errorf("%w, %s listener on port %d on a %s load balancer", ErrLoadBalancerTypeProtocol, listener.Protocol, listener.Port, lbType)
+        }
+    }
+
+    // a network load balancer terminating tls may still pass it on to the instances as tcp, and the other way around
+    if !slices.Contains(protocols, trafficProtocol) {
+        return "", fmt.Errorf("%w, %s instance traffic behind a %s load balancer", ErrLoadBalancerTypeProtocol, trafficProtocol, lbType)
+    }
+
+    return lbType, nil
+}
+
+// supportsSecurityGroups reports whether a load balancer of the type can have security groups.
+func supportsSecurityGroups(lbType types.LoadBalancerTypeEnum) bool {
+    return lbType != types.LoadBalancerTypeEnumGateway
+}
+
+// ipProtocols returns the ip protocols the traffic of a load balancer protocol goes over, tcp ones use fallback.
+func ipProtocols(protocol types.ProtocolEnum, fallback string) []string {
+    switch protocol {
+    case types.ProtocolEnumUdp:
+        return []string{"udp"}
+    case types.ProtocolEnumTcpUdp:
+        return []string{"tcp", "udp"}
+    case types.ProtocolEnumGeneve:
+        return []string{"udp"}
+    default:
+        return []string{fallback}
+    }
+}
+
+// existingListenerPort returns the port of an existing listener, GatewayListenerPort for gateway listeners.
+func existingListenerPort(listener types.Listener) int32 {
+    if port := memory.Unwrap(listener.Port); port > 0 {
+        return port
+    }
+
+    return GatewayListenerPort
+}
+
+// validateLoadBalancerType checks the rest of the options are possible on the type of load balancer.
+func validateLoadBalancerType(opts SetupNewILBServiceOptions) error {
+    if opts.LoadBalancerType == types.LoadBalancerTypeEnumGateway &&
+        (len(opts.DomainNames) > 0 || len(opts.CertificateNames) > 0 || len(opts.SharedLoadBalancer) > 0) {
+        return ErrGatewayLoadBalancerUnsupported
+    }
+
+    if opts.LoadBalancerType == types.LoadBalancerTypeEnumGateway &&
+        (len(opts.Listeners) != 1 || opts.Listeners[0].Port != GatewayListenerPort) {
+        return ErrGatewayLoadBalancerListener
+    }
+
+    if opts.LoadBalancerType != types.LoadBalancerTypeEnumApplication && len(opts.SharedLoadBalancer) > 0 {
+        return ErrSharedLoadBalancerNeedsRules
+    }
+
+    if opts.LoadBalancerType != types.LoadBalancerTypeEnumApplication && opts.Canary > 0 {
+        return ErrCanaryNeedsApplicationLoadBalancer
+    }
+
+    if len(opts.AlpnPolicy) > 0 {
+        if !slices.Contains(AlpnPolicies, opts.AlpnPolicy) {
+            return fmt.Errorf("%w, got '%s'", ErrInvalidAlpnPolicy, opts.AlpnPolicy)
+        }
+
+        if !slices.ContainsFunc(opts.Listeners, func(listener ListenerOptions) bool {
+            return listener.Protocol == types.ProtocolEnumTls
+        }) {
+            return ErrAlpnPolicyNeedsTLSListener
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,73 @@
+package service_test
+
+import (
+    "testing"
+
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/service"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestResolveLoadBalancerType(t *testing.T) {
+    tests := []struct {
+        requested       types.LoadBalancerTypeEnum
+        protocols       []types.ProtocolEnum
+        trafficProtocol types.ProtocolEnum
+        expected        types.LoadBalancerTypeEnum
+        err             error
+    }{
+        {"", []types.ProtocolEnum{types.ProtocolEnumHttps, types.ProtocolEnumHttp}, types.ProtocolEnumHttp, types.LoadBalancerTypeEnumApplication, nil},
+        {"", []types.ProtocolEnum{types.ProtocolEnumTcp}, types.ProtocolEnumTcp, types.LoadBalancerTypeEnumNetwork, nil},
+        {"", []types.ProtocolEnum{types.ProtocolEnumTls}, types.ProtocolEnumTcp, types.LoadBalancerTypeEnumNetwork, nil},
+        {"", []types.ProtocolEnum{types.ProtocolEnumUdp}, types.ProtocolEnumUdp, types.LoadBalancerTypeEnumNetwork, nil},
+        {types.LoadBalancerTypeEnumGateway, []types.ProtocolEnum{types.ProtocolEnumGeneve}, types.ProtocolEnumGeneve, types.LoadBalancerTypeEnumGateway, nil},
+        {"", []types.ProtocolEnum{types.ProtocolEnumHttps, types.ProtocolEnumTcp}, types.ProtocolEnumHttp, "", service.ErrListenersNeedSeveralTypes},
+        {types.LoadBalancerTypeEnumApplication, []types.ProtocolEnum{types.ProtocolEnumTcp}, types.ProtocolEnumTcp, "", service.ErrLoadBalancerTypeProtocol},
+        {"", []types.ProtocolEnum{types.ProtocolEnumTls}, types.ProtocolEnumHttp, "", service.ErrLoadBalancerTypeProtocol},
+    }
+
+    for _, test := range tests {
+        var listeners []service.ListenerOptions
+
+        for i, protocol := range test.protocols {
+            listeners = append(listeners, service.ListenerOptions{Port: int32(8000 + i), Protocol: protocol})
+        }
+
+        lbType, err := service.ResolveLoadBalancerType(test.requested, listeners, test.trafficProtocol)
+
+        assert.ErrorIs(t, err, test.err, "protocols: %v", test.protocols)
+        assert.Equal(t, test.expected, lbType, "protocols: %v", test.protocols)
+    }
+}
+
+func TestParseLoadBalancerType(t *testing.T) {
+    lbType, err := service.ParseLoadBalancerType("Network")
+
+    assert.NoError(t, err)
+    assert.Equal(t, types.LoadBalancerTypeEnumNetwork, lbType)
+
+    _, err = service.ParseLoadBalancerType("classic")
+
+    assert.ErrorIs(t, err, service.ErrInvalidLoadBalancerType)
+}
+
+func TestParseIpAddressType(t *testing.T) {
+    tests := []struct {
+        s        string
+        expected types.IpAddressType
+        err      error
+    }{
+        {"", "", nil},
+        {"ipv4", types.IpAddressTypeIpv4, nil},
+        {"DualStack", types.IpAddressTypeDualstack, nil},
+        {"ipv6", "", service.ErrInvalidIpAddressType},
+        {"dualstack-without-public-ipv4", "", service.ErrInvalidIpAddressType},
+    }
+
+    for _, test := range tests {
+        ipAddressType, err := service.ParseIpAddressType(test.s)
+
+        assert.ErrorIs(t, err, test.err, "ip address type: %s", test.s)
+        assert.Equal(t, test.expected, ipAddressType, "ip address type: %s", test.s)
+    }
+}
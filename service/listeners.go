@@ -0,0 +1,620 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "slices"
+    "strconv"
+    "strings"
+
+    elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+var (
+    ErrNoListeners                    = errors.New("at least one listener is required")
+    ErrDuplicateListenerPort          = errors.New("only one listener may use each port")
+    ErrInvalidListenerDefinition      = errors.New("listener must be in format <load balancer port>:<instance port>:<load balancer protocol>:<instance protocol>")
+    ErrListenersMustShareTraffic      = errors.New("all listeners must forward to the same instance port and protocol")
+    ErrHTTPRedirectNeedsHTTPSListener = errors.New("redirecting http requires an https listener")
+)
+
+// ListenerOptions is a load balancer listener of a service.
+type ListenerOptions struct {
+    Port     int32              `json:"port"`
+    Protocol types.ProtocolEnum `json:"protocol"`
+    // RedirectPort, when set, makes the listener redirect every request to https on that port instead of forwarding it
+    // to the service's instances.
+    RedirectPort int32 `json:"redirect_port,omitempty"`
+
+    // notFound makes the listener answer requests that match none of its rules with a 404, for listeners that are
+    // shared between services.
+    notFound bool
+}
+
+// usesCertificates reports whether the listener terminates tls, and so needs the service's certificates.
+func (l ListenerOptions) usesCertificates() bool {
+    return l.Protocol == types.ProtocolEnumHttps || l.Protocol == types.ProtocolEnumTls
+}
+
+func forwardActions(targetGroupArn string) []types.Action {
+    return []types.Action{{
+        Type: types.ActionTypeEnumForward,
+        ForwardConfig: &types.ForwardActionConfig{
+            TargetGroups: []types.TargetGroupTuple{
+                {
+                    TargetGroupArn: memory.Pointer(targetGroupArn),
+                },
+            },
+        },
+    }}
+}
+
+// weightedForwardActions returns the actions splitting requests between the target group and the canary target group,
+// canaryWeight percent of them going to the canary.
+func weightedForwardActions(targetGroupArn string, canaryTargetGroupArn string, canaryWeight int32) []types.Action {
+    return []types.Action{{
+        Type: types.ActionTypeEnumForward,
+        ForwardConfig: &types.ForwardActionConfig{
+            TargetGroups: []types.TargetGroupTuple{
+                {
+                    TargetGroupArn: memory.Pointer(targetGroupArn),
+                    Weight:         memory.Pointer(100 - canaryWeight),
+                },
+                {
+                    TargetGroupArn: memory.Pointer(canaryTargetGroupArn),
+                    Weight:         memory.Pointer(canaryWeight),
+                },
+            },
+        },
+    }}
+}
+
+// serviceForwardActions returns the actions forwarding requests to the service, split between its target group and its
+// canary target group while it has a canary.
+func serviceForwardActions(opts SetupNewILBServiceOptions, resources *ILBServiceResources) []types.Action {
+    if opts.Canary == 0 {
+        return forwardActions(resources.TargetGroupArn)
+    }
+
+    return weightedForwardActions(resources.TargetGroupArn, resources.CanaryTargetGroupArn, opts.Canary)
+}
+
+// plannedForwardActions is serviceForwardActions for describing a plan, in which the target groups may not exist yet.
+func plannedForwardActions(opts SetupNewILBServiceOptions, resources ILBServiceResources) []types.Action {
+    if len(resources.TargetGroupArn) == 0 {
+        resources.TargetGroupArn = KnownAfterApply
+    }
+
+    if len(resources.CanaryTargetGroupArn) == 0 {
+        resources.CanaryTargetGroupArn = KnownAfterApply
+    }
+
+    return serviceForwardActions(opts, &resources)
+}
+
+// defaultActions returns the listener's default actions, forward being where the service's requests are forwarded to.
+func (l ListenerOptions) defaultActions(forward []types.Action) []types.Action {
+    if l.RedirectPort > 0 {
+        return []types.Action{{
+            Type: types.ActionTypeEnumRedirect,
+            RedirectConfig: &types.RedirectActionConfig{
+                Protocol:   memory.Pointer(string(types.ProtocolEnumHttps)),
+                Port:       memory.Pointer(strconv.Itoa(int(l.RedirectPort))),
+                StatusCode: types.RedirectActionStatusCodeEnumHttp301,
+            },
+        }}
+    }
+
+    if l.notFound {
+        return []types.Action{{
+            Type: types.ActionTypeEnumFixedResponse,
+            FixedResponseConfig: &types.FixedResponseActionConfig{
+                StatusCode:  memory.Pointer("404"),
+                ContentType: memory.Pointer("text/plain"),
+                MessageBody: memory.Pointer("not found"),
+            },
+        }}
+    }
+
+    return forward
+}
+
+// describeAction describes what the listener does with requests, comparable to describeListenerAction.
+func (l ListenerOptions) describeAction(forward []types.Action) string {
+    if l.RedirectPort > 0 {
+        return fmt.Sprintf("redirect to %s:%d", types.ProtocolEnumHttps, l.RedirectPort)
+    }
+
+    if l.notFound {
+        return "respond 404"
+    }
+
+    return describeForward(forward)
+}
+
+// alpnPolicy returns the alpn policy the listener should have, only tls listeners have one.
+func (l ListenerOptions) alpnPolicy(opts SetupNewILBServiceOptions) []string {
+    if l.Protocol != types.ProtocolEnumTls || len(opts.AlpnPolicy) == 0 {
+        return nil
+    }
+
+    return []string{opts.AlpnPolicy}
+}
+
+func parseListenerPort(s string) (int32, error) {
+    port, err := strconv.ParseInt(s, 10, 32)
+
+    if err != nil || port < 1 || port > 65535 {
+        return 0, fmt.Errorf("%w, invalid port '%s'", ErrInvalidListenerDefinition, s)
+    }
+
+    return int32(port), nil
+}
+
+func parseListenerProtocol(s string) (types.ProtocolEnum, error) {
+    protocol := types.ProtocolEnum(strings.ToUpper(s))
+
+    if !slices.Contains(protocol.Values(), protocol) {
+        return "", fmt.Errorf("%w, invalid protocol '%s'", ErrInvalidListenerDefinition, s)
+    }
+
+    return protocol, nil
+}
+
+// ParseListenerDefinitions parses listeners in the format
+// <load balancer port>:<instance port>:<load balancer protocol>:<instance protocol>, returning them along with the
+// instance port and protocol they all share. With redirectHTTP, a listener redirecting http on port 80 to the first
+// https listener is added.
+func ParseListenerDefinitions(definitions []string, redirectHTTP bool) ([]ListenerOptions, int32, types.ProtocolEnum, error) {
+    var (
+        listeners       []ListenerOptions
+        trafficPort     int32
+        trafficProtocol types.ProtocolEnum
+    )
+
+    for _, definition := range definitions {
+        parts := strings.Split(definition, ":")
+
+        if len(parts) != 4 {
+            return nil, 0, "", fmt.Errorf("%w, got '%s'", ErrInvalidListenerDefinition, definition)
+        }
+
+        port, err := parseListenerPort(parts[0])
+
+        if err != nil {
+            return nil, 0, "", err
+        }
+
+        instancePort, err := parseListenerPort(parts[1])
+
+        if err != nil {
+            return nil, 0, "", err
+        }
+
+        protocol, err := parseListenerProtocol(parts[2])
+
+        if err != nil {
+            return nil, 0, "", err
+        }
+
+        instanceProtocol, err := parseListenerProtocol(parts[3])
+
+        if err != nil {
+            return nil, 0, "", err
+        }
+
+        if len(listeners) > 0 && (instancePort != trafficPort || instanceProtocol != trafficProtocol) {
+            return nil, 0, "", ErrListenersMustShareTraffic
+        }
+
+        trafficPort, trafficProtocol = instancePort, instanceProtocol
+
+        listeners = append(listeners, ListenerOptions{
+            Port:     port,
+            Protocol: protocol,
+        })
+    }
+
+    if len(listeners) == 0 {
+        return nil, 0, "", ErrNoListeners
+    }
+
+    if redirectHTTP {
+        i := slices.IndexFunc(listeners, func(listener ListenerOptions) bool {
+            return listener.Protocol == types.ProtocolEnumHttps
+        })
+
+        if i == -1 {
+            return nil, 0, "", ErrHTTPRedirectNeedsHTTPSListener
+        }
+
+        listeners = append(listeners, ListenerOptions{
+            Port:         80,
+            Protocol:     types.ProtocolEnumHttp,
+            RedirectPort: listeners[i].Port,
+        })
+    }
+
+    ports := make(map[int32]struct{})
+
+    for _, listener := range listeners {
+        if _, ok := ports[listener.Port]; ok {
+            return nil, 0, "", fmt.Errorf("%w, port %d is used more than once", ErrDuplicateListenerPort, listener.Port)
+        }
+
+        ports[listener.Port] = struct{}{}
+    }
+
+    return listeners, trafficPort, trafficProtocol, nil
+}
+
+func listenerName(loadBalancerName string, port int32) string {
+    return fmt.Sprintf("%s:%d", loadBalancerName, port)
+}
+
+// forwardTargetGroups returns the target groups the actions forward everything to, if they are a single forward
+// action.
+func forwardTargetGroups(actions []types.Action) []types.TargetGroupTuple {
+    if len(actions) != 1 || actions[0].Type != types.ActionTypeEnumForward {
+        return nil
+    }
+
+    action := actions[0]
+
+    if action.ForwardConfig != nil && len(action.ForwardConfig.TargetGroups) > 0 {
+        return action.ForwardConfig.TargetGroups
+    }
+
+    if arn := memory.Unwrap(action.TargetGroupArn); len(arn) > 0 {
+        return []types.TargetGroupTuple{{TargetGroupArn: action.TargetGroupArn}}
+    }
+
+    return nil
+}
+
+// forwardsTo reports whether the actions forward to the target group, alone or splitting requests with a canary target
+// group. Aws doesn't keep the target groups of a split in any particular order, so they are matched by arn.
+func forwardsTo(actions []types.Action, targetGroupArn string) bool {
+    if len(targetGroupArn) == 0 {
+        return false
+    }
+
+    return slices.ContainsFunc(forwardTargetGroups(actions), func(targetGroup types.TargetGroupTuple) bool {
+        return memory.Unwrap(targetGroup.TargetGroupArn) == targetGroupArn
+    })
+}
+
+// describeForward describes where forward actions send requests, comparable between existing and planned actions.
+func describeForward(actions []types.Action) string {
+    targetGroups := forwardTargetGroups(actions)
+
+    if len(targetGroups) == 0 {
+        return "forward to (none)"
+    }
+
+    // a lone target group gets every request, whatever its weight
+    if len(targetGroups) == 1 {
+        return fmt.Sprintf("forward to %s", memory.Unwrap(targetGroups[0].TargetGroupArn))
+    }
+
+    var split []string
+
+    for _, targetGroup := range targetGroups {
+        split = append(split, fmt.Sprintf("%s (weight %d)", memory.Unwrap(targetGroup.TargetGroupArn), memory.Unwrap(targetGroup.Weight)))
+    }
+
+    // sorted by arn, so that the weights of the same target groups are compared whatever order aws lists them in
+    slices.Sort(split)
+
+    return fmt.Sprintf("forward to %s", strings.Join(split, ", "))
+}
+
+// describeListenerAction describes what an existing listener does with requests, comparable to
+// ListenerOptions.describeAction.
+func describeListenerAction(listener types.Listener) string {
+    if len(listener.DefaultActions) == 1 && listener.DefaultActions[0].RedirectConfig != nil {
+        redirect := listener.DefaultActions[0].RedirectConfig
+
+        return fmt.Sprintf("redirect to %s:%s", memory.Unwrap(redirect.Protocol), memory.Unwrap(redirect.Port))
+    }
+
+    if len(listener.DefaultActions) == 1 && listener.DefaultActions[0].FixedResponseConfig != nil {
+        return fmt.Sprintf("respond %s", memory.Unwrap(listener.DefaultActions[0].FixedResponseConfig.StatusCode))
+    }
+
+    return describeForward(listener.DefaultActions)
+}
+
+func certificateArn(certs []types.Certificate) string {
+    if len(certs) == 0 {
+        return ""
+    }
+
+    return memory.Unwrap(certs[0].CertificateArn)
+}
+
+// planListeners makes the load balancer have exactly the service's listeners, modifying existing listeners in place
+// instead of recreating them.
+func (svc *AwsumILBService) planListeners(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    listeners []types.Listener,
+    certs []types.Certificate,
+) error {
+    var (
+        name     = opts.LoadBalancerResourceName()
+        existing = make(map[int32]types.Listener)
+        desired  = make(map[int32]struct{})
+    )
+
+    for _, listener := range opts.Listeners {
+        desired[listener.Port] = struct{}{}
+    }
+
+    for _, listener := range listeners {
+        port := existingListenerPort(listener)
+
+        if _, ok := desired[port]; ok {
+            existing[port] = listener
+            continue
+        }
+
+        plan.add(PlanActionDelete, "listener", listenerName(name, port), func(ctx context.Context, _ *ILBServiceResources) error {
+            _, err := svc.ELBv2.Client().DeleteListener(ctx, &elbv2.DeleteListenerInput{
+                ListenerArn: listener.ListenerArn,
+            })
+
+            return err
+        })
+    }
+
+    for _, listener := range opts.Listeners {
+        var listenerCerts []types.Certificate
+
+        if listener.usesCertificates() {
+            listenerCerts = certs
+        }
+
+        if current, ok := existing[listener.Port]; ok {
+            if err := svc.planListenerUpdate(opts, plan, listener, current, listenerCerts); err != nil {
+                return err
+            }
+        } else {
+            svc.planListenerCreation(opts, plan, listener, listenerCerts)
+        }
+    }
+
+    return nil
+}
+
+func (svc *AwsumILBService) planListenerCreation(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    listener ListenerOptions,
+    certs []types.Certificate,
+) {
+    var (
+        name = listenerName(opts.LoadBalancerResourceName(), listener.Port)
+        // a listener takes exactly one default certificate, the rest are added to it separately
+        defaultCert []types.Certificate
+    )
+
+    if len(certs) > 0 {
+        defaultCert = certs[:1]
+    }
+
+    changes := []string{fmt.Sprintf("protocol: %s", listener.Protocol)}
+
+    if len(defaultCert) > 0 {
+        changes = append(changes, fmt.Sprintf("certificate: %s", certificateArn(defaultCert)))
+    }
+
+    changes = append(changes, listener.describeAction(plannedForwardActions(opts, plan.Resources)))
+
+    alpnPolicy := listener.alpnPolicy(opts)
+
+    if len(alpnPolicy) > 0 {
+        changes = append(changes, fmt.Sprintf("alpn policy: %s", alpnPolicy[0]))
+    }
+
+    plan.add(PlanActionCreate, "listener", name, func(ctx context.Context, resources *ILBServiceResources) error {
+        tags, err := svc.creationTags(ctx, opts.loadBalancerTags())
+
+        if err != nil {
+            return err
+        }
+
+        input := &elbv2.CreateListenerInput{
+            LoadBalancerArn: memory.Pointer(resources.LoadBalancerArn),
+            Port:            memory.Pointer(listener.Port),
+            Protocol:        listener.Protocol,
+            Certificates:    defaultCert,
+            AlpnPolicy:      alpnPolicy,
+            DefaultActions:  listener.defaultActions(serviceForwardActions(opts, resources)),
+            Tags:            elbv2Tags(tags),
+        }
+
+        // gateway load balancer listeners take all traffic, they can't have a port or protocol
+        if opts.LoadBalancerType == types.LoadBalancerTypeEnumGateway {
+            input.Port, input.Protocol = nil, ""
+        }
+
+        clOutput, err := svc.ELBv2.Client().CreateListener(ctx, input)
+
+        if err != nil {
+            return err
+        }
+
+        resources.ListenerArns[listener.Port] = memory.Unwrap(clOutput.Listeners[0].ListenerArn)
+
+        return nil
+    }, changes...)
+
+    if len(certs) > 1 {
+        var certChanges []string
+
+        for _, cert := range certs[1:] {
+            certChanges = append(certChanges, fmt.Sprintf("+ %s", memory.Unwrap(cert.CertificateArn)))
+        }
+
+        plan.add(PlanActionCreate, "listener certificates", name, func(ctx context.Context, resources *ILBServiceResources) error {
+            _, err := svc.ELBv2.Client().AddListenerCertificates(ctx, &elbv2.AddListenerCertificatesInput{
+                ListenerArn:  memory.Pointer(resources.ListenerArns[listener.Port]),
+                Certificates: certs[1:],
+            })
+
+            return err
+        }, certChanges...)
+    }
+}
+
+func (svc *AwsumILBService) planListenerUpdate(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    listener ListenerOptions,
+    current types.Listener,
+    certs []types.Certificate,
+) error {
+    var (
+        name        = listenerName(opts.LoadBalancerResourceName(), listener.Port)
+        defaultCert []types.Certificate
+        changes     []string
+    )
+
+    plan.Resources.ListenerArns[listener.Port] = memory.Unwrap(current.ListenerArn)
+
+    if len(certs) > 0 {
+        defaultCert = certs[:1]
+    }
+
+    var (
+        currentDefaultCert = certificateArn(current.Certificates)
+        desiredDefaultCert = certificateArn(defaultCert)
+        currentAction      = describeListenerAction(current)
+        desiredAction      = listener.describeAction(plannedForwardActions(opts, plan.Resources))
+    )
+
+    gateway := opts.LoadBalancerType == types.LoadBalancerTypeEnumGateway
+
+    if !gateway && current.Protocol != listener.Protocol {
+        changes = append(changes, fmt.Sprintf("protocol: %s -> %s", current.Protocol, listener.Protocol))
+    }
+
+    alpnPolicy := listener.alpnPolicy(opts)
+
+    if currentAlpnPolicy := strings.Join(current.AlpnPolicy, ","); len(alpnPolicy) > 0 && currentAlpnPolicy != alpnPolicy[0] {
+        changes = append(changes, fmt.Sprintf("alpn policy: %s -> %s", orNone(currentAlpnPolicy), alpnPolicy[0]))
+    }
+
+    if currentDefaultCert != desiredDefaultCert {
+        changes = append(changes, fmt.Sprintf("certificate: %s -> %s", orNone(currentDefaultCert), orNone(desiredDefaultCert)))
+    }
+
+    if currentAction != desiredAction {
+        changes = append(changes, fmt.Sprintf("%s -> %s", currentAction, desiredAction))
+    }
+
+    if len(changes) > 0 {
+        plan.add(PlanActionModify, "listener", name, func(ctx context.Context, resources *ILBServiceResources) error {
+            input := &elbv2.ModifyListenerInput{
+                ListenerArn:    current.ListenerArn,
+                Port:           memory.Pointer(listener.Port),
+                Protocol:       listener.Protocol,
+                Certificates:   defaultCert,
+                AlpnPolicy:     alpnPolicy,
+                DefaultActions: listener.defaultActions(serviceForwardActions(opts, resources)),
+            }
+
+            if gateway {
+                input.Port, input.Protocol = nil, ""
+            }
+
+            _, err := svc.ELBv2.Client().ModifyListener(ctx, input)
+
+            return err
+        }, changes...)
+    }
+
+    // additional (non-default) certificates
+
+    if len(certs) == 0 {
+        return nil
+    }
+
+    attached, err := svc.ELBv2.GetAllListenerCertificates(opts.Ctx, memory.Unwrap(current.ListenerArn))
+
+    if err != nil {
+        return err
+    }
+
+    var (
+        toAdd          []types.Certificate
+        toRemove       []types.Certificate
+        certChanges    []string
+        attachedArns   = make(map[string]struct{})
+        desiredArns    = make(map[string]struct{})
+        defaultChanges = currentDefaultCert != desiredDefaultCert
+    )
+
+    for _, cert := range certs {
+        desiredArns[memory.Unwrap(cert.CertificateArn)] = struct{}{}
+    }
+
+    for _, cert := range attached {
+        arn := memory.Unwrap(cert.CertificateArn)
+
+        if memory.Unwrap(cert.IsDefault) {
+            // a replaced default certificate isn't kept in the listener's certificate list
+            if !defaultChanges {
+                attachedArns[arn] = struct{}{}
+            }
+
+            continue
+        }
+
+        attachedArns[arn] = struct{}{}
+
+        if _, ok := desiredArns[arn]; !ok {
+            toRemove = append(toRemove, types.Certificate{CertificateArn: cert.CertificateArn})
+            certChanges = append(certChanges, fmt.Sprintf("- %s", arn))
+        }
+    }
+
+    for _, cert := range certs[1:] {
+        if _, ok := attachedArns[memory.Unwrap(cert.CertificateArn)]; !ok {
+            toAdd = append(toAdd, cert)
+            certChanges = append(certChanges, fmt.Sprintf("+ %s", memory.Unwrap(cert.CertificateArn)))
+        }
+    }
+
+    if len(certChanges) == 0 {
+        return nil
+    }
+
+    plan.add(PlanActionModify, "listener certificates", name, func(ctx context.Context, _ *ILBServiceResources) error {
+        if len(toAdd) > 0 {
+            if _, err := svc.ELBv2.Client().AddListenerCertificates(ctx, &elbv2.AddListenerCertificatesInput{
+                ListenerArn:  current.ListenerArn,
+                Certificates: toAdd,
+            }); err != nil {
+                return err
+            }
+        }
+
+        if len(toRemove) > 0 {
+            if _, err := svc.ELBv2.Client().RemoveListenerCertificates(ctx, &elbv2.RemoveListenerCertificatesInput{
+                ListenerArn:  current.ListenerArn,
+                Certificates: toRemove,
+            }); err != nil {
+                return err
+            }
+        }
+
+        return nil
+    }, certChanges...)
+
+    return nil
+}
@@ -0,0 +1,47 @@
+package service_test
+
+import (
+    "testing"
+
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/service"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestParseListenerDefinitions(t *testing.T) {
+    listeners, trafficPort, trafficProtocol, err := service.ParseListenerDefinitions(
+        []string{"443:8080:https:http", "8443:8080:HTTPS:HTTP"},
+        true,
+    )
+
+    assert.NoError(t, err)
+    assert.Equal(t, int32(8080), trafficPort)
+    assert.Equal(t, types.ProtocolEnumHttp, trafficProtocol)
+    assert.Equal(t, []service.ListenerOptions{
+        {Port: 443, Protocol: types.ProtocolEnumHttps},
+        {Port: 8443, Protocol: types.ProtocolEnumHttps},
+        {Port: 80, Protocol: types.ProtocolEnumHttp, RedirectPort: 443},
+    }, listeners)
+}
+
+func TestParseListenerDefinitions_Invalid(t *testing.T) {
+    tests := []struct {
+        definitions  []string
+        redirectHTTP bool
+        err          error
+    }{
+        {nil, false, service.ErrNoListeners},
+        {[]string{"443:80:https"}, false, service.ErrInvalidListenerDefinition},
+        {[]string{"443:80:https:nope"}, false, service.ErrInvalidListenerDefinition},
+        {[]string{"70000:80:http:http"}, false, service.ErrInvalidListenerDefinition},
+        {[]string{"443:80:https:http", "80:81:http:http"}, false, service.ErrListenersMustShareTraffic},
+        {[]string{"80:80:http:http"}, true, service.ErrHTTPRedirectNeedsHTTPSListener},
+        {[]string{"443:80:https:http", "80:80:http:http"}, true, service.ErrDuplicateListenerPort},
+    }
+
+    for _, test := range tests {
+        _, _, _, err := service.ParseListenerDefinitions(test.definitions, test.redirectHTTP)
+
+        assert.ErrorIs(t, err, test.err, "definitions: %v", test.definitions)
+    }
+}
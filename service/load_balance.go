@@ -7,18 +7,23 @@ import (
     "maps"
     "slices"
     "strings"
+    "sync"
+    "time"
 
     "github.com/aws/aws-sdk-go-v2/aws"
-    "github.com/aws/aws-sdk-go-v2/service/ec2"
-    ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
     elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
     "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    route53Types "github.com/aws/aws-sdk-go-v2/service/route53/types"
     "github.com/levelshatter/awsum/internal/memory"
 )
 
+// TargetHealthyTimeout is how long new targets are given to become healthy before removed targets would be drained.
+const TargetHealthyTimeout = time.Minute * 10
+
 var (
     ErrTargetGroupNotReturnedAfterCreation = errors.New("target group not returned after creation")
     ErrTargetInstancesMustAllBeInSameVPC   = errors.New("target instances must all be in the same vpc")
+    ErrNoTargetInstances                   = errors.New("no running instances matched the target filters")
 )
 
 // AwsumILBService is a struct used to encompass all the logic of services on instances that are load balanced by
@@ -28,6 +33,16 @@ type AwsumILBService struct {
     ELBv2   *ELBv2
     ACM     *ACM
     Route53 *Route53
+    STS     *STS
+    // State is where the resources of every service are recorded, the state file in the awsum data directory when nil.
+    State StateBackend
+
+    // caller is the arn of the identity awsum calls aws as, once asked for.
+    caller   string
+    callerMu sync.Mutex
+    // stateHolds counts what is holding the lock of the state, which is only released once nothing is.
+    stateHolds int
+    stateMu    sync.Mutex
 }
 
 func NewAwsumILBService(awsConfig aws.Config) *AwsumILBService {
@@ -36,6 +51,7 @@ func NewAwsumILBService(awsConfig aws.Config) *AwsumILBService {
         ELBv2:   NewELBv2(awsConfig),
         ACM:     NewACM(awsConfig),
         Route53: NewRoute53(awsConfig),
+        STS:     NewSTS(awsConfig),
     }
 }
 
@@ -48,349 +64,966 @@ type CreateInstanceTargetGroupOptions struct {
 }
 
 type SetupNewILBServiceOptions struct {
-    Ctx                          context.Context
-    ServiceName                  string
-    TargetInstanceFilters        InstanceFilters
-    LoadBalancerListenerProtocol types.ProtocolEnum
-    LoadBalancerIpProtocol       string
-    LoadBalancerPort             int32
-    TrafficPort                  int32
-    TrafficProtocol              types.ProtocolEnum
-    CertificateNames             []string
-    DomainNames                  []string
-    Private                      bool
+    Ctx                    context.Context
+    ServiceName            string
+    TargetInstanceFilters  InstanceFilters
+    Listeners              []ListenerOptions
+    LoadBalancerIpProtocol string
+    TrafficPort            int32
+    TrafficProtocol        types.ProtocolEnum
+    CertificateNames       []string
+    DomainNames            []string
+    Private                bool
+    HealthCheck            HealthCheckOptions
+    // Canary, when set, is the percentage of requests sent to a separate canary target group holding the newly matched
+    // instances, instead of adding them to the service's target group.
+    Canary int32
+    // LoadBalancerType is picked from the listener protocols when empty.
+    LoadBalancerType types.LoadBalancerTypeEnum
+    // AlpnPolicy is the application-layer protocol negotiation policy of tls listeners.
+    AlpnPolicy string
+    // SharedLoadBalancer is the name of a load balancer shared between services. Instead of a load balancer of its
+    // own, the service then gets listener rules on it that match Hosts and Paths.
+    SharedLoadBalancer string
+    Hosts              []string
+    Paths              []string
+    // Priority pins the priority of the service's listener rules on a shared load balancer, 0 picks a free one.
+    Priority int32
+    // AllowCIDRs are the ranges, ipv4 or ipv6, allowed to reach the listeners. Anywhere is allowed when empty, over
+    // ipv6 as well if IPv6 is set.
+    AllowCIDRs []string
+    IPv6       bool
+    // IpAddressType is ipv4 for a new load balancer when empty, and left as it is for an existing one. A dualstack
+    // load balancer takes ipv6 clients as well and gets AAAA aliases.
+    IpAddressType          types.IpAddressType
+    TargetGroupAttributes  TargetGroupAttributes
+    LoadBalancerAttributes LoadBalancerAttributes
+    // Tags are put on every resource of the service, on top of the ones awsum puts to know its resources by. The load
+    // balancer, listeners and security group of a shared load balancer only get awsum's.
+    Tags map[string]string
 }
 
-func (opts SetupNewILBServiceOptions) AwsumResourceName() string {
-    return fmt.Sprintf("awsum-ilb-svc-%s", opts.ServiceName)
+// HealthCheckOptions configures the target group health check. Zero values are left to the aws defaults when the
+// target group is created, and left as they are when it is updated.
+type HealthCheckOptions struct {
+    Path string `json:"path,omitempty"`
+    // Port is either a port number or "traffic-port".
+    Port               string             `json:"port,omitempty"`
+    Protocol           types.ProtocolEnum `json:"protocol,omitempty"`
+    Interval           time.Duration      `json:"interval,omitempty"`
+    HealthyThreshold   int32              `json:"healthy_threshold,omitempty"`
+    UnhealthyThreshold int32              `json:"unhealthy_threshold,omitempty"`
+    // Matcher is the http status codes that count as healthy, like "200" or "200-299".
+    Matcher string `json:"matcher,omitempty"`
 }
 
-type ILBServiceResources struct {
-    TargetGroupArn      string
-    SecurityGroupId     string
-    LoadBalancerArn     string
-    LoadBalancerDNSName string
+// apply sets the configured health check settings on a new target group.
+func (hc HealthCheckOptions) apply(input *elbv2.CreateTargetGroupInput) {
+    if len(hc.Path) > 0 {
+        input.HealthCheckPath = memory.Pointer(hc.Path)
+    }
+
+    if len(hc.Port) > 0 {
+        input.HealthCheckPort = memory.Pointer(hc.Port)
+    }
+
+    if len(hc.Protocol) > 0 {
+        input.HealthCheckProtocol = hc.Protocol
+    }
+
+    if hc.Interval > 0 {
+        input.HealthCheckIntervalSeconds = memory.Pointer(int32(hc.Interval.Seconds()))
+    }
+
+    if hc.HealthyThreshold > 0 {
+        input.HealthyThresholdCount = memory.Pointer(hc.HealthyThreshold)
+    }
+
+    if hc.UnhealthyThreshold > 0 {
+        input.UnhealthyThresholdCount = memory.Pointer(hc.UnhealthyThreshold)
+    }
+
+    if len(hc.Matcher) > 0 {
+        input.Matcher = &types.Matcher{HttpCode: memory.Pointer(hc.Matcher)}
+    }
 }
 
-func (svc *AwsumILBService) SetupNewILBService(opts SetupNewILBServiceOptions) (*ILBServiceResources, error) {
-    var resources ILBServiceResources
+// describe returns a line for each configured health check setting.
+func (hc HealthCheckOptions) describe() []string {
+    var lines []string
 
-    // target selection
+    if len(hc.Path) > 0 {
+        lines = append(lines, fmt.Sprintf("health check path: %s", hc.Path))
+    }
 
-    instances, err := svc.EC2.GetAllRunningInstances(opts.Ctx)
+    if len(hc.Port) > 0 {
+        lines = append(lines, fmt.Sprintf("health check port: %s", hc.Port))
+    }
 
-    if err != nil {
-        return nil, err
+    if len(hc.Protocol) > 0 {
+        lines = append(lines, fmt.Sprintf("health check protocol: %s", hc.Protocol))
     }
 
-    targetInstances := opts.TargetInstanceFilters.Matches(instances)
+    if hc.Interval > 0 {
+        lines = append(lines, fmt.Sprintf("health check interval: %ds", int32(hc.Interval.Seconds())))
+    }
+
+    if hc.HealthyThreshold > 0 {
+        lines = append(lines, fmt.Sprintf("healthy threshold: %d", hc.HealthyThreshold))
+    }
+
+    if hc.UnhealthyThreshold > 0 {
+        lines = append(lines, fmt.Sprintf("unhealthy threshold: %d", hc.UnhealthyThreshold))
+    }
 
+    if len(hc.Matcher) > 0 {
+        lines = append(lines, fmt.Sprintf("matcher: %s", hc.Matcher))
+    }
+
+    return lines
+}
+
+// differences returns the changes needed for the existing target group's health check to match, along with the
+// input to make them.
+func (hc HealthCheckOptions) differences(targetGroup *types.TargetGroup) ([]string, *elbv2.ModifyTargetGroupInput) {
     var (
-        vpcIdMap    = make(map[string]struct{})
-        subnetIdMap = make(map[string]struct{})
+        changes []string
+        input   = &elbv2.ModifyTargetGroupInput{TargetGroupArn: targetGroup.TargetGroupArn}
     )
 
-    for _, instance := range targetInstances {
-        vpcIdMap[memory.Unwrap(instance.Info.VpcId)] = struct{}{}
-        subnetIdMap[memory.Unwrap(instance.Info.SubnetId)] = struct{}{}
+    if current := memory.Unwrap(targetGroup.HealthCheckPath); len(hc.Path) > 0 && current != hc.Path {
+        changes = append(changes, fmt.Sprintf("health check path: %s -> %s", orNone(current), hc.Path))
+        input.HealthCheckPath = memory.Pointer(hc.Path)
     }
 
-    instanceVPCs := slices.Collect(maps.Keys(vpcIdMap))
-    instanceSubnets := slices.Collect(maps.Keys(subnetIdMap))
+    if current := memory.Unwrap(targetGroup.HealthCheckPort); len(hc.Port) > 0 && current != hc.Port {
+        changes = append(changes, fmt.Sprintf("health check port: %s -> %s", orNone(current), hc.Port))
+        input.HealthCheckPort = memory.Pointer(hc.Port)
+    }
 
-    if len(instanceVPCs) > 1 {
-        return nil, ErrTargetInstancesMustAllBeInSameVPC
+    if current := targetGroup.HealthCheckProtocol; len(hc.Protocol) > 0 && current != hc.Protocol {
+        changes = append(changes, fmt.Sprintf("health check protocol: %s -> %s", orNone(string(current)), hc.Protocol))
+        input.HealthCheckProtocol = hc.Protocol
     }
 
-    targetVPC := instanceVPCs[0]
+    if current := memory.Unwrap(targetGroup.HealthCheckIntervalSeconds); hc.Interval > 0 && current != int32(hc.Interval.Seconds()) {
+        changes = append(changes, fmt.Sprintf("health check interval: %ds -> %ds", current, int32(hc.Interval.Seconds())))
+        input.HealthCheckIntervalSeconds = memory.Pointer(int32(hc.Interval.Seconds()))
+    }
 
-    // remove old security group resources
+    if current := memory.Unwrap(targetGroup.HealthyThresholdCount); hc.HealthyThreshold > 0 && current != hc.HealthyThreshold {
+        changes = append(changes, fmt.Sprintf("healthy threshold: %d -> %d", current, hc.HealthyThreshold))
+        input.HealthyThresholdCount = memory.Pointer(hc.HealthyThreshold)
+    }
 
-    securityGroup, err := svc.EC2.SearchForSecurityGroupByName(opts.Ctx, opts.AwsumResourceName())
+    if current := memory.Unwrap(targetGroup.UnhealthyThresholdCount); hc.UnhealthyThreshold > 0 && current != hc.UnhealthyThreshold {
+        changes = append(changes, fmt.Sprintf("unhealthy threshold: %d -> %d", current, hc.UnhealthyThreshold))
+        input.UnhealthyThresholdCount = memory.Pointer(hc.UnhealthyThreshold)
+    }
+
+    var currentMatcher string
+
+    if targetGroup.Matcher != nil {
+        currentMatcher = memory.Unwrap(targetGroup.Matcher.HttpCode)
+    }
+
+    if len(hc.Matcher) > 0 && currentMatcher != hc.Matcher {
+        changes = append(changes, fmt.Sprintf("matcher: %s -> %s", orNone(currentMatcher), hc.Matcher))
+        input.Matcher = &types.Matcher{HttpCode: memory.Pointer(hc.Matcher)}
+    }
+
+    return changes, input
+}
+
+func (opts SetupNewILBServiceOptions) AwsumResourceName() string {
+    return AwsumILBResourceName(opts.ServiceName)
+}
+
+// LoadBalancerResourceName returns the name of the load balancer (and its security group) the service is on.
+func (opts SetupNewILBServiceOptions) LoadBalancerResourceName() string {
+    if len(opts.SharedLoadBalancer) > 0 {
+        return AwsumSharedLBResourceName(opts.SharedLoadBalancer)
+    }
+
+    return opts.AwsumResourceName()
+}
+
+// AwsumSharedLBResourceName returns the name of the load balancer, and its security group, shared between services.
+func AwsumSharedLBResourceName(name string) string {
+    return resourceName(fmt.Sprintf("awsum-ilb-shared-%s", name))
+}
+
+// AwsumILBResourceName returns the name of every aws resource awsum creates for the load-balanced service. Long service
+// names are cut short to fit, see resourceName.
+func AwsumILBResourceName(serviceName string) string {
+    return resourceName(fmt.Sprintf("awsum-ilb-svc-%s", serviceName))
+}
+
+type ILBServiceResources struct {
+    TargetGroupArn           string
+    CanaryTargetGroupArn     string
+    SecurityGroupId          string
+    InstanceSecurityGroupId  string
+    LoadBalancerArn          string
+    LoadBalancerDNSName      string
+    LoadBalancerHostedZoneId string
+    // ListenerArns holds the arn of each of the service's listeners, keyed by port.
+    ListenerArns map[int32]string
+}
+
+// resolveIpAddressType returns the ip address type the service's load balancer is to have: the one given, or else the
+// one it already has (ipv4 for a new one).
+func (svc *AwsumILBService) resolveIpAddressType(
+    opts SetupNewILBServiceOptions,
+    recorded ServiceState,
+) (types.IpAddressType, error) {
+    if len(opts.IpAddressType) > 0 {
+        return opts.IpAddressType, nil
+    }
+
+    loadBalancer, err := svc.findLoadBalancer(
+        opts.Ctx,
+        recorded.loadBalancerArn(opts.SharedLoadBalancer),
+        opts.LoadBalancerResourceName(),
+    )
+
+    if err != nil {
+        return "", err
+    }
+
+    if loadBalancer == nil {
+        return types.IpAddressTypeIpv4, nil
+    }
+
+    return loadBalancer.IpAddressType, nil
+}
+
+// isDualstack reports whether a load balancer of the ip address type takes ipv6 clients.
+func isDualstack(ipAddressType types.IpAddressType) bool {
+    return strings.HasPrefix(string(ipAddressType), string(types.IpAddressTypeDualstack))
+}
+
+// planLoadBalancer plans the creation of the service load balancer if it doesn't exist yet, returning its current
+// listeners otherwise.
+func (svc *AwsumILBService) planLoadBalancer(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    instanceSubnets []string,
+) ([]types.Listener, error) {
+    name := opts.LoadBalancerResourceName()
+
+    loadBalancer, err := svc.findLoadBalancer(opts.Ctx, plan.recorded.loadBalancerArn(opts.SharedLoadBalancer), name)
 
     if err != nil {
         return nil, err
     }
 
-    if securityGroup != nil {
-        rules, err := svc.EC2.GetAllSecurityGroupRules(opts.Ctx)
+    if loadBalancer != nil {
+        if loadBalancer.Type != opts.LoadBalancerType {
+            return nil, fmt.Errorf("%w, '%s' is %s instead of %s", ErrLoadBalancerTypeChanged, name, loadBalancer.Type, opts.LoadBalancerType)
+        }
+
+        plan.Resources.LoadBalancerArn = memory.Unwrap(loadBalancer.LoadBalancerArn)
+        plan.Resources.LoadBalancerDNSName = memory.Unwrap(loadBalancer.DNSName)
+        plan.Resources.LoadBalancerHostedZoneId = memory.Unwrap(loadBalancer.CanonicalHostedZoneId)
 
-        if err != nil {
+        // unlike its type, a load balancer's ip address type can be changed in place
+        if loadBalancer.IpAddressType != opts.IpAddressType {
+            plan.add(PlanActionModify, "load balancer", name, func(ctx context.Context, resources *ILBServiceResources) error {
+                _, err := svc.ELBv2.Client().SetIpAddressType(ctx, &elbv2.SetIpAddressTypeInput{
+                    LoadBalancerArn: memory.Pointer(resources.LoadBalancerArn),
+                    IpAddressType:   opts.IpAddressType,
+                })
+
+                return err
+            }, fmt.Sprintf("ip address type: %s -> %s", loadBalancer.IpAddressType, opts.IpAddressType))
+        }
+
+        if err = svc.planLoadBalancerAttributes(opts, plan, name, plan.Resources.LoadBalancerArn); err != nil {
             return nil, err
         }
 
-        var (
-            egressRuleIds  []string
-            ingressRuleIds []string
-        )
+        if err = svc.planELBTags(opts, plan, "load balancer", name, plan.Resources.LoadBalancerArn, opts.loadBalancerTags()); err != nil {
+            return nil, err
+        }
 
-        for _, rule := range rules {
-            if memory.Unwrap(rule.IsEgress) {
-                egressRuleIds = append(egressRuleIds, memory.Unwrap(rule.SecurityGroupRuleId))
-                continue
-            }
+        return svc.ELBv2.GetAllListenersInLoadBalancer(opts.Ctx, plan.Resources.LoadBalancerArn)
+    }
 
-            ingressRuleIds = append(ingressRuleIds, memory.Unwrap(rule.SecurityGroupRuleId))
-        }
+    allSubnets, err := svc.EC2.GetAllSubnets(opts.Ctx)
 
-        if len(egressRuleIds) > 0 {
-            if _, err = svc.EC2.Client().RevokeSecurityGroupEgress(opts.Ctx, &ec2.RevokeSecurityGroupEgressInput{
-                SecurityGroupRuleIds: egressRuleIds,
-            }); err != nil {
-                return nil, err
-            }
+    if err != nil {
+        return nil, err
+    }
+
+    subnetAzMap := make(map[string]string)
+
+    for _, subnet := range allSubnets {
+        subnetAzMap[memory.Unwrap(subnet.AvailabilityZone)] = memory.Unwrap(subnet.SubnetId)
+    }
+
+    azGroupedSubnets := slices.Collect(maps.Values(subnetAzMap))
+
+    lbConfig := &elbv2.CreateLoadBalancerInput{
+        Name:          memory.Pointer(name),
+        Type:          opts.LoadBalancerType,
+        Scheme:        types.LoadBalancerSchemeEnumInternetFacing,
+        Subnets:       append(instanceSubnets, azGroupedSubnets...),
+        IpAddressType: opts.IpAddressType,
+    }
+
+    if opts.Private {
+        lbConfig.Scheme = types.LoadBalancerSchemeEnumInternal
+    }
+
+    // gateway load balancers only ever receive traffic from endpoints in the vpc
+    if opts.LoadBalancerType == types.LoadBalancerTypeEnumGateway {
+        lbConfig.Scheme = ""
+    }
+
+    plan.add(PlanActionCreate, "load balancer", name, func(ctx context.Context, resources *ILBServiceResources) error {
+        if len(resources.SecurityGroupId) > 0 {
+            lbConfig.SecurityGroups = []string{resources.SecurityGroupId}
         }
 
-        if len(ingressRuleIds) > 0 {
-            if _, err = svc.EC2.Client().RevokeSecurityGroupIngress(opts.Ctx, &ec2.RevokeSecurityGroupIngressInput{
-                SecurityGroupRuleIds: ingressRuleIds,
-            }); err != nil {
-                return nil, err
-            }
+        tags, err := svc.creationTags(ctx, opts.loadBalancerTags())
+
+        if err != nil {
+            return err
         }
 
-        resources.SecurityGroupId = memory.Unwrap(securityGroup.GroupId)
-    } else {
-        // setup service security group
+        lbConfig.Tags = elbv2Tags(tags)
 
-        cesgOutput, err := svc.EC2.CreateEmptySecurityGroup(opts.Ctx, opts.AwsumResourceName())
+        clbOutput, err := svc.ELBv2.Client().CreateLoadBalancer(ctx, lbConfig)
 
         if err != nil {
-            return nil, err
+            return err
         }
 
-        resources.SecurityGroupId = memory.Unwrap(cesgOutput.GroupId)
-    }
-
-    _, err = svc.EC2.Client().AuthorizeSecurityGroupIngress(opts.Ctx, &ec2.AuthorizeSecurityGroupIngressInput{
-        GroupId: memory.Pointer(resources.SecurityGroupId),
-        IpPermissions: []ec2Types.IpPermission{
-            {
-                FromPort:   memory.Pointer(opts.TrafficPort),
-                ToPort:     memory.Pointer(opts.TrafficPort),
-                IpProtocol: memory.Pointer(opts.LoadBalancerIpProtocol),
-                IpRanges: []ec2Types.IpRange{
-                    {
-                        CidrIp:      memory.Pointer("0.0.0.0/0"),
-                        Description: memory.Pointer("all inbound traffic"),
-                    },
-                },
-            },
-        },
-    })
+        resources.LoadBalancerArn = memory.Unwrap(clbOutput.LoadBalancers[0].LoadBalancerArn)
+        resources.LoadBalancerDNSName = memory.Unwrap(clbOutput.LoadBalancers[0].DNSName)
+        resources.LoadBalancerHostedZoneId = memory.Unwrap(clbOutput.LoadBalancers[0].CanonicalHostedZoneId)
 
-    if err != nil && !strings.Contains(err.Error(), "already exists") {
-        return nil, err
-    }
+        return svc.modifyLoadBalancerAttributes(ctx, resources.LoadBalancerArn, opts.LoadBalancerAttributes.attributes())
+    }, append([]string{
+        fmt.Sprintf("type: %s", lbConfig.Type),
+        fmt.Sprintf("scheme: %s", lbConfig.Scheme),
+        fmt.Sprintf("ip address type: %s", lbConfig.IpAddressType),
+    }, describeAttributes(opts.LoadBalancerAttributes.attributes())...)...)
 
-    _, err = svc.EC2.Client().AuthorizeSecurityGroupIngress(opts.Ctx, &ec2.AuthorizeSecurityGroupIngressInput{
-        GroupId: memory.Pointer(resources.SecurityGroupId),
-        IpPermissions: []ec2Types.IpPermission{
-            {
-                FromPort:   memory.Pointer(opts.LoadBalancerPort),
-                ToPort:     memory.Pointer(opts.LoadBalancerPort),
-                IpProtocol: memory.Pointer(opts.LoadBalancerIpProtocol),
-                IpRanges: []ec2Types.IpRange{
-                    {
-                        CidrIp:      memory.Pointer("0.0.0.0/0"),
-                        Description: memory.Pointer("all inbound traffic"),
-                    },
-                },
-            },
-        },
-    })
+    return nil, nil
+}
 
-    if err != nil && !strings.Contains(err.Error(), "already exists") {
-        return nil, err
+// targetGroupDifferences describes why the existing target group can't be kept as is. A target group's protocol, port
+// and vpc can't be changed after creation.
+func targetGroupDifferences(targetGroup *types.TargetGroup, opts SetupNewILBServiceOptions, vpcId string) []string {
+    var differences []string
+
+    if targetGroup.Protocol != opts.TrafficProtocol {
+        differences = append(differences, fmt.Sprintf("protocol: %s -> %s", targetGroup.Protocol, opts.TrafficProtocol))
     }
 
-    _, err = svc.EC2.Client().AuthorizeSecurityGroupEgress(opts.Ctx, &ec2.AuthorizeSecurityGroupEgressInput{
-        GroupId: memory.Pointer(resources.SecurityGroupId),
-        IpPermissions: []ec2Types.IpPermission{
-            {
-                FromPort:   memory.Pointer(opts.TrafficPort),
-                ToPort:     memory.Pointer(opts.TrafficPort),
-                IpProtocol: memory.Pointer(opts.LoadBalancerIpProtocol),
-                IpRanges: []ec2Types.IpRange{
-                    {
-                        CidrIp:      memory.Pointer("0.0.0.0/0"),
-                        Description: memory.Pointer("all outbound traffic"),
-                    },
-                },
-            },
-        },
-    })
+    if port := memory.Unwrap(targetGroup.Port); port != opts.TrafficPort {
+        differences = append(differences, fmt.Sprintf("port: %d -> %d", port, opts.TrafficPort))
+    }
 
-    if err != nil && !strings.Contains(err.Error(), "already exists") {
-        return nil, err
+    if vpc := memory.Unwrap(targetGroup.VpcId); vpc != vpcId {
+        differences = append(differences, fmt.Sprintf("vpc: %s -> %s", vpc, vpcId))
     }
 
-    // remove conflicting load balancing resources
+    if targetGroup.TargetType != types.TargetTypeEnumInstance {
+        differences = append(differences, fmt.Sprintf("target type: %s -> %s", targetGroup.TargetType, types.TargetTypeEnumInstance))
+    }
 
-    loadBalancer, err := svc.ELBv2.SearchForLoadBalancerByName(opts.Ctx, opts.AwsumResourceName())
+    return differences
+}
+
+// planTargetGroup keeps the existing service target group if it is compatible with the options, otherwise it is
+// replaced (which does interrupt traffic, since the listeners forwarding to it have to be removed first). It returns
+// whether the target group is new, in which case so are all its targets and none of the listeners remain.
+func (svc *AwsumILBService) planTargetGroup(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    vpcId string,
+    listeners []types.Listener,
+) (bool, error) {
+    name := opts.AwsumResourceName()
+
+    targetGroup, err := svc.findTargetGroup(opts.Ctx, plan.recorded.TargetGroupArn, name)
 
     if err != nil {
-        return nil, err
+        return false, err
     }
 
-    if loadBalancer != nil {
-        listeners, err := svc.ELBv2.GetAllListenersInLoadBalancer(opts.Ctx, memory.Unwrap(loadBalancer.LoadBalancerArn))
+    if targetGroup != nil {
+        differences := targetGroupDifferences(targetGroup, opts, vpcId)
 
-        if err != nil {
-            return nil, err
+        if len(differences) == 0 {
+            plan.Resources.TargetGroupArn = memory.Unwrap(targetGroup.TargetGroupArn)
+
+            if changes, input := opts.HealthCheck.differences(targetGroup); len(changes) > 0 {
+                plan.add(PlanActionModify, "target group", name, func(ctx context.Context, _ *ILBServiceResources) error {
+                    _, err := svc.ELBv2.Client().ModifyTargetGroup(ctx, input)
+                    return err
+                }, changes...)
+            }
+
+            if err = svc.planTargetGroupAttributes(opts, plan, name, plan.Resources.TargetGroupArn); err != nil {
+                return false, err
+            }
+
+            if err = svc.planELBTags(opts, plan, "target group", name, plan.Resources.TargetGroupArn, opts.serviceTags()); err != nil {
+                return false, err
+            }
+
+            return false, nil
         }
 
-        for _, listener := range listeners {
-            if _, err = svc.ELBv2.Client().DeleteListener(opts.Ctx, &elbv2.DeleteListenerInput{
-                ListenerArn: listener.ListenerArn,
-            }); err != nil {
-                return nil, err
+        if len(opts.SharedLoadBalancer) > 0 {
+            // other services' listener rules stay, only the ones forwarding to this service go
+            plan.replacedTargetGroupArn = memory.Unwrap(targetGroup.TargetGroupArn)
+
+            for _, listener := range listeners {
+                if err = svc.planRuleRemoval(plan, opts.LoadBalancerResourceName(), listener, plan.replacedTargetGroupArn); err != nil {
+                    return false, err
+                }
+            }
+        } else {
+            for _, listener := range listeners {
+                listenerArn := listener.ListenerArn
+
+                plan.add(PlanActionDelete, "listener", listenerName(name, memory.Unwrap(listener.Port)), func(ctx context.Context, _ *ILBServiceResources) error {
+                    _, err := svc.ELBv2.Client().DeleteListener(ctx, &elbv2.DeleteListenerInput{
+                        ListenerArn: listenerArn,
+                    })
+
+                    return err
+                }, "forwards to the target group being replaced, traffic will be interrupted")
             }
         }
 
-        resources.LoadBalancerArn = memory.Unwrap(loadBalancer.LoadBalancerArn)
-        resources.LoadBalancerDNSName = memory.Unwrap(loadBalancer.DNSName)
-    } else {
-        // load balancer creation
+        targetGroupArn := targetGroup.TargetGroupArn
+
+        plan.add(PlanActionDelete, "target group", name, func(ctx context.Context, _ *ILBServiceResources) error {
+            _, err := svc.ELBv2.Client().DeleteTargetGroup(ctx, &elbv2.DeleteTargetGroupInput{
+                TargetGroupArn: targetGroupArn,
+            })
 
-        allSubnets, err := svc.EC2.GetAllSubnets(opts.Ctx)
+            return err
+        }, append([]string{"replaced because it can't be modified in place:"}, differences...)...)
+    }
+
+    svc.planTargetGroupCreation(opts, plan, name, vpcId, func(resources *ILBServiceResources, targetGroupArn string) {
+        resources.TargetGroupArn = targetGroupArn
+    })
+
+    return true, nil
+}
+
+// planTargetGroupCreation creates a target group for the service's instances, handing its arn to created.
+func (svc *AwsumILBService) planTargetGroupCreation(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    name string,
+    vpcId string,
+    created func(resources *ILBServiceResources, targetGroupArn string),
+) {
+    plan.add(PlanActionCreate, "target group", name, func(ctx context.Context, resources *ILBServiceResources) error {
+        tags, err := svc.creationTags(ctx, opts.serviceTags())
 
         if err != nil {
-            return nil, err
+            return err
+        }
+
+        input := &elbv2.CreateTargetGroupInput{
+            Name:       memory.Pointer(name),
+            Port:       memory.Pointer(opts.TrafficPort),
+            Protocol:   opts.TrafficProtocol,
+            VpcId:      memory.Pointer(vpcId),
+            TargetType: types.TargetTypeEnumInstance,
+            Tags:       elbv2Tags(tags),
+        }
+
+        opts.HealthCheck.apply(input)
+
+        ctgOutput, err := svc.ELBv2.Client().CreateTargetGroup(ctx, input)
+
+        if err != nil {
+            return err
+        }
+
+        if len(ctgOutput.TargetGroups) == 0 {
+            return ErrTargetGroupNotReturnedAfterCreation
+        }
+
+        targetGroupArn := memory.Unwrap(ctgOutput.TargetGroups[0].TargetGroupArn)
+
+        created(resources, targetGroupArn)
+
+        return svc.modifyTargetGroupAttributes(ctx, targetGroupArn, opts.TargetGroupAttributes.attributes())
+    }, slices.Concat([]string{
+        fmt.Sprintf("protocol: %s", opts.TrafficProtocol),
+        fmt.Sprintf("port: %d", opts.TrafficPort),
+        fmt.Sprintf("vpc: %s", vpcId),
+    }, opts.HealthCheck.describe(), describeAttributes(opts.TargetGroupAttributes.attributes()))...)
+}
+
+// diffTargets returns the targets to register and deregister for a target group with the registered targets to contain
+// exactly the instances.
+func diffTargets(
+    opts SetupNewILBServiceOptions,
+    targetInstances []*Instance,
+    registered []types.TargetHealthDescription,
+) ([]types.TargetDescription, []types.TargetDescription) {
+    var (
+        toRegister   []types.TargetDescription
+        toDeregister []types.TargetDescription
+        desired      = make(map[string]struct{})
+        existing     = make(map[string]struct{})
+    )
+
+    for _, instance := range targetInstances {
+        desired[memory.Unwrap(instance.Info.InstanceId)] = struct{}{}
+    }
+
+    for _, description := range registered {
+        if description.Target == nil {
+            continue
+        }
+
+        // draining targets are already on their way out, wanted ones are simply registered again below
+        if description.TargetHealth != nil && description.TargetHealth.State == types.TargetHealthStateEnumDraining {
+            continue
         }
 
-        subnetAzMap := make(map[string]string)
+        id := memory.Unwrap(description.Target.Id)
 
-        for _, subnet := range allSubnets {
-            subnetAzMap[memory.Unwrap(subnet.AvailabilityZone)] = memory.Unwrap(subnet.SubnetId)
+        if _, ok := desired[id]; ok && memory.Unwrap(description.Target.Port) == opts.TrafficPort {
+            existing[id] = struct{}{}
+            continue
         }
 
-        azGroupedSubnets := slices.Collect(maps.Values(subnetAzMap))
+        toDeregister = append(toDeregister, *description.Target)
+    }
 
-        lbConfig := &elbv2.CreateLoadBalancerInput{
-            Name:           memory.Pointer(opts.AwsumResourceName()),
-            Type:           types.LoadBalancerTypeEnumApplication,
-            Scheme:         types.LoadBalancerSchemeEnumInternetFacing,
-            SecurityGroups: []string{resources.SecurityGroupId},
-            Subnets:        append(instanceSubnets, azGroupedSubnets...),
-            IpAddressType:  types.IpAddressTypeIpv4,
+    for _, instance := range targetInstances {
+        if _, ok := existing[memory.Unwrap(instance.Info.InstanceId)]; !ok {
+            toRegister = append(toRegister, types.TargetDescription{
+                Id:   instance.Info.InstanceId,
+                Port: memory.Pointer(opts.TrafficPort),
+            })
         }
+    }
+
+    return toRegister, toDeregister
+}
+
+// describeTargets returns a line per target for a plan, naming the instance when it is known.
+func describeTargets(targets []types.TargetDescription, instances []*Instance) []string {
+    var lines []string
 
-        if opts.Private {
-            lbConfig.Scheme = types.LoadBalancerSchemeEnumInternal
+    for _, target := range targets {
+        id := memory.Unwrap(target.Id)
+        line := fmt.Sprintf("%s port %d", id, memory.Unwrap(target.Port))
+
+        for _, instance := range instances {
+            if memory.Unwrap(instance.Info.InstanceId) == id {
+                line = fmt.Sprintf("%s (%s) port %d", id, instance.GetName(), memory.Unwrap(target.Port))
+                break
+            }
         }
 
-        clbOutput, err := svc.ELBv2.Client().CreateLoadBalancer(opts.Ctx, lbConfig)
+        lines = append(lines, line)
+    }
+
+    return lines
+}
+
+func orNone(s string) string {
+    if len(s) == 0 {
+        return "(none)"
+    }
+
+    return s
+}
+
+// planDomains points every domain at the load balancer, with AAAA as well as A aliases for a dualstack one, skipping
+// records that already do. AAAA aliases of a load balancer that is no longer dualstack are removed.
+func (svc *AwsumILBService) planDomains(opts SetupNewILBServiceOptions, plan *ILBServicePlan) error {
+    loadBalancerDNSName := plan.Resources.LoadBalancerDNSName
+
+    if len(loadBalancerDNSName) == 0 {
+        loadBalancerDNSName = KnownAfterApply
+    }
+
+    for _, domainName := range opts.DomainNames {
+        hostedZone, err := svc.Route53.GetAssumedHostedZoneByDomainName(opts.Ctx, domainName, opts.Private)
 
         if err != nil {
+            return err
+        }
+
+        if hostedZone == nil {
+            return fmt.Errorf("hosted zone not found for '%s'", domainName)
+        }
+
+        wanted := loadBalancerAliasTypes(isDualstack(opts.IpAddressType))
+
+        for _, recordType := range []route53Types.RRType{route53Types.RRTypeA, route53Types.RRTypeAaaa} {
+            record, err := svc.Route53.SearchForRecord(opts.Ctx, memory.Unwrap(hostedZone.Id), domainName, recordType)
+
+            if err != nil {
+                return err
+            }
+
+            pointsAtLoadBalancer := aliasPointsTo(record, plan.Resources.LoadBalancerDNSName)
+
+            if !slices.Contains(wanted, recordType) {
+                if pointsAtLoadBalancer {
+                    plan.add(PlanActionDelete, "dns record", domainName, func(ctx context.Context, _ *ILBServiceResources) error {
+                        return svc.Route53.DeleteRecord(ctx, memory.Unwrap(hostedZone.Id), *record)
+                    }, fmt.Sprintf("%s alias: %s", recordType, loadBalancerDNSName))
+                }
+
+                continue
+            }
+
+            if pointsAtLoadBalancer {
+                continue
+            }
+
+            var (
+                kind   = PlanActionCreate
+                change = fmt.Sprintf("%s alias: %s", recordType, loadBalancerDNSName)
+            )
+
+            if record != nil {
+                kind = PlanActionModify
+
+                var current string
+
+                if record.AliasTarget != nil {
+                    current = memory.Unwrap(record.AliasTarget.DNSName)
+                }
+
+                change = fmt.Sprintf("%s alias: %s -> %s", recordType, orNone(current), loadBalancerDNSName)
+            }
+
+            plan.add(kind, "dns record", domainName, func(ctx context.Context, resources *ILBServiceResources) error {
+                return svc.Route53.UpsertLoadBalancerAlias(
+                    ctx,
+                    memory.Unwrap(hostedZone.Id),
+                    domainName,
+                    recordType,
+                    resources.LoadBalancerDNSName,
+                    resources.LoadBalancerHostedZoneId,
+                )
+            }, change)
+        }
+    }
+
+    return nil
+}
+
+// PlanILBService works out every change needed for the service's resources to match the options, without making any
+// of them. Existing resources are reconciled in place rather than recreated, so applying the plan doesn't interrupt
+// traffic (and re-planning unchanged options yields no actions): new targets are registered and become healthy before
+// removed targets are drained.
+func (svc *AwsumILBService) PlanILBService(opts SetupNewILBServiceOptions) (*ILBServicePlan, error) {
+    plan := &ILBServicePlan{
+        Options:   opts,
+        Resources: ILBServiceResources{ListenerArns: make(map[int32]string)},
+    }
+
+    if err := ValidateServiceName(opts.ServiceName); err != nil {
+        return nil, err
+    }
+
+    if len(opts.SharedLoadBalancer) > 0 {
+        if err := ValidateServiceName(opts.SharedLoadBalancer); err != nil {
             return nil, err
         }
+    }
 
-        resources.LoadBalancerArn = memory.Unwrap(clbOutput.LoadBalancers[0].LoadBalancerArn)
-        resources.LoadBalancerDNSName = memory.Unwrap(clbOutput.LoadBalancers[0].DNSName)
+    if len(opts.Listeners) == 0 {
+        return nil, ErrNoListeners
     }
 
-    // remove old target group resources
+    ports := make(map[int32]struct{})
+
+    for _, listener := range opts.Listeners {
+        if _, ok := ports[listener.Port]; ok {
+            return nil, fmt.Errorf("%w, port %d is used more than once", ErrDuplicateListenerPort, listener.Port)
+        }
+
+        ports[listener.Port] = struct{}{}
+    }
 
-    targetGroup, err := svc.ELBv2.SearchForTargetGroupByName(opts.Ctx, opts.AwsumResourceName())
+    lbType, err := ResolveLoadBalancerType(opts.LoadBalancerType, opts.Listeners, opts.TrafficProtocol)
 
     if err != nil {
         return nil, err
     }
 
-    if targetGroup != nil {
-        if _, err = svc.ELBv2.Client().DeleteTargetGroup(opts.Ctx, &elbv2.DeleteTargetGroupInput{
-            TargetGroupArn: targetGroup.TargetGroupArn,
-        }); err != nil {
-            return nil, err
+    opts.LoadBalancerType = lbType
+
+    if err = validateLoadBalancerType(opts); err != nil {
+        return nil, err
+    }
+
+    if err = opts.TargetGroupAttributes.Validate(opts.LoadBalancerType); err != nil {
+        return nil, err
+    }
+
+    if err = opts.LoadBalancerAttributes.Validate(opts.LoadBalancerType); err != nil {
+        return nil, err
+    }
+
+    // udp can't be health checked, so udp targets are checked over tcp on the same port
+    if len(opts.HealthCheck.Protocol) == 0 &&
+        (opts.TrafficProtocol == types.ProtocolEnumUdp || opts.TrafficProtocol == types.ProtocolEnumTcpUdp) {
+        opts.HealthCheck.Protocol = types.ProtocolEnumTcp
+    }
+
+    if plan.recorded, err = svc.serviceState(opts.Ctx, opts.ServiceName); err != nil {
+        return nil, err
+    }
+
+    if opts.IpAddressType, err = svc.resolveIpAddressType(opts, plan.recorded); err != nil {
+        return nil, err
+    }
+
+    if opts.AllowCIDRs, err = ParseAllowCIDRs(opts.AllowCIDRs, opts.IPv6 || isDualstack(opts.IpAddressType)); err != nil {
+        return nil, err
+    }
+
+    plan.Options = opts
+    plan.record = func(ctx context.Context, resources *ILBServiceResources, applyErr error) error {
+        var (
+            inputs    *ServiceInputs
+            inputsErr error
+        )
+
+        // without them, the inputs it was last applied with are kept
+        if applyErr == nil {
+            if inputs, inputsErr = svc.appliedInputs(ctx, opts, resources); inputsErr != nil {
+                inputsErr = fmt.Errorf("failed to read the settings the service was applied with: %w", inputsErr)
+            }
         }
+
+        return errors.Join(inputsErr, svc.updateState(ctx, func(state *State) error {
+            state.record(opts, resources, inputs)
+            return nil
+        }))
     }
 
-    // target group creation
+    if len(opts.SharedLoadBalancer) > 0 && len(opts.Hosts) == 0 && len(opts.Paths) == 0 {
+        return nil, ErrSharedLoadBalancerNeedsConditions
+    }
 
-    ctgInput := elbv2.CreateTargetGroupInput{
-        Name:       memory.Pointer(opts.AwsumResourceName()),
-        Port:       memory.Pointer(opts.TrafficPort),
-        Protocol:   opts.TrafficProtocol,
-        VpcId:      memory.Pointer(targetVPC),
-        TargetType: types.TargetTypeEnumInstance,
+    if len(opts.SharedLoadBalancer) == 0 && (len(opts.Hosts) > 0 || len(opts.Paths) > 0) {
+        return nil, ErrConditionsNeedSharedLoadBalancer
     }
 
-    ctgOutput, err := svc.ELBv2.Client().CreateTargetGroup(opts.Ctx, &ctgInput)
+    if len(opts.Hosts)+len(opts.Paths) > MaxRuleConditionValues {
+        return nil, ErrTooManyRuleConditionValues
+    }
+
+    if opts.Canary < 0 || opts.Canary > 99 {
+        return nil, ErrInvalidCanaryWeight
+    }
+
+    // target selection
+
+    instances, err := svc.EC2.GetAllRunningInstances(opts.Ctx)
 
     if err != nil {
         return nil, err
     }
 
-    resources.TargetGroupArn = memory.Unwrap(ctgOutput.TargetGroups[0].TargetGroupArn)
+    targetInstances := opts.TargetInstanceFilters.Matches(instances)
+
+    if len(targetInstances) == 0 {
+        return nil, ErrNoTargetInstances
+    }
 
-    // target group registration
+    var (
+        vpcIdMap    = make(map[string]struct{})
+        subnetIdMap = make(map[string]struct{})
+    )
 
     for _, instance := range targetInstances {
-        _, err = svc.ELBv2.Client().RegisterTargets(opts.Ctx, &elbv2.RegisterTargetsInput{
-            TargetGroupArn: memory.Pointer(resources.TargetGroupArn),
-            Targets: []types.TargetDescription{
-                {
-                    Id:   instance.Info.InstanceId,
-                    Port: memory.Pointer(opts.TrafficPort),
-                },
-            },
-        })
+        vpcIdMap[memory.Unwrap(instance.Info.VpcId)] = struct{}{}
+        subnetIdMap[memory.Unwrap(instance.Info.SubnetId)] = struct{}{}
+    }
 
-        if err != nil {
+    instanceVPCs := slices.Collect(maps.Keys(vpcIdMap))
+    instanceSubnets := slices.Collect(maps.Keys(subnetIdMap))
+
+    if len(instanceVPCs) > 1 {
+        return nil, ErrTargetInstancesMustAllBeInSameVPC
+    }
+
+    // load balancer & instance security groups
+
+    if supportsSecurityGroups(opts.LoadBalancerType) {
+        if err = svc.planSecurityGroups(opts, plan, instanceVPCs[0]); err != nil {
             return nil, err
         }
+
+        // instances have to let the load balancer in before they are registered with it
+        svc.planInstanceSecurityGroupAttachments(opts, plan, targetInstances)
     }
 
-    // load-balancer certificate and listener setup
+    // load balancer
+
+    listeners, err := svc.planLoadBalancer(opts, plan, instanceSubnets)
+
+    if err != nil {
+        return nil, err
+    }
+
+    // target group & targets
+
+    newTargetGroup, err := svc.planTargetGroup(opts, plan, instanceVPCs[0], listeners)
+
+    if err != nil {
+        return nil, err
+    }
 
-    var certs []types.Certificate
+    var registered []types.TargetHealthDescription
 
-    if len(opts.CertificateNames) > 0 {
-        certs, err = svc.ACM.GenerateLoadBalanceCertificateListFromCertificateNames(opts.Ctx, opts.CertificateNames)
+    if newTargetGroup {
+        // a shared load balancer's listeners are kept, see planTargetGroup
+        if len(opts.SharedLoadBalancer) == 0 {
+            listeners = nil
+        }
+    } else {
+        registered, err = svc.ELBv2.GetTargetHealth(opts.Ctx, plan.Resources.TargetGroupArn)
 
         if err != nil {
             return nil, err
         }
     }
 
-    _, err = svc.ELBv2.Client().CreateListener(opts.Ctx, &elbv2.CreateListenerInput{
-        LoadBalancerArn: memory.Pointer(resources.LoadBalancerArn),
-        Port:            memory.Pointer(opts.LoadBalancerPort),
-        Protocol:        opts.LoadBalancerListenerProtocol,
-        Certificates:    certs,
-        DefaultActions: []types.Action{{
-            Type: types.ActionTypeEnumForward,
-            ForwardConfig: &types.ForwardActionConfig{
-                TargetGroups: []types.TargetGroupTuple{
-                    {
-                        TargetGroupArn: memory.Pointer(resources.TargetGroupArn),
-                    },
-                },
-            },
-        }},
-    })
+    toRegister, toDeregister := diffTargets(opts, targetInstances, registered)
+
+    // canary target group & targets
+
+    canaryTargetGroup, err := svc.findTargetGroup(opts.Ctx, plan.recorded.CanaryTargetGroupArn, opts.CanaryResourceName())
 
     if err != nil {
         return nil, err
     }
 
-    // attach domain(s) to load balancer
-
-    if len(opts.DomainNames) > 0 {
-        err = svc.Route53.AttachDomainsToLoadBalancer(AttachDomainsToLoadBalancerOptions{
-            Ctx:              opts.Ctx,
-            LoadBalancerName: opts.AwsumResourceName(),
-            ELBService:       svc.ELBv2,
-            EC2Service:       svc.EC2,
-            Private:          opts.Private,
-            DomainNames:      opts.DomainNames,
-        })
+    if opts.Canary > 0 {
+        if newTargetGroup {
+            return nil, ErrCanaryNeedsExistingService
+        }
 
-        if err != nil {
+        if err = svc.planCanary(opts, plan, instanceVPCs[0], canaryTargetGroup, targetInstances, toRegister, instances); err != nil {
             return nil, err
         }
+
+        // the service's own targets stay as they are until the canary is promoted or rolled back
+        toRegister, toDeregister = nil, nil
+    } else if canaryTargetGroup != nil {
+        return nil, ErrCanaryInProgress
+    }
+
+    if len(toRegister) > 0 {
+        plan.add(PlanActionCreate, "targets", opts.AwsumResourceName(), func(ctx context.Context, resources *ILBServiceResources) error {
+            _, err := svc.ELBv2.Client().RegisterTargets(ctx, &elbv2.RegisterTargetsInput{
+                TargetGroupArn: memory.Pointer(resources.TargetGroupArn),
+                Targets:        toRegister,
+            })
+
+            return err
+        }, describeTargets(toRegister, instances)...)
+    }
+
+    // load-balancer certificate and listener setup
+
+    certs, err := svc.ACM.GenerateLoadBalanceCertificateListFromCertificateNames(opts.Ctx, opts.CertificateNames)
+
+    if err != nil {
+        return nil, err
+    }
+
+    if len(opts.SharedLoadBalancer) > 0 {
+        err = svc.planSharedListeners(opts, plan, listeners, certs)
+    } else {
+        err = svc.planListeners(opts, plan, listeners, certs)
+    }
+
+    if err != nil {
+        return nil, err
+    }
+
+    // drain removed targets, but only once the new ones can take over
+
+    if len(toDeregister) > 0 {
+        plan.add(PlanActionDelete, "targets", opts.AwsumResourceName(), func(ctx context.Context, resources *ILBServiceResources) error {
+            if len(toRegister) > 0 {
+                fmt.Printf("waiting for %d new target(s) to become healthy...\n", len(toRegister))
+
+                if err := svc.ELBv2.WaitForTargetsInService(
+                    ctx,
+                    resources.TargetGroupArn,
+                    toRegister,
+                    TargetHealthyTimeout,
+                ); err != nil {
+                    return fmt.Errorf("new targets did not become healthy, old targets were left registered: %w", err)
+                }
+            }
+
+            fmt.Printf("draining %d removed target(s)...\n", len(toDeregister))
+
+            return svc.ELBv2.DeregisterTargetsAndWait(ctx, resources.TargetGroupArn, toDeregister)
+        }, describeTargets(toDeregister, instances)...)
+    }
+
+    // and only then take the instances' security group off them, keeping it on the targets a canary runs alongside
+
+    if supportsSecurityGroups(opts.LoadBalancerType) {
+        serving := make(map[string]struct{})
+
+        for _, instance := range targetInstances {
+            serving[memory.Unwrap(instance.Info.InstanceId)] = struct{}{}
+        }
+
+        if opts.Canary > 0 {
+            for _, description := range registered {
+                serving[memory.Unwrap(description.Target.Id)] = struct{}{}
+            }
+        }
+
+        svc.planInstanceSecurityGroupDetachments(
+            plan,
+            opts.InstanceSecurityGroupResourceName(),
+            plan.Resources.InstanceSecurityGroupId,
+            instances,
+            serving,
+        )
+    }
+
+    // attach domain(s) to load balancer
+
+    if err = svc.planDomains(opts, plan); err != nil {
+        return nil, err
+    }
+
+    return plan, nil
+}
+
+// SetupNewILBService creates or updates every resource needed to load balance the service on the target instances,
+// by planning the changes and immediately applying them.
+func (svc *AwsumILBService) SetupNewILBService(opts SetupNewILBServiceOptions) (*ILBServiceResources, error) {
+    plan, err := svc.PlanILBService(opts)
+
+    if err != nil {
+        return nil, err
     }
 
-    return &resources, err
+    return plan.Apply(opts.Ctx)
 }
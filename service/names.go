@@ -0,0 +1,68 @@
+package service
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+const (
+    // MaxResourceNameLength is the longest name a load balancer or target group can have.
+    MaxResourceNameLength = 32
+    // MaxServiceNameLength is the longest name a service, or a shared load balancer, can have.
+    MaxServiceNameLength = 64
+    // resourceNameHashLength is how many hex digits of the hash of a name too long to fit end the name it is cut to.
+    resourceNameHashLength = 8
+)
+
+// reservedServiceNameSuffixes end the names of the resources a service has besides its own, which a service named with
+// one of them would take for its own (like service foo-canary and the canary target group of service foo).
+var reservedServiceNameSuffixes = []string{"-canary", "-instances"}
+
+var (
+    ErrInvalidServiceName  = errors.New("service and shared load balancer names may only contain letters, digits and hyphens, and can't start or end with a hyphen")
+    ErrServiceNameTooLong  = fmt.Errorf("service and shared load balancer names can be at most %d characters", MaxServiceNameLength)
+    ErrReservedServiceName = fmt.Errorf("service and shared load balancer names can't end in %s", strings.Join(reservedServiceNameSuffixes, " or "))
+)
+
+var (
+    serviceNamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+    hashSuffixPattern  = regexp.MustCompile(fmt.Sprintf(`(?i)-[0-9a-f]{%d}$`, resourceNameHashLength))
+)
+
+// ValidateServiceName checks the name of a service, or a shared load balancer, can be part of the names of the
+// resources awsum creates for it.
+func ValidateServiceName(name string) error {
+    if len(name) > MaxServiceNameLength {
+        return fmt.Errorf("%w, got '%s'", ErrServiceNameTooLong, name)
+    }
+
+    if !serviceNamePattern.MatchString(name) {
+        return fmt.Errorf("%w, got '%s'", ErrInvalidServiceName, name)
+    }
+
+    for _, suffix := range reservedServiceNameSuffixes {
+        if strings.HasSuffix(strings.ToLower(name), suffix) {
+            return fmt.Errorf("%w, got '%s'", ErrReservedServiceName, name)
+        }
+    }
+
+    return nil
+}
+
+// resourceName fits the name into MaxResourceNameLength characters. A name too long is cut short, ending in a hash of
+// the whole of it instead, so the same name always fits the same way and different names stay different. A name that
+// fits but already ends like a hash is hashed too, or it could be the name another one is cut to.
+func resourceName(name string) string {
+    if len(name) <= MaxResourceNameLength && !hashSuffixPattern.MatchString(name) {
+        return name
+    }
+
+    sum := sha256.Sum256([]byte(name))
+    hash := hex.EncodeToString(sum[:])[:resourceNameHashLength]
+
+    return fmt.Sprintf("%s-%s", strings.TrimRight(name[:min(len(name), MaxResourceNameLength-len(hash)-1)], "-"), hash)
+}
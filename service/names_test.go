@@ -0,0 +1,67 @@
+package service_test
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/levelshatter/awsum/service"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestValidateServiceName(t *testing.T) {
+    for _, name := range []string{"web", "my-web-2", "A", strings.Repeat("a", service.MaxServiceNameLength)} {
+        assert.NoError(t, service.ValidateServiceName(name), name)
+    }
+
+    for _, name := range []string{"", "-web", "web-", "my_web", "my.web", "web app"} {
+        assert.ErrorIs(t, service.ValidateServiceName(name), service.ErrInvalidServiceName, name)
+    }
+
+    assert.ErrorIs(t, service.ValidateServiceName(strings.Repeat("a", service.MaxServiceNameLength+1)), service.ErrServiceNameTooLong)
+
+    // these would take over the canary target group and instance security group of services foo and bar
+    for _, name := range []string{"foo-canary", "bar-instances", "Foo-CANARY"} {
+        assert.ErrorIs(t, service.ValidateServiceName(name), service.ErrReservedServiceName, name)
+    }
+
+    assert.NoError(t, service.ValidateServiceName("canary"))
+    assert.NoError(t, service.ValidateServiceName("canary-web"))
+}
+
+func TestResourceNames(t *testing.T) {
+    // names that fit are left as they are
+    assert.Equal(t, "awsum-ilb-svc-website", service.AwsumILBResourceName("website"))
+    assert.Equal(t, "awsum-ilb-svc-website-canary", service.AwsumILBCanaryResourceName("website"))
+    assert.Equal(t, "awsum-ilb-svc-website-instances", service.AwsumILBInstancesResourceName("website"))
+    assert.Equal(t, "awsum-ilb-shared-main", service.AwsumSharedLBResourceName("main"))
+
+    var (
+        long    = "customer-billing-notifications"
+        similar = "customer-billing-notifications-eu"
+        names   = []string{
+            service.AwsumILBResourceName(long),
+            service.AwsumILBCanaryResourceName(long),
+            service.AwsumILBInstancesResourceName(long),
+            service.AwsumILBResourceName(similar),
+            service.AwsumSharedLBResourceName(long),
+        }
+    )
+
+    for i, name := range names {
+        assert.LessOrEqual(t, len(name), service.MaxResourceNameLength, name)
+        assert.Regexp(t, `^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`, name)
+
+        for _, other := range names[i+1:] {
+            assert.NotEqual(t, name, other)
+        }
+    }
+
+    // existing resources are only found again if the same name always fits the same way
+    assert.Equal(t, "awsum-ilb-svc-customer-48b04ed4", service.AwsumILBResourceName(long))
+
+    // a name ending like the hash of a longer one doesn't take its resources
+    for _, name := range []string{"customer-48b04ed4", "customer-48B04ED4"} {
+        assert.NotEqual(t, service.AwsumILBResourceName(long), service.AwsumILBResourceName(name), name)
+        assert.LessOrEqual(t, len(service.AwsumILBResourceName(name)), service.MaxResourceNameLength, name)
+    }
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "maps"
+)
+
+// KnownAfterApply stands in for values in a plan that only exist once the plan has been applied, like the arn of a
+// resource that is yet to be created.
+const KnownAfterApply = "(known after apply)"
+
+type PlanActionKind int
+
+const (
+    PlanActionCreate PlanActionKind = iota
+    PlanActionModify
+    PlanActionDelete
+)
+
+func (k PlanActionKind) String() string {
+    switch k {
+    case PlanActionCreate:
+        return "create"
+    case PlanActionModify:
+        return "modify"
+    case PlanActionDelete:
+        return "delete"
+    default:
+        return "unknown"
+    }
+}
+
+// Symbol returns the prefix used for the kind of action when printing a plan.
+func (k PlanActionKind) Symbol() string {
+    switch k {
+    case PlanActionCreate:
+        return "+"
+    case PlanActionModify:
+        return "~"
+    case PlanActionDelete:
+        return "-"
+    default:
+        return "?"
+    }
+}
+
+// planApplyFunc carries out a single planned action, filling in the resources it creates.
+type planApplyFunc func(ctx context.Context, resources *ILBServiceResources) error
+
+// PlanAction is a single change to a single aws resource.
+type PlanAction struct {
+    Kind     PlanActionKind
+    Resource string
+    Name     string
+    // Changes describes what the action does in more detail, one line per change.
+    Changes []string
+
+    apply planApplyFunc
+}
+
+// ILBServicePlan is the set of actions needed to make a load-balanced service match its options. Computing a plan
+// doesn't change anything, applying it does.
+type ILBServicePlan struct {
+    Options SetupNewILBServiceOptions
+    // Resources holds the resources that already exist, the rest are filled in as the plan is applied.
+    Resources ILBServiceResources
+    Actions   []*PlanAction
+
+    // replacedTargetGroupArn is the target group being replaced, whose listener rules on a shared load balancer are
+    // already planned for removal.
+    replacedTargetGroupArn string
+    // recorded is the state's record of the service when the plan was made.
+    recorded ServiceState
+    // record updates the state once the plan is applied, as far as it got before any error.
+    record func(ctx context.Context, resources *ILBServiceResources, applyErr error) error
+}
+
+func (p *ILBServicePlan) add(kind PlanActionKind, resource string, name string, apply planApplyFunc, changes ...string) {
+    p.Actions = append(p.Actions, &PlanAction{
+        Kind:     kind,
+        Resource: resource,
+        Name:     name,
+        Changes:  changes,
+        apply:    apply,
+    })
+}
+
+func (p *ILBServicePlan) HasChanges() bool {
+    return len(p.Actions) > 0
+}
+
+// Count returns how many of the plan's actions are of the given kind.
+func (p *ILBServicePlan) Count(kind PlanActionKind) int {
+    return countActions(p.Actions, kind)
+}
+
+func countActions(actions []*PlanAction, kind PlanActionKind) int {
+    var count int
+
+    for _, action := range actions {
+        if action.Kind == kind {
+            count++
+        }
+    }
+
+    return count
+}
+
+// Print writes the plan in a human-readable diff-like format.
+func (p *ILBServicePlan) Print(w io.Writer) {
+    if !p.HasChanges() {
+        _, _ = fmt.Fprintf(w, "no changes, service '%s' is up to date\n", p.Options.ServiceName)
+        return
+    }
+
+    _, _ = fmt.Fprintf(w, "service '%s' requires the following changes:\n\n", p.Options.ServiceName)
+
+    printActions(w, p.Actions)
+
+    _, _ = fmt.Fprintf(
+        w,
+        "\nplan: %d to create, %d to modify, %d to delete\n",
+        p.Count(PlanActionCreate),
+        p.Count(PlanActionModify),
+        p.Count(PlanActionDelete),
+    )
+}
+
+func printActions(w io.Writer, actions []*PlanAction) {
+    for _, action := range actions {
+        _, _ = fmt.Fprintf(w, "  %s %s %s\n", action.Kind.Symbol(), action.Resource, action.Name)
+
+        for _, change := range action.Changes {
+            _, _ = fmt.Fprintf(w, "        %s\n", change)
+        }
+    }
+}
+
+// Apply carries out the plan's actions in order, stopping at the first failure, and records the resources of the
+// service in the state.
+func (p *ILBServicePlan) Apply(ctx context.Context) (*ILBServiceResources, error) {
+    resources := p.Resources
+    resources.ListenerArns = maps.Clone(p.Resources.ListenerArns)
+
+    if resources.ListenerArns == nil {
+        resources.ListenerArns = make(map[int32]string)
+    }
+
+    var err error
+
+    for _, action := range p.Actions {
+        if err = action.apply(ctx, &resources); err != nil {
+            err = fmt.Errorf("failed to %s %s '%s': %w", action.Kind, action.Resource, action.Name, err)
+            break
+        }
+    }
+
+    // resources created before a failure are recorded all the same, so they aren't lost track of
+    if p.record != nil {
+        if recordErr := p.record(context.WithoutCancel(ctx), &resources, err); recordErr != nil {
+            err = errors.Join(err, fmt.Errorf("failed to record service '%s' in the state: %w", p.Options.ServiceName, recordErr))
+        }
+    }
+
+    if err != nil {
+        return nil, err
+    }
+
+    return &resources, nil
+}
@@ -0,0 +1,114 @@
+package service_test
+
+import (
+    "bytes"
+    "testing"
+
+    "github.com/levelshatter/awsum/service"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestILBServicePlan_Print(t *testing.T) {
+    var (
+        buf  bytes.Buffer
+        plan = &service.ILBServicePlan{Options: service.SetupNewILBServiceOptions{ServiceName: "website"}}
+    )
+
+    plan.Print(&buf)
+    assert.Equal(t, "no changes, service 'website' is up to date\n", buf.String())
+
+    plan.Actions = []*service.PlanAction{
+        {
+            Kind:     service.PlanActionCreate,
+            Resource: "security group rule",
+            Name:     "awsum-ilb-svc-website",
+            Changes:  []string{"ingress tcp 443 from 0.0.0.0/0"},
+        },
+        {
+            Kind:     service.PlanActionModify,
+            Resource: "listener",
+            Name:     "awsum-ilb-svc-website:443",
+            Changes:  []string{"protocol: HTTP -> HTTPS"},
+        },
+        {
+            Kind:     service.PlanActionDelete,
+            Resource: "targets",
+            Name:     "awsum-ilb-svc-website",
+        },
+    }
+
+    buf.Reset()
+    plan.Print(&buf)
+
+    assert.True(t, plan.HasChanges())
+    assert.Equal(t, 1, plan.Count(service.PlanActionModify))
+    assert.Equal(t, `service 'website' requires the following changes:
+
+  + security group rule awsum-ilb-svc-website
+        ingress tcp 443 from 0.0.0.0/0
+  ~ listener awsum-ilb-svc-website:443
+        protocol: HTTP -> HTTPS
+  - targets awsum-ilb-svc-website
+
+plan: 1 to create, 1 to modify, 1 to delete
+`, buf.String())
+}
+
+func TestILBServicePlan_PrintDrift(t *testing.T) {
+    var (
+        buf  bytes.Buffer
+        plan = &service.ILBServicePlan{Options: service.SetupNewILBServiceOptions{ServiceName: "website"}}
+    )
+
+    plan.PrintDrift(&buf)
+    assert.Equal(t, "no drift, service 'website' matches its last applied inputs\n", buf.String())
+    assert.False(t, plan.HasDrift())
+
+    // an instance scaled out isn't drift
+    plan.Actions = []*service.PlanAction{
+        {
+            Kind:     service.PlanActionCreate,
+            Resource: "targets",
+            Name:     "awsum-ilb-svc-website",
+            Changes:  []string{"+ i-0abc (website-3)"},
+        },
+    }
+
+    buf.Reset()
+    plan.PrintDrift(&buf)
+
+    assert.False(t, plan.HasDrift())
+    assert.Equal(t, `no drift, service 'website' matches its last applied inputs
+
+instances started or stopped matching service 'website' since, which applying it again would follow:
+
+  + targets awsum-ilb-svc-website
+        + i-0abc (website-3)
+`, buf.String())
+
+    plan.Actions = append([]*service.PlanAction{
+        {
+            Kind:     service.PlanActionDelete,
+            Resource: "security group rule",
+            Name:     "awsum-ilb-svc-website",
+            Changes:  []string{"ingress tcp 22 from 0.0.0.0/0"},
+        },
+    }, plan.Actions...)
+
+    buf.Reset()
+    plan.PrintDrift(&buf)
+
+    assert.True(t, plan.HasDrift())
+    assert.Equal(t, `service 'website' has drifted, applying its last inputs again would make the following changes:
+
+  - security group rule awsum-ilb-svc-website
+        ingress tcp 22 from 0.0.0.0/0
+
+drift: 0 to create, 0 to modify, 1 to delete
+
+instances started or stopped matching service 'website' since, which applying it again would follow:
+
+  + targets awsum-ilb-svc-website
+        + i-0abc (website-3)
+`, buf.String())
+}
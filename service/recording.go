@@ -0,0 +1,71 @@
+package service
+
+import (
+    "fmt"
+    "os"
+    "time"
+
+    "github.com/levelshatter/awsum/internal/asciicast"
+    "github.com/levelshatter/awsum/internal/files"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+// sessionRecording is an asciicast recording of a shell session, written to a file in the awsum data directory.
+type sessionRecording struct {
+    *asciicast.Recorder
+    file *os.File
+}
+
+// newSessionRecording starts a new recording of a shell session on the instance with the given terminal size.
+func (i *Instance) newSessionRecording(width int, height int) (*sessionRecording, error) {
+    filename := files.SessionFilename(
+        fmt.Sprintf("awsum-session-recording-%s", memory.Unwrap(i.Info.InstanceId)),
+        time.Now(),
+    ) + ".cast"
+
+    file, err := files.OpenAwsumFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+
+    if err != nil {
+        return nil, fmt.Errorf("failed to create session recording file: %w", err)
+    }
+
+    recorder, err := asciicast.NewRecorder(file, asciicast.Header{
+        Width:  width,
+        Height: height,
+        Title:  fmt.Sprintf("%s (%s)", i.GetName(), memory.Unwrap(i.Info.InstanceId)),
+        Env: map[string]string{
+            "TERM": os.Getenv("TERM"),
+        },
+    })
+
+    if err != nil {
+        _ = file.Close()
+
+        return nil, err
+    }
+
+    return &sessionRecording{
+        Recorder: recorder,
+        file:     file,
+    }, nil
+}
+
+// close finishes the recording, reporting where it was saved (or why it is incomplete).
+func (r *sessionRecording) close() {
+    if err := r.Err(); err != nil {
+        fmt.Printf("failed to write session recording '%s', it may be incomplete: %s\n", r.file.Name(), err)
+    }
+
+    if err := r.file.Close(); err != nil {
+        fmt.Printf("failed to properly close session recording '%s': %s\n", r.file.Name(), err)
+        return
+    }
+
+    fmt.Printf("session recorded to '%s'\n", r.file.Name())
+}
+
+// discard removes the recording of a session that never started.
+func (r *sessionRecording) discard() {
+    _ = r.file.Close()
+    _ = os.Remove(r.file.Name())
+}
@@ -103,6 +103,161 @@ func (svc *Route53) GetAssumedHostedZoneByDomainName(ctx context.Context, domain
     return nil, nil
 }
 
+// GetAllResourceRecordSets returns every record in the hosted zone.
+func (svc *Route53) GetAllResourceRecordSets(ctx context.Context, hostedZoneId string) ([]types.ResourceRecordSet, error) {
+    var (
+        output  *route53.ListResourceRecordSetsOutput
+        records []types.ResourceRecordSet
+        input   = &route53.ListResourceRecordSetsInput{HostedZoneId: memory.Pointer(hostedZoneId)}
+        err     error
+    )
+
+    for {
+        output, err = svc.Client().ListResourceRecordSets(ctx, input)
+
+        if err != nil {
+            return nil, err
+        }
+
+        records = append(records, output.ResourceRecordSets...)
+
+        if !output.IsTruncated {
+            break
+        }
+
+        input.StartRecordName = output.NextRecordName
+        input.StartRecordType = output.NextRecordType
+        input.StartRecordIdentifier = output.NextRecordIdentifier
+    }
+
+    return records, nil
+}
+
+// HostedZoneRecord is a record along with the hosted zone it is in.
+type HostedZoneRecord struct {
+    HostedZoneId string
+    Record       types.ResourceRecordSet
+}
+
+// GetAllAliasRecords returns every alias record in every hosted zone.
+func (svc *Route53) GetAllAliasRecords(ctx context.Context) ([]HostedZoneRecord, error) {
+    zones, err := svc.GetAllHostedZones(ctx)
+
+    if err != nil {
+        return nil, err
+    }
+
+    var aliases []HostedZoneRecord
+
+    for _, zone := range zones {
+        records, err := svc.GetAllResourceRecordSets(ctx, memory.Unwrap(zone.Id))
+
+        if err != nil {
+            return nil, err
+        }
+
+        for _, record := range records {
+            if record.AliasTarget != nil {
+                aliases = append(aliases, HostedZoneRecord{
+                    HostedZoneId: memory.Unwrap(zone.Id),
+                    Record:       record,
+                })
+            }
+        }
+    }
+
+    return aliases, nil
+}
+
+// GetAliasRecordsPointingTo searches every hosted zone for alias records of the given dns name.
+func (svc *Route53) GetAliasRecordsPointingTo(ctx context.Context, dnsName string) ([]HostedZoneRecord, error) {
+    aliases, err := svc.GetAllAliasRecords(ctx)
+
+    if err != nil {
+        return nil, err
+    }
+
+    var matching []HostedZoneRecord
+
+    for _, alias := range aliases {
+        if aliasPointsTo(&alias.Record, dnsName) {
+            matching = append(matching, alias)
+        }
+    }
+
+    return matching, nil
+}
+
+// DeleteRecord deletes the record, which has to match the existing record exactly.
+func (svc *Route53) DeleteRecord(ctx context.Context, hostedZoneId string, record types.ResourceRecordSet) error {
+    _, err := svc.Client().ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+        ChangeBatch: &types.ChangeBatch{
+            Changes: []types.Change{
+                {
+                    Action:            types.ChangeActionDelete,
+                    ResourceRecordSet: &record,
+                },
+            },
+            Comment: memory.Pointer("managed by awsum"),
+        },
+        HostedZoneId: memory.Pointer(hostedZoneId),
+    })
+
+    return err
+}
+
+// SearchForRecord returns the record of the type with exactly the given name in the hosted zone, or nil when there is
+// none.
+func (svc *Route53) SearchForRecord(
+    ctx context.Context,
+    hostedZoneId string,
+    domainName string,
+    recordType types.RRType,
+) (*types.ResourceRecordSet, error) {
+    output, err := svc.Client().ListResourceRecordSets(ctx, &route53.ListResourceRecordSetsInput{
+        HostedZoneId:    memory.Pointer(hostedZoneId),
+        StartRecordName: memory.Pointer(domainName),
+        StartRecordType: recordType,
+        MaxItems:        memory.Pointer(int32(1)),
+    })
+
+    if err != nil {
+        return nil, err
+    }
+
+    for _, record := range output.ResourceRecordSets {
+        if record.Type == recordType && normalizeDNSName(memory.Unwrap(record.Name)) == normalizeDNSName(domainName) {
+            return &record, nil
+        }
+    }
+
+    return nil, nil
+}
+
+// normalizeDNSName makes dns names comparable regardless of case, a trailing dot or the "dualstack." prefix route53
+// adds to load balancer alias targets.
+func normalizeDNSName(name string) string {
+    name = strings.ToLower(strings.TrimSuffix(name, "."))
+    return strings.TrimPrefix(name, "dualstack.")
+}
+
+// aliasPointsTo reports whether the record is an alias of the given dns name.
+func aliasPointsTo(record *types.ResourceRecordSet, dnsName string) bool {
+    return record != nil &&
+        record.AliasTarget != nil &&
+        normalizeDNSName(memory.Unwrap(record.AliasTarget.DNSName)) == normalizeDNSName(dnsName)
+}
+
+// loadBalancerAliasTypes returns the types of alias records pointing domains at a load balancer, AAAA as well as A
+// for a dualstack one.
+func loadBalancerAliasTypes(dualstack bool) []types.RRType {
+    if dualstack {
+        return []types.RRType{types.RRTypeA, types.RRTypeAaaa}
+    }
+
+    return []types.RRType{types.RRTypeA}
+}
+
 type AttachDomainsToLoadBalancerOptions struct {
     Ctx              context.Context
     LoadBalancerName string
@@ -112,6 +267,39 @@ type AttachDomainsToLoadBalancerOptions struct {
     DomainNames      []string
 }
 
+// UpsertLoadBalancerAlias points the domain's record of the type (A or AAAA) at the load balancer.
+func (svc *Route53) UpsertLoadBalancerAlias(
+    ctx context.Context,
+    hostedZoneId string,
+    domainName string,
+    recordType types.RRType,
+    loadBalancerDNSName string,
+    loadBalancerHostedZoneId string,
+) error {
+    _, err := svc.Client().ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+        ChangeBatch: &types.ChangeBatch{
+            Changes: []types.Change{
+                {
+                    Action: "UPSERT",
+                    ResourceRecordSet: &types.ResourceRecordSet{
+                        Name: memory.Pointer(domainName),
+                        Type: recordType,
+                        AliasTarget: &types.AliasTarget{
+                            DNSName:              memory.Pointer(loadBalancerDNSName),
+                            HostedZoneId:         memory.Pointer(loadBalancerHostedZoneId),
+                            EvaluateTargetHealth: false,
+                        },
+                    },
+                },
+            },
+            Comment: memory.Pointer("managed by awsum"),
+        },
+        HostedZoneId: memory.Pointer(hostedZoneId),
+    })
+
+    return err
+}
+
 func (svc *Route53) AttachDomainsToLoadBalancer(opts AttachDomainsToLoadBalancerOptions) error {
     for _, domainName := range opts.DomainNames {
         hostedZone, err := svc.GetAssumedHostedZoneByDomainName(opts.Ctx, domainName, opts.Private)
@@ -134,29 +322,27 @@ func (svc *Route53) AttachDomainsToLoadBalancer(opts AttachDomainsToLoadBalancer
             return errors.New("load balancer not found")
         }
 
-        _, err = svc.Client().ChangeResourceRecordSets(opts.Ctx, &route53.ChangeResourceRecordSetsInput{
-            ChangeBatch: &types.ChangeBatch{
-                Changes: []types.Change{
-                    {
-                        Action: "UPSERT",
-                        ResourceRecordSet: &types.ResourceRecordSet{
-                            Name: memory.Pointer(domainName),
-                            Type: "A",
-                            AliasTarget: &types.AliasTarget{
-                                DNSName:              loadBalancer.DNSName,
-                                HostedZoneId:         loadBalancer.CanonicalHostedZoneId,
-                                EvaluateTargetHealth: false,
-                            },
-                        },
-                    },
-                },
-                Comment: memory.Pointer("managed by awsum"),
-            },
-            HostedZoneId: hostedZone.Id,
-        })
-
-        if err != nil {
-            return err
+        for _, recordType := range loadBalancerAliasTypes(isDualstack(loadBalancer.IpAddressType)) {
+            existing, err := svc.SearchForRecord(opts.Ctx, memory.Unwrap(hostedZone.Id), domainName, recordType)
+
+            if err != nil {
+                return err
+            }
+
+            if aliasPointsTo(existing, memory.Unwrap(loadBalancer.DNSName)) {
+                continue
+            }
+
+            if err = svc.UpsertLoadBalancerAlias(
+                opts.Ctx,
+                memory.Unwrap(hostedZone.Id),
+                domainName,
+                recordType,
+                memory.Unwrap(loadBalancer.DNSName),
+                memory.Unwrap(loadBalancer.CanonicalHostedZoneId),
+            ); err != nil {
+                return err
+            }
         }
     }
 
@@ -0,0 +1,28 @@
+package service
+
+import (
+    "fmt"
+    "os"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type S3 struct {
+    client *s3.Client
+}
+
+func NewS3(awsConfig aws.Config) *S3 {
+    return &S3{
+        client: s3.NewFromConfig(awsConfig),
+    }
+}
+
+func (svc *S3) Client() *s3.Client {
+    if svc == nil || svc.client == nil {
+        fmt.Printf("s3 service not initialized!")
+        os.Exit(1)
+    }
+
+    return svc.client
+}
@@ -0,0 +1,713 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/netip"
+    "slices"
+    "strconv"
+    "strings"
+
+    "github.com/aws/aws-sdk-go-v2/service/ec2"
+    ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+var ErrInvalidAllowCIDR = errors.New("allowed cidr must be an ipv4 or ipv6 range like 203.0.113.0/24 or 2001:db8::/32")
+
+// AwsumILBInstancesResourceName returns the name of the security group awsum attaches to the service's instances, which
+// only lets in traffic from the load balancer.
+func AwsumILBInstancesResourceName(serviceName string) string {
+    return resourceName(fmt.Sprintf("awsum-ilb-svc-%s-instances", serviceName))
+}
+
+func (opts SetupNewILBServiceOptions) InstanceSecurityGroupResourceName() string {
+    return AwsumILBInstancesResourceName(opts.ServiceName)
+}
+
+// securityGroupId returns the id of the service's security group with the name, as far as the resources know it.
+func (opts SetupNewILBServiceOptions) securityGroupId(resources *ILBServiceResources, name string) string {
+    if name == opts.InstanceSecurityGroupResourceName() {
+        return resources.InstanceSecurityGroupId
+    }
+
+    return resources.SecurityGroupId
+}
+
+// ParseAllowCIDRs parses the ranges allowed to reach the load balancer's listeners, anywhere (over ipv6 as well if
+// ipv6 is set) when none are given.
+func ParseAllowCIDRs(cidrs []string, ipv6 bool) ([]string, error) {
+    if len(cidrs) == 0 {
+        if ipv6 {
+            return []string{"0.0.0.0/0", "::/0"}, nil
+        }
+
+        return []string{"0.0.0.0/0"}, nil
+    }
+
+    var parsed []string
+
+    for _, cidr := range cidrs {
+        prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+
+        if err != nil {
+            return nil, fmt.Errorf("%w, got '%s'", ErrInvalidAllowCIDR, cidr)
+        }
+
+        if masked := prefix.Masked().String(); !slices.Contains(parsed, masked) {
+            parsed = append(parsed, masked)
+        }
+    }
+
+    return parsed, nil
+}
+
+// securityGroupRule is a security group rule described by what it allows, for comparing existing rules to desired ones.
+type securityGroupRule struct {
+    egress     bool
+    ipProtocol string
+    fromPort   int32
+    toPort     int32
+    cidr       string
+    // group is the name of the security group the rule allows traffic from or to, instead of a cidr. Groups awsum
+    // doesn't know the name of are referred to by id.
+    group string
+}
+
+func (r securityGroupRule) String() string {
+    direction, preposition := "ingress", "from"
+
+    if r.egress {
+        direction, preposition = "egress", "to"
+    }
+
+    ports := strconv.Itoa(int(r.fromPort))
+
+    if r.toPort != r.fromPort {
+        ports = fmt.Sprintf("%d-%d", r.fromPort, r.toPort)
+    }
+
+    peer := r.cidr
+
+    if len(r.group) > 0 {
+        peer = r.group
+    }
+
+    return fmt.Sprintf("%s %s %s %s %s", direction, r.ipProtocol, ports, preposition, peer)
+}
+
+// isListenerRule reports whether the rule lets clients reach the listeners, rather than being between the load balancer
+// and the instances.
+func (r securityGroupRule) isListenerRule() bool {
+    return !r.egress && len(r.group) == 0
+}
+
+func (r securityGroupRule) description() string {
+    switch {
+    case len(r.group) > 0 && r.egress:
+        return "traffic to the service instances"
+    case len(r.group) > 0:
+        return "traffic from the load balancer"
+    case r.egress:
+        return "all outbound traffic"
+    default:
+        return fmt.Sprintf("listener traffic on port %d", r.fromPort)
+    }
+}
+
+// securityGroupRuleOwners returns the services the rule is tagged as wanted by.
+func securityGroupRuleOwners(rule ec2Types.SecurityGroupRule) []string {
+    var owners []string
+
+    for _, tag := range rule.Tags {
+        if owner, ok := strings.CutPrefix(memory.Unwrap(tag.Key), ListenerRuleOwnerTagKeyPrefix); ok {
+            owners = append(owners, owner)
+        }
+    }
+
+    slices.Sort(owners)
+
+    return owners
+}
+
+// existingSecurityGroupRule describes an existing rule, naming the security groups it references where they are known.
+func existingSecurityGroupRule(rule ec2Types.SecurityGroupRule, names map[string]string) securityGroupRule {
+    existing := securityGroupRule{
+        egress:     memory.Unwrap(rule.IsEgress),
+        ipProtocol: memory.Unwrap(rule.IpProtocol),
+        fromPort:   memory.Unwrap(rule.FromPort),
+        toPort:     memory.Unwrap(rule.ToPort),
+        cidr:       memory.Unwrap(rule.CidrIpv4),
+    }
+
+    if rule.CidrIpv6 != nil {
+        existing.cidr = memory.Unwrap(rule.CidrIpv6)
+    }
+
+    if rule.ReferencedGroupInfo != nil {
+        existing.group = memory.Unwrap(rule.ReferencedGroupInfo.GroupId)
+
+        if name, ok := names[existing.group]; ok {
+            existing.group = name
+        }
+    }
+
+    return existing
+}
+
+// trafficRules returns a rule per ip protocol for the traffic, and health checks, going between the load balancer and
+// the instances, in the direction given and to or from the group.
+func trafficRules(opts SetupNewILBServiceOptions, egress bool, group string) []securityGroupRule {
+    var (
+        rules            []securityGroupRule
+        trafficProtocols = ipProtocols(opts.TrafficProtocol, opts.LoadBalancerIpProtocol)
+    )
+
+    for _, ipProtocol := range trafficProtocols {
+        rules = append(rules, securityGroupRule{
+            egress:     egress,
+            ipProtocol: ipProtocol,
+            fromPort:   opts.TrafficPort,
+            toPort:     opts.TrafficPort,
+            group:      group,
+        })
+    }
+
+    // health checks on a separate port, or over tcp for udp traffic, need a rule of their own
+    healthPort := opts.TrafficPort
+
+    if port, err := strconv.Atoi(opts.HealthCheck.Port); err == nil {
+        healthPort = int32(port)
+    }
+
+    if healthPort != opts.TrafficPort || !slices.Contains(trafficProtocols, "tcp") {
+        rules = append(rules, securityGroupRule{
+            egress:     egress,
+            ipProtocol: "tcp",
+            fromPort:   healthPort,
+            toPort:     healthPort,
+            group:      group,
+        })
+    }
+
+    return rules
+}
+
+// desiredLoadBalancerSecurityGroupRules returns the rules of the load balancer's security group: the listener ports
+// open to the allowed cidrs, and nothing let out but the traffic to the service instances.
+func desiredLoadBalancerSecurityGroupRules(opts SetupNewILBServiceOptions) []securityGroupRule {
+    var rules []securityGroupRule
+
+    for _, listener := range opts.Listeners {
+        for _, ipProtocol := range ipProtocols(listener.Protocol, opts.LoadBalancerIpProtocol) {
+            for _, cidr := range opts.AllowCIDRs {
+                rules = append(rules, securityGroupRule{
+                    ipProtocol: ipProtocol,
+                    fromPort:   listener.Port,
+                    toPort:     listener.Port,
+                    cidr:       cidr,
+                })
+            }
+        }
+    }
+
+    return append(rules, trafficRules(opts, true, opts.InstanceSecurityGroupResourceName())...)
+}
+
+// ownsLoadBalancerSecurityGroupRule reports whether the rule of the load balancer's security group is the service's to
+// reconcile. A shared load balancer's security group has the rules of every service on it, of which the service owns
+// the ones reaching its instances and the listener rules tagged as wanted by it (which other services sharing the
+// port may want as well). Listener rules tagged as wanted by no service, like ones added in the console, are left
+// alone.
+func ownsLoadBalancerSecurityGroupRule(opts SetupNewILBServiceOptions, rule securityGroupRule, owners []string) bool {
+    if len(opts.SharedLoadBalancer) == 0 {
+        return true
+    }
+
+    if rule.egress {
+        return rule.group == opts.InstanceSecurityGroupResourceName()
+    }
+
+    return rule.isListenerRule() && slices.Contains(owners, opts.ServiceName)
+}
+
+// desiredInstanceSecurityGroupRules returns the ingress rules of the instances' security group, which only let in
+// traffic from the load balancer.
+func desiredInstanceSecurityGroupRules(opts SetupNewILBServiceOptions) []securityGroupRule {
+    return trafficRules(opts, false, opts.LoadBalancerResourceName())
+}
+
+// authorizeSecurityGroupRule adds the rule to the security group, tagged with the tags given, if any.
+func (svc *AwsumILBService) authorizeSecurityGroupRule(
+    ctx context.Context,
+    groupId string,
+    rule securityGroupRule,
+    peerGroupId string,
+    tags map[string]string,
+) error {
+    var (
+        err        error
+        permission = ec2Types.IpPermission{
+            FromPort:   memory.Pointer(rule.fromPort),
+            ToPort:     memory.Pointer(rule.toPort),
+            IpProtocol: memory.Pointer(rule.ipProtocol),
+        }
+    )
+
+    switch {
+    case len(rule.group) > 0:
+        permission.UserIdGroupPairs = []ec2Types.UserIdGroupPair{
+            {
+                GroupId:     memory.Pointer(peerGroupId),
+                Description: memory.Pointer(rule.description()),
+            },
+        }
+    case strings.Contains(rule.cidr, ":"):
+        permission.Ipv6Ranges = []ec2Types.Ipv6Range{
+            {
+                CidrIpv6:    memory.Pointer(rule.cidr),
+                Description: memory.Pointer(rule.description()),
+            },
+        }
+    default:
+        permission.IpRanges = []ec2Types.IpRange{
+            {
+                CidrIp:      memory.Pointer(rule.cidr),
+                Description: memory.Pointer(rule.description()),
+            },
+        }
+    }
+
+    var tagSpecifications []ec2Types.TagSpecification
+
+    if len(tags) > 0 {
+        tagSpecifications = []ec2Types.TagSpecification{
+            {
+                ResourceType: ec2Types.ResourceTypeSecurityGroupRule,
+                Tags:         ec2Tags(tags),
+            },
+        }
+    }
+
+    if rule.egress {
+        _, err = svc.EC2.Client().AuthorizeSecurityGroupEgress(ctx, &ec2.AuthorizeSecurityGroupEgressInput{
+            GroupId:           memory.Pointer(groupId),
+            IpPermissions:     []ec2Types.IpPermission{permission},
+            TagSpecifications: tagSpecifications,
+        })
+    } else {
+        _, err = svc.EC2.Client().AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+            GroupId:           memory.Pointer(groupId),
+            IpPermissions:     []ec2Types.IpPermission{permission},
+            TagSpecifications: tagSpecifications,
+        })
+    }
+
+    if err != nil && !strings.Contains(err.Error(), "already exists") {
+        return err
+    }
+
+    return nil
+}
+
+func (svc *AwsumILBService) revokeSecurityGroupRule(ctx context.Context, groupId string, egress bool, ruleId string) error {
+    var err error
+
+    if egress {
+        _, err = svc.EC2.Client().RevokeSecurityGroupEgress(ctx, &ec2.RevokeSecurityGroupEgressInput{
+            GroupId:              memory.Pointer(groupId),
+            SecurityGroupRuleIds: []string{ruleId},
+        })
+    } else {
+        _, err = svc.EC2.Client().RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+            GroupId:              memory.Pointer(groupId),
+            SecurityGroupRuleIds: []string{ruleId},
+        })
+    }
+
+    return err
+}
+
+// planSecurityGroupCreation plans creating an empty security group in the vpc. A new security group lets out all
+// traffic, which is revoked unless keepEgress is set.
+func (svc *AwsumILBService) planSecurityGroupCreation(
+    plan *ILBServicePlan,
+    name string,
+    vpcId string,
+    tags map[string]string,
+    keepEgress bool,
+    created func(resources *ILBServiceResources, groupId string),
+) {
+    plan.add(PlanActionCreate, "security group", name, func(ctx context.Context, resources *ILBServiceResources) error {
+        groupTags, err := svc.creationTags(ctx, tags)
+
+        if err != nil {
+            return err
+        }
+
+        cesgOutput, err := svc.EC2.CreateEmptySecurityGroup(ctx, name, vpcId, groupTags)
+
+        if err != nil {
+            return err
+        }
+
+        created(resources, memory.Unwrap(cesgOutput.GroupId))
+
+        if keepEgress {
+            return nil
+        }
+
+        _, err = svc.EC2.Client().RevokeSecurityGroupEgress(ctx, &ec2.RevokeSecurityGroupEgressInput{
+            GroupId: cesgOutput.GroupId,
+            IpPermissions: []ec2Types.IpPermission{
+                {
+                    IpProtocol: memory.Pointer("-1"),
+                    IpRanges:   []ec2Types.IpRange{{CidrIp: memory.Pointer("0.0.0.0/0")}},
+                },
+            },
+        })
+
+        return err
+    }, fmt.Sprintf("vpc: %s", vpcId))
+}
+
+// planSecurityGroupRules makes the rules of the security group that the service owns match the desired ones, leaving
+// rules that are already correct, and the ones it doesn't own, untouched. With shared set, listener rules are shared
+// between the services tagged as wanting them: the service only tags or untags itself on a rule others want as well,
+// so each port is open to every range any service on it allows.
+func (svc *AwsumILBService) planSecurityGroupRules(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    name string,
+    desired []securityGroupRule,
+    names map[string]string,
+    owns func(rule securityGroupRule, owners []string) bool,
+    shared bool,
+) error {
+    var (
+        rules []ec2Types.SecurityGroupRule
+        err   error
+        found = make(map[securityGroupRule]struct{})
+    )
+
+    // a security group yet to be created has no rules
+    if groupId := opts.securityGroupId(&plan.Resources, name); len(groupId) > 0 {
+        rules, err = svc.EC2.GetAllSecurityGroupRules(opts.Ctx, groupId)
+
+        if err != nil {
+            return err
+        }
+    }
+
+    for _, rule := range rules {
+        var (
+            existing = existingSecurityGroupRule(rule, names)
+            owners   = securityGroupRuleOwners(rule)
+            ruleId   = memory.Unwrap(rule.SecurityGroupRuleId)
+            owned    = slices.Contains(owners, opts.ServiceName)
+        )
+
+        if slices.Contains(desired, existing) {
+            found[existing] = struct{}{}
+
+            if shared && existing.isListenerRule() && !owned {
+                plan.add(PlanActionModify, "security group rule", name, func(ctx context.Context, _ *ILBServiceResources) error {
+                    _, err := svc.EC2.Client().CreateTags(ctx, &ec2.CreateTagsInput{
+                        Resources: []string{ruleId},
+                        Tags:      ec2Tags(listenerRuleOwnerTags(opts.ServiceName)),
+                    })
+
+                    return err
+                }, existing.String(), fmt.Sprintf("owners: + %s", opts.ServiceName))
+            }
+
+            continue
+        }
+
+        if !owns(existing, owners) {
+            continue
+        }
+
+        // the rule stays for the other services wanting it
+        if shared && existing.isListenerRule() && len(owners) > 1 {
+            svc.planListenerRuleUntagging(plan, name, ruleId, existing, opts.ServiceName)
+            continue
+        }
+
+        plan.add(PlanActionDelete, "security group rule", name, func(ctx context.Context, resources *ILBServiceResources) error {
+            return svc.revokeSecurityGroupRule(ctx, opts.securityGroupId(resources, name), existing.egress, ruleId)
+        }, existing.String())
+    }
+
+    for _, rule := range desired {
+        if _, ok := found[rule]; ok {
+            continue
+        }
+
+        // the traffic and load balancer ports may be the same, in which case so are their rules
+        found[rule] = struct{}{}
+
+        var tags map[string]string
+
+        if shared && rule.isListenerRule() {
+            tags = listenerRuleOwnerTags(opts.ServiceName)
+        }
+
+        plan.add(PlanActionCreate, "security group rule", name, func(ctx context.Context, resources *ILBServiceResources) error {
+            return svc.authorizeSecurityGroupRule(
+                ctx,
+                opts.securityGroupId(resources, name),
+                rule,
+                opts.securityGroupId(resources, rule.group),
+                tags,
+            )
+        }, rule.String())
+    }
+
+    return nil
+}
+
+// listenerRuleOwnerTags returns the tag marking a listener rule of a shared load balancer's security group as wanted by
+// the service.
+func listenerRuleOwnerTags(serviceName string) map[string]string {
+    return map[string]string{ListenerRuleOwnerTagKeyPrefix + serviceName: serviceName}
+}
+
+// planListenerRuleUntagging plans removing the service from the services tagged as wanting the listener rule, which
+// stays for the others.
+func (svc *AwsumILBService) planListenerRuleUntagging(
+    plan *ILBServicePlan,
+    name string,
+    ruleId string,
+    rule securityGroupRule,
+    serviceName string,
+) {
+    plan.add(PlanActionModify, "security group rule", name, func(ctx context.Context, _ *ILBServiceResources) error {
+        _, err := svc.EC2.Client().DeleteTags(ctx, &ec2.DeleteTagsInput{
+            Resources: []string{ruleId},
+            Tags:      ec2Tags(listenerRuleOwnerTags(serviceName)),
+        })
+
+        return err
+    }, rule.String(), fmt.Sprintf("owners: - %s", serviceName))
+}
+
+// planListenerRulesRelease plans the service giving up the listener rules of a shared load balancer's security group
+// tagged as wanted by it: revoking the ones only it wants and untagging it from the rest.
+func (svc *AwsumILBService) planListenerRulesRelease(
+    ctx context.Context,
+    plan *ILBServicePlan,
+    name string,
+    groupId string,
+    serviceName string,
+) error {
+    rules, err := svc.EC2.GetAllSecurityGroupRules(ctx, groupId)
+
+    if err != nil {
+        return err
+    }
+
+    for _, rule := range rules {
+        var (
+            existing = existingSecurityGroupRule(rule, nil)
+            owners   = securityGroupRuleOwners(rule)
+            ruleId   = memory.Unwrap(rule.SecurityGroupRuleId)
+        )
+
+        if !existing.isListenerRule() || !slices.Contains(owners, serviceName) {
+            continue
+        }
+
+        if len(owners) > 1 {
+            svc.planListenerRuleUntagging(plan, name, ruleId, existing, serviceName)
+            continue
+        }
+
+        plan.add(PlanActionDelete, "security group rule", name, func(ctx context.Context, _ *ILBServiceResources) error {
+            return svc.revokeSecurityGroupRule(ctx, groupId, existing.egress, ruleId)
+        }, existing.String())
+    }
+
+    return nil
+}
+
+// planSecurityGroups plans the load balancer's security group and the one attached to the service instances, the
+// latter only letting in traffic from the former.
+func (svc *AwsumILBService) planSecurityGroups(opts SetupNewILBServiceOptions, plan *ILBServicePlan, vpcId string) error {
+    var (
+        lbName        = opts.LoadBalancerResourceName()
+        instancesName = opts.InstanceSecurityGroupResourceName()
+        names         = make(map[string]string)
+    )
+
+    lbSecurityGroup, err := svc.findSecurityGroup(opts.Ctx, plan.recorded.securityGroupId(opts.SharedLoadBalancer), lbName)
+
+    if err != nil {
+        return err
+    }
+
+    instancesSecurityGroup, err := svc.findSecurityGroup(opts.Ctx, plan.recorded.InstanceSecurityGroupId, instancesName)
+
+    if err != nil {
+        return err
+    }
+
+    if lbSecurityGroup == nil {
+        svc.planSecurityGroupCreation(plan, lbName, vpcId, opts.loadBalancerTags(), false, func(resources *ILBServiceResources, groupId string) {
+            resources.SecurityGroupId = groupId
+        })
+    } else {
+        plan.Resources.SecurityGroupId = memory.Unwrap(lbSecurityGroup.GroupId)
+        names[plan.Resources.SecurityGroupId] = lbName
+
+        svc.planSecurityGroupTags(plan, lbName, lbSecurityGroup, opts.loadBalancerTags())
+    }
+
+    // the instances' other security groups decide what they may reach, this one only adds what they let in
+    if instancesSecurityGroup == nil {
+        svc.planSecurityGroupCreation(plan, instancesName, vpcId, opts.serviceTags(), true, func(resources *ILBServiceResources, groupId string) {
+            resources.InstanceSecurityGroupId = groupId
+        })
+    } else {
+        plan.Resources.InstanceSecurityGroupId = memory.Unwrap(instancesSecurityGroup.GroupId)
+        names[plan.Resources.InstanceSecurityGroupId] = instancesName
+
+        svc.planSecurityGroupTags(plan, instancesName, instancesSecurityGroup, opts.serviceTags())
+    }
+
+    if err = svc.planSecurityGroupRules(
+        opts,
+        plan,
+        lbName,
+        desiredLoadBalancerSecurityGroupRules(opts),
+        names,
+        func(rule securityGroupRule, owners []string) bool {
+            return ownsLoadBalancerSecurityGroupRule(opts, rule, owners)
+        },
+        len(opts.SharedLoadBalancer) > 0,
+    ); err != nil {
+        return err
+    }
+
+    // the instances' security group keeps the egress it was created with, only its ingress is reconciled
+    return svc.planSecurityGroupRules(
+        opts,
+        plan,
+        instancesName,
+        desiredInstanceSecurityGroupRules(opts),
+        names,
+        func(rule securityGroupRule, _ []string) bool {
+            return !rule.egress
+        },
+        false,
+    )
+}
+
+// instanceSecurityGroupIds returns the ids of the security groups the instance has.
+func instanceSecurityGroupIds(instance *Instance) []string {
+    var groupIds []string
+
+    for _, group := range instance.Info.SecurityGroups {
+        groupIds = append(groupIds, memory.Unwrap(group.GroupId))
+    }
+
+    return groupIds
+}
+
+// hasSecurityGroup reports whether the instance has the security group, which never holds for one yet to be created.
+func hasSecurityGroup(instance *Instance, groupId string) bool {
+    return len(groupId) > 0 && slices.Contains(instanceSecurityGroupIds(instance), groupId)
+}
+
+// planInstanceSecurityGroupAttachments plans adding the instances' security group to the target instances that don't
+// have it yet, alongside the security groups they already have.
+func (svc *AwsumILBService) planInstanceSecurityGroupAttachments(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    targetInstances []*Instance,
+) {
+    name := opts.InstanceSecurityGroupResourceName()
+
+    for _, instance := range targetInstances {
+        if hasSecurityGroup(instance, plan.Resources.InstanceSecurityGroupId) {
+            continue
+        }
+
+        id := memory.Unwrap(instance.Info.InstanceId)
+
+        plan.add(PlanActionModify, "instance security groups", fmt.Sprintf("%s (%s)", id, instance.GetName()), func(ctx context.Context, resources *ILBServiceResources) error {
+            return svc.EC2.SetInstanceSecurityGroups(ctx, id, append(instanceSecurityGroupIds(instance), resources.InstanceSecurityGroupId))
+        }, fmt.Sprintf("+ %s", name))
+    }
+}
+
+// planInstanceSecurityGroupDetachments plans removing the instances' security group from the instances that have it
+// but aren't in serving, meant to run once they have been drained.
+func (svc *AwsumILBService) planInstanceSecurityGroupDetachments(
+    plan *ILBServicePlan,
+    name string,
+    groupId string,
+    instances []*Instance,
+    serving map[string]struct{},
+) {
+    for _, instance := range instances {
+        id := memory.Unwrap(instance.Info.InstanceId)
+
+        if _, ok := serving[id]; ok || !hasSecurityGroup(instance, groupId) {
+            continue
+        }
+
+        plan.add(PlanActionModify, "instance security groups", fmt.Sprintf("%s (%s)", id, instance.GetName()), func(ctx context.Context, _ *ILBServiceResources) error {
+            return svc.EC2.SetInstanceSecurityGroups(ctx, id, slices.DeleteFunc(instanceSecurityGroupIds(instance), func(id string) bool {
+                return id == groupId
+            }))
+        }, fmt.Sprintf("- %s", name))
+    }
+}
+
+// planInstanceSecurityGroupDeletion plans removing the service instances' security group: revoking the rules of other
+// security groups (like a shared load balancer's) that reference it, taking it off the instances and deleting it.
+func (svc *AwsumILBService) planInstanceSecurityGroupDeletion(opts DeleteILBServiceOptions, plan *ILBServicePlan) error {
+    name := AwsumILBInstancesResourceName(opts.ServiceName)
+
+    securityGroup, err := svc.findSecurityGroup(opts.Ctx, plan.recorded.InstanceSecurityGroupId, name)
+
+    if err != nil || securityGroup == nil {
+        return err
+    }
+
+    groupId := memory.Unwrap(securityGroup.GroupId)
+
+    referencing, err := svc.EC2.GetSecurityGroupRulesReferencing(opts.Ctx, groupId)
+
+    if err != nil {
+        return err
+    }
+
+    for _, rule := range referencing {
+        var (
+            ruleGroupId = memory.Unwrap(rule.GroupId)
+            ruleId      = memory.Unwrap(rule.SecurityGroupRuleId)
+            existing    = existingSecurityGroupRule(rule, map[string]string{groupId: name})
+        )
+
+        plan.add(PlanActionDelete, "security group rule", ruleGroupId, func(ctx context.Context, _ *ILBServiceResources) error {
+            return svc.revokeSecurityGroupRule(ctx, ruleGroupId, existing.egress, ruleId)
+        }, existing.String())
+    }
+
+    instances, err := svc.EC2.GetAllInstances(opts.Ctx)
+
+    if err != nil {
+        return err
+    }
+
+    svc.planInstanceSecurityGroupDetachments(plan, name, groupId, instances, nil)
+
+    plan.add(PlanActionDelete, "security group", name, func(ctx context.Context, _ *ILBServiceResources) error {
+        return svc.EC2.DeleteSecurityGroupWhenUnused(ctx, groupId, ServiceDeleteTimeout)
+    }, groupId)
+
+    return nil
+}
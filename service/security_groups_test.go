@@ -0,0 +1,31 @@
+package service_test
+
+import (
+    "testing"
+
+    "github.com/levelshatter/awsum/service"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestParseAllowCIDRs(t *testing.T) {
+    tests := []struct {
+        cidrs    []string
+        ipv6     bool
+        expected []string
+        err      error
+    }{
+        {nil, false, []string{"0.0.0.0/0"}, nil},
+        {nil, true, []string{"0.0.0.0/0", "::/0"}, nil},
+        {[]string{"203.0.113.0/24", "2001:db8::/32"}, false, []string{"203.0.113.0/24", "2001:db8::/32"}, nil},
+        {[]string{"203.0.113.7/24", "203.0.113.0/24"}, true, []string{"203.0.113.0/24"}, nil},
+        {[]string{"203.0.113.7"}, false, nil, service.ErrInvalidAllowCIDR},
+        {[]string{"example.com/24"}, false, nil, service.ErrInvalidAllowCIDR},
+    }
+
+    for _, test := range tests {
+        cidrs, err := service.ParseAllowCIDRs(test.cidrs, test.ipv6)
+
+        assert.ErrorIs(t, err, test.err, "cidrs: %v", test.cidrs)
+        assert.Equal(t, test.expected, cidrs, "cidrs: %v", test.cidrs)
+    }
+}
@@ -0,0 +1,571 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "path"
+    "slices"
+    "strconv"
+    "strings"
+
+    elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+const (
+    // MaxRuleConditionValues is how many hosts and paths a single listener rule may match, combined.
+    MaxRuleConditionValues = 5
+    // MaxRulePriority is the highest priority a listener rule can have.
+    MaxRulePriority = 50000
+)
+
+var (
+    ErrSharedLoadBalancerNeedsConditions = errors.New("a service on a shared load balancer needs at least one host or path to match")
+    ErrConditionsNeedSharedLoadBalancer  = errors.New("hosts and paths can only be matched on a shared load balancer")
+    ErrTooManyRuleConditionValues        = errors.New("a listener rule can match at most 5 hosts and paths combined")
+    ErrSharedListenerProtocolMismatch    = errors.New("shared load balancer already has a listener on the port with another protocol")
+    ErrRulePriorityTaken                 = errors.New("listener rule priority is already used by another service")
+    ErrNoFreeRulePriority                = errors.New("no free listener rule priority left")
+)
+
+func ruleName(loadBalancerName string, port int32, serviceName string) string {
+    return fmt.Sprintf("%s/%s", listenerName(loadBalancerName, port), serviceName)
+}
+
+// ruleConditions returns the listener rule conditions routing the service's hosts and paths to it.
+func ruleConditions(opts SetupNewILBServiceOptions) []types.RuleCondition {
+    var conditions []types.RuleCondition
+
+    if len(opts.Hosts) > 0 {
+        conditions = append(conditions, types.RuleCondition{
+            Field:            memory.Pointer("host-header"),
+            HostHeaderConfig: &types.HostHeaderConditionConfig{Values: opts.Hosts},
+        })
+    }
+
+    if len(opts.Paths) > 0 {
+        conditions = append(conditions, types.RuleCondition{
+            Field:             memory.Pointer("path-pattern"),
+            PathPatternConfig: &types.PathPatternConditionConfig{Values: opts.Paths},
+        })
+    }
+
+    return conditions
+}
+
+// ruleHosts returns the hosts a listener rule matches.
+func ruleHosts(rule types.Rule) []string {
+    var hosts []string
+
+    for _, condition := range rule.Conditions {
+        if memory.Unwrap(condition.Field) != "host-header" {
+            continue
+        }
+
+        if condition.HostHeaderConfig != nil {
+            hosts = append(hosts, condition.HostHeaderConfig.Values...)
+        } else {
+            hosts = append(hosts, condition.Values...)
+        }
+    }
+
+    return hosts
+}
+
+// OrphanedRuleHosts returns the hosts the removed listener rules match that none of the remaining ones do, whose dns
+// records can go along with the removed rules. A remaining rule matching no host in particular (only paths) matches
+// every host, and so leaves none orphaned.
+func OrphanedRuleHosts(removed []types.Rule, remaining []types.Rule) []string {
+    var patterns []string
+
+    for _, rule := range remaining {
+        if memory.Unwrap(rule.IsDefault) {
+            continue
+        }
+
+        hosts := ruleHosts(rule)
+
+        if len(hosts) == 0 {
+            return nil
+        }
+
+        for _, host := range hosts {
+            patterns = append(patterns, normalizeDNSName(host))
+        }
+    }
+
+    var orphaned []string
+
+    for _, rule := range removed {
+        for _, host := range ruleHosts(rule) {
+            host = normalizeDNSName(host)
+
+            if slices.Contains(orphaned, host) {
+                continue
+            }
+
+            // host conditions can have * and ? wildcards, neither of which matches across the dots of a host name
+            // any more than path.Match does across slashes
+            if slices.ContainsFunc(patterns, func(pattern string) bool {
+                matched, err := path.Match(pattern, host)
+                return err == nil && matched
+            }) {
+                continue
+            }
+
+            orphaned = append(orphaned, host)
+        }
+    }
+
+    return orphaned
+}
+
+// describeRuleConditions returns a line per value the conditions match, sorted so that conditions can be compared.
+func describeRuleConditions(conditions []types.RuleCondition) []string {
+    var lines []string
+
+    for _, condition := range conditions {
+        field := memory.Unwrap(condition.Field)
+        values := condition.Values
+
+        if condition.HostHeaderConfig != nil {
+            values = condition.HostHeaderConfig.Values
+        } else if condition.PathPatternConfig != nil {
+            values = condition.PathPatternConfig.Values
+        }
+
+        for _, value := range values {
+            lines = append(lines, fmt.Sprintf("%s: %s", field, value))
+        }
+    }
+
+    slices.Sort(lines)
+
+    return lines
+}
+
+// serviceRules returns the rules of the listener that forward to the target group.
+func (svc *AwsumILBService) serviceRules(ctx context.Context, listenerArn string, targetGroupArn string) ([]types.Rule, error) {
+    if len(targetGroupArn) == 0 {
+        return nil, nil
+    }
+
+    rules, err := svc.ELBv2.GetAllRulesInListener(ctx, listenerArn)
+
+    if err != nil {
+        return nil, err
+    }
+
+    return slices.DeleteFunc(rules, func(rule types.Rule) bool {
+        return memory.Unwrap(rule.IsDefault) || !forwardsTo(rule.Actions, targetGroupArn)
+    }), nil
+}
+
+func (svc *AwsumILBService) planRuleDeletion(plan *ILBServicePlan, name string, rule types.Rule) {
+    plan.add(PlanActionDelete, "listener rule", name, func(ctx context.Context, _ *ILBServiceResources) error {
+        _, err := svc.ELBv2.Client().DeleteRule(ctx, &elbv2.DeleteRuleInput{
+            RuleArn: rule.RuleArn,
+        })
+
+        return err
+    }, append([]string{fmt.Sprintf("priority: %s", memory.Unwrap(rule.Priority))}, describeRuleConditions(rule.Conditions)...)...)
+}
+
+// planRuleRemoval removes the listener rules forwarding to the target group, leaving the listener and the rules of
+// other services alone.
+func (svc *AwsumILBService) planRuleRemoval(
+    plan *ILBServicePlan,
+    loadBalancerName string,
+    listener types.Listener,
+    targetGroupArn string,
+) error {
+    rules, err := svc.serviceRules(plan.Options.Ctx, memory.Unwrap(listener.ListenerArn), targetGroupArn)
+
+    if err != nil {
+        return err
+    }
+
+    for _, rule := range rules {
+        svc.planRuleDeletion(plan, ruleName(loadBalancerName, memory.Unwrap(listener.Port), plan.Options.ServiceName), rule)
+    }
+
+    return nil
+}
+
+// planSharedListeners makes the shared load balancer route the service's hosts and paths to it. Unlike planListeners,
+// it never removes listeners or certificates, since other services depend on them: missing listeners are created with
+// a default action that responds 404, and the service gets a listener rule on each of them.
+func (svc *AwsumILBService) planSharedListeners(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    listeners []types.Listener,
+    certs []types.Certificate,
+) error {
+    var (
+        name     = opts.LoadBalancerResourceName()
+        existing = make(map[int32]types.Listener)
+        desired  = make(map[int32]struct{})
+    )
+
+    for _, listener := range opts.Listeners {
+        desired[listener.Port] = struct{}{}
+    }
+
+    for _, listener := range listeners {
+        port := memory.Unwrap(listener.Port)
+
+        if _, ok := desired[port]; ok {
+            existing[port] = listener
+            continue
+        }
+
+        if err := svc.planRuleRemoval(plan, name, listener, plan.Resources.TargetGroupArn); err != nil {
+            return err
+        }
+    }
+
+    for _, listener := range opts.Listeners {
+        var listenerCerts []types.Certificate
+
+        if listener.usesCertificates() {
+            listenerCerts = certs
+        }
+
+        if listener.RedirectPort == 0 {
+            listener.notFound = true
+        }
+
+        current, ok := existing[listener.Port]
+
+        if !ok {
+            svc.planListenerCreation(opts, plan, listener, listenerCerts)
+
+            if listener.RedirectPort == 0 {
+                if err := svc.planRule(opts, plan, listener, nil); err != nil {
+                    return err
+                }
+            }
+
+            continue
+        }
+
+        if current.Protocol != listener.Protocol {
+            return fmt.Errorf(
+                "%w, port %d is %s instead of %s",
+                ErrSharedListenerProtocolMismatch,
+                listener.Port,
+                current.Protocol,
+                listener.Protocol,
+            )
+        }
+
+        plan.Resources.ListenerArns[listener.Port] = memory.Unwrap(current.ListenerArn)
+
+        if err := svc.planSharedListenerCertificates(opts, plan, listener, current, listenerCerts); err != nil {
+            return err
+        }
+
+        if listener.RedirectPort > 0 {
+            if currentAction, desiredAction := describeListenerAction(current), listener.describeAction(nil); currentAction != desiredAction {
+                plan.add(PlanActionModify, "listener", listenerName(name, listener.Port), func(ctx context.Context, _ *ILBServiceResources) error {
+                    _, err := svc.ELBv2.Client().ModifyListener(ctx, &elbv2.ModifyListenerInput{
+                        ListenerArn:    current.ListenerArn,
+                        DefaultActions: listener.defaultActions(nil),
+                    })
+
+                    return err
+                }, fmt.Sprintf("%s -> %s", currentAction, desiredAction))
+            }
+
+            continue
+        }
+
+        if err := svc.planRule(opts, plan, listener, &current); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// planSharedListenerCertificates adds the service's certificates the shared listener doesn't have yet.
+func (svc *AwsumILBService) planSharedListenerCertificates(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    listener ListenerOptions,
+    current types.Listener,
+    certs []types.Certificate,
+) error {
+    if len(certs) == 0 {
+        return nil
+    }
+
+    attached, err := svc.ELBv2.GetAllListenerCertificates(opts.Ctx, memory.Unwrap(current.ListenerArn))
+
+    if err != nil {
+        return err
+    }
+
+    var (
+        toAdd       []types.Certificate
+        certChanges []string
+    )
+
+    for _, cert := range certs {
+        if !slices.ContainsFunc(attached, func(attachedCert types.Certificate) bool {
+            return memory.Unwrap(attachedCert.CertificateArn) == memory.Unwrap(cert.CertificateArn)
+        }) {
+            toAdd = append(toAdd, cert)
+            certChanges = append(certChanges, fmt.Sprintf("+ %s", memory.Unwrap(cert.CertificateArn)))
+        }
+    }
+
+    if len(toAdd) == 0 {
+        return nil
+    }
+
+    plan.add(PlanActionModify, "listener certificates", listenerName(opts.LoadBalancerResourceName(), listener.Port), func(ctx context.Context, _ *ILBServiceResources) error {
+        _, err := svc.ELBv2.Client().AddListenerCertificates(ctx, &elbv2.AddListenerCertificatesInput{
+            ListenerArn:  current.ListenerArn,
+            Certificates: toAdd,
+        })
+
+        return err
+    }, certChanges...)
+
+    return nil
+}
+
+// planRule makes the service's listener rule on a shared listener match its hosts and paths, creating it with the
+// requested priority, or the lowest free one, when there is none yet. current is nil when the listener is yet to be
+// created.
+func (svc *AwsumILBService) planRule(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    listener ListenerOptions,
+    current *types.Listener,
+) error {
+    var (
+        name              = ruleName(opts.LoadBalancerResourceName(), listener.Port, opts.ServiceName)
+        conditions        = ruleConditions(opts)
+        desiredConditions = describeRuleConditions(conditions)
+        used              = make(map[int32]struct{})
+        own               *types.Rule
+    )
+
+    if current != nil {
+        rules, err := svc.ELBv2.GetAllRulesInListener(opts.Ctx, memory.Unwrap(current.ListenerArn))
+
+        if err != nil {
+            return err
+        }
+
+        for _, rule := range rules {
+            if memory.Unwrap(rule.IsDefault) {
+                continue
+            }
+
+            if forwardsTo(rule.Actions, plan.Resources.TargetGroupArn) {
+                own = &rule
+                continue
+            }
+
+            // removed along with the target group being replaced
+            if forwardsTo(rule.Actions, plan.replacedTargetGroupArn) {
+                continue
+            }
+
+            if priority, err := strconv.ParseInt(memory.Unwrap(rule.Priority), 10, 32); err == nil {
+                used[int32(priority)] = struct{}{}
+            }
+        }
+    }
+
+    if own != nil {
+        ruleArn := own.RuleArn
+
+        var (
+            changes           []string
+            currentConditions = describeRuleConditions(own.Conditions)
+            currentAction     = describeForward(own.Actions)
+            desiredAction     = describeForward(plannedForwardActions(opts, plan.Resources))
+        )
+
+        if !slices.Equal(currentConditions, desiredConditions) {
+            changes = append(changes, fmt.Sprintf("%s -> %s", strings.Join(currentConditions, ", "), strings.Join(desiredConditions, ", ")))
+        }
+
+        if currentAction != desiredAction {
+            changes = append(changes, fmt.Sprintf("%s -> %s", currentAction, desiredAction))
+        }
+
+        if len(changes) > 0 {
+            plan.add(PlanActionModify, "listener rule", name, func(ctx context.Context, resources *ILBServiceResources) error {
+                _, err := svc.ELBv2.Client().ModifyRule(ctx, &elbv2.ModifyRuleInput{
+                    RuleArn:    ruleArn,
+                    Conditions: conditions,
+                    Actions:    serviceForwardActions(opts, resources),
+                })
+
+                return err
+            }, changes...)
+        }
+
+        currentPriority := memory.Unwrap(own.Priority)
+
+        if opts.Priority > 0 && currentPriority != strconv.Itoa(int(opts.Priority)) {
+            if _, ok := used[opts.Priority]; ok {
+                return fmt.Errorf("%w, %d on port %d", ErrRulePriorityTaken, opts.Priority, listener.Port)
+            }
+
+            plan.add(PlanActionModify, "listener rule", name, func(ctx context.Context, _ *ILBServiceResources) error {
+                _, err := svc.ELBv2.Client().SetRulePriorities(ctx, &elbv2.SetRulePrioritiesInput{
+                    RulePriorities: []types.RulePriorityPair{{
+                        RuleArn:  ruleArn,
+                        Priority: memory.Pointer(opts.Priority),
+                    }},
+                })
+
+                return err
+            }, fmt.Sprintf("priority: %s -> %d", currentPriority, opts.Priority))
+        }
+
+        return nil
+    }
+
+    priority := opts.Priority
+
+    if priority > 0 {
+        if _, ok := used[priority]; ok {
+            return fmt.Errorf("%w, %d on port %d", ErrRulePriorityTaken, priority, listener.Port)
+        }
+    } else {
+        for priority = 1; priority <= MaxRulePriority; priority++ {
+            if _, ok := used[priority]; !ok {
+                break
+            }
+        }
+
+        if priority > MaxRulePriority {
+            return fmt.Errorf("%w on port %d", ErrNoFreeRulePriority, listener.Port)
+        }
+    }
+
+    changes := append([]string{fmt.Sprintf("priority: %d", priority)}, desiredConditions...)
+    changes = append(changes, describeForward(plannedForwardActions(opts, plan.Resources)))
+
+    plan.add(PlanActionCreate, "listener rule", name, func(ctx context.Context, resources *ILBServiceResources) error {
+        tags, err := svc.creationTags(ctx, opts.serviceTags())
+
+        if err != nil {
+            return err
+        }
+
+        _, err = svc.ELBv2.Client().CreateRule(ctx, &elbv2.CreateRuleInput{
+            ListenerArn: memory.Pointer(resources.ListenerArns[listener.Port]),
+            Priority:    memory.Pointer(priority),
+            Conditions:  conditions,
+            Actions:     serviceForwardActions(opts, resources),
+            Tags:        elbv2Tags(tags),
+        })
+
+        return err
+    }, changes...)
+
+    return nil
+}
+
+// planSharedServiceDeletion removes a service from the shared load balancer(s) its target group is on: the dns records
+// of the hosts only its rules match, the rules themselves and the listener rules of the security groups only it wants.
+// The load balancers and their listeners stay for the other services.
+func (svc *AwsumILBService) planSharedServiceDeletion(
+    opts DeleteILBServiceOptions,
+    plan *ILBServicePlan,
+    targetGroup *types.TargetGroup,
+) error {
+    targetGroupArn := memory.Unwrap(targetGroup.TargetGroupArn)
+
+    for _, loadBalancerArn := range targetGroup.LoadBalancerArns {
+        loadBalancer, err := svc.ELBv2.GetLoadBalancerByArn(opts.Ctx, loadBalancerArn)
+
+        if err != nil {
+            return err
+        }
+
+        if loadBalancer == nil {
+            continue
+        }
+
+        name := memory.Unwrap(loadBalancer.LoadBalancerName)
+
+        listeners, err := svc.ELBv2.GetAllListenersInLoadBalancer(opts.Ctx, loadBalancerArn)
+
+        if err != nil {
+            return err
+        }
+
+        var (
+            rules     = make(map[int32][]types.Rule)
+            removed   []types.Rule
+            remaining []types.Rule
+        )
+
+        for _, listener := range listeners {
+            listenerRules, err := svc.ELBv2.GetAllRulesInListener(opts.Ctx, memory.Unwrap(listener.ListenerArn))
+
+            if err != nil {
+                return err
+            }
+
+            for _, rule := range listenerRules {
+                if !memory.Unwrap(rule.IsDefault) && forwardsTo(rule.Actions, targetGroupArn) {
+                    rules[memory.Unwrap(listener.Port)] = append(rules[memory.Unwrap(listener.Port)], rule)
+                    removed = append(removed, rule)
+                } else {
+                    remaining = append(remaining, rule)
+                }
+            }
+        }
+
+        // other services may still be routed the same hosts, on other paths
+        hosts := OrphanedRuleHosts(removed, remaining)
+
+        if !opts.KeepDNS && len(hosts) > 0 {
+            aliases, err := svc.Route53.GetAliasRecordsPointingTo(opts.Ctx, memory.Unwrap(loadBalancer.DNSName))
+
+            if err != nil {
+                return err
+            }
+
+            for _, alias := range aliases {
+                if !slices.ContainsFunc(hosts, func(host string) bool {
+                    return normalizeDNSName(host) == normalizeDNSName(memory.Unwrap(alias.Record.Name))
+                }) {
+                    continue
+                }
+
+                plan.add(PlanActionDelete, "dns record", memory.Unwrap(alias.Record.Name), func(ctx context.Context, _ *ILBServiceResources) error {
+                    return svc.Route53.DeleteRecord(ctx, alias.HostedZoneId, alias.Record)
+                }, fmt.Sprintf("%s alias: %s", alias.Record.Type, memory.Unwrap(alias.Record.AliasTarget.DNSName)))
+            }
+        }
+
+        for _, listener := range listeners {
+            port := memory.Unwrap(listener.Port)
+
+            for _, rule := range rules[port] {
+                svc.planRuleDeletion(plan, ruleName(name, port, opts.ServiceName), rule)
+            }
+        }
+
+        for _, groupId := range loadBalancer.SecurityGroups {
+            if err = svc.planListenerRulesRelease(opts.Ctx, plan, name, groupId, opts.ServiceName); err != nil {
+                return err
+            }
+        }
+    }
+
+    return nil
+}
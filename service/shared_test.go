@@ -0,0 +1,48 @@
+package service_test
+
+import (
+    "testing"
+
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/internal/memory"
+    "github.com/levelshatter/awsum/service"
+    "github.com/stretchr/testify/assert"
+)
+
+func listenerRule(hosts []string, paths []string) types.Rule {
+    var conditions []types.RuleCondition
+
+    if len(hosts) > 0 {
+        conditions = append(conditions, types.RuleCondition{
+            Field:            memory.Pointer("host-header"),
+            HostHeaderConfig: &types.HostHeaderConditionConfig{Values: hosts},
+        })
+    }
+
+    if len(paths) > 0 {
+        conditions = append(conditions, types.RuleCondition{
+            Field:             memory.Pointer("path-pattern"),
+            PathPatternConfig: &types.PathPatternConditionConfig{Values: paths},
+        })
+    }
+
+    return types.Rule{Conditions: conditions, IsDefault: memory.Pointer(false)}
+}
+
+func TestOrphanedRuleHosts(t *testing.T) {
+    var (
+        v1          = listenerRule([]string{"api.example.com", "v1.example.com"}, []string{"/v1/*"})
+        v2          = listenerRule([]string{"API.example.com"}, []string{"/v2/*"})
+        docs        = listenerRule([]string{"docs.example.com"}, nil)
+        pathOnly    = listenerRule(nil, []string{"/status"})
+        defaultRule = types.Rule{IsDefault: memory.Pointer(true)}
+        wildcard    = listenerRule([]string{"*.example.com"}, nil)
+    )
+
+    // the host the other service on the load balancer is still routed keeps its records
+    assert.Equal(t, []string{"v1.example.com"}, service.OrphanedRuleHosts([]types.Rule{v1}, []types.Rule{v2, docs, defaultRule}))
+    assert.Equal(t, []string{"api.example.com", "v1.example.com"}, service.OrphanedRuleHosts([]types.Rule{v1}, []types.Rule{docs, defaultRule}))
+    assert.Empty(t, service.OrphanedRuleHosts([]types.Rule{v1}, []types.Rule{wildcard}))
+    assert.Empty(t, service.OrphanedRuleHosts([]types.Rule{v1}, []types.Rule{pathOnly}))
+    assert.Empty(t, service.OrphanedRuleHosts([]types.Rule{pathOnly}, nil))
+}
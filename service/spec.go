@@ -0,0 +1,365 @@
+package service
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "gopkg.in/yaml.v3"
+)
+
+var (
+    ErrSpecNoServiceName     = errors.New("service is required")
+    ErrSpecNoInstances       = errors.New("instances needs a selector, like name")
+    ErrSpecDuplicateService  = errors.New("service is defined more than once")
+    ErrSpecNoServices        = errors.New("no services defined")
+    ErrInvalidIpProtocol     = errors.New("ip protocol must be tcp or udp")
+    ErrInvalidHealthPath     = errors.New("health check path must start with /")
+    ErrInvalidHealthPort     = errors.New("health check port must be a port number or traffic-port")
+    ErrInvalidHealthProtocol = errors.New("health check protocol must be http, https or tcp")
+    ErrInvalidHealthInterval = errors.New("health check interval must be whole seconds from 5s to 300s")
+    ErrInvalidHealthy        = errors.New("healthy threshold must be from 2 to 10")
+    ErrInvalidUnhealthy      = errors.New("unhealthy threshold must be from 2 to 10")
+    ErrInvalidPriority       = errors.New("priority must be from 1 to 50000")
+)
+
+// ServiceSpec is a load-balanced service as described in a spec file, its fields named after the load-balance flags.
+type ServiceSpec struct {
+    Service            string            `yaml:"service"`
+    Instances          InstancesSpec     `yaml:"instances"`
+    Listeners          []string          `yaml:"listeners"`
+    RedirectHTTP       bool              `yaml:"redirect-http"`
+    IpProtocol         string            `yaml:"ip-protocol"`
+    Certificates       []string          `yaml:"certificates"`
+    Domains            []string          `yaml:"domains"`
+    Private            bool              `yaml:"private"`
+    LoadBalancerType   string            `yaml:"lb-type"`
+    AlpnPolicy         string            `yaml:"alpn-policy"`
+    IpAddressType      string            `yaml:"ip-address-type"`
+    AllowCIDRs         []string          `yaml:"allow-cidrs"`
+    IPv6               bool              `yaml:"ipv6"`
+    SharedLoadBalancer string            `yaml:"shared-lb"`
+    Hosts              []string          `yaml:"hosts"`
+    Paths              []string          `yaml:"paths"`
+    Priority           int32             `yaml:"priority"`
+    HealthCheck        HealthCheckSpec   `yaml:"health-check"`
+    TargetGroup        TargetGroupSpec   `yaml:"target-group"`
+    LoadBalancer       LoadBalancerSpec  `yaml:"load-balancer"`
+    Tags               map[string]string `yaml:"tags"`
+}
+
+// InstancesSpec selects the instances a service is load balanced on.
+type InstancesSpec struct {
+    Name string `yaml:"name"`
+}
+
+type HealthCheckSpec struct {
+    Path               string        `yaml:"path"`
+    Port               string        `yaml:"port"`
+    Protocol           string        `yaml:"protocol"`
+    Interval           time.Duration `yaml:"interval"`
+    HealthyThreshold   int32         `yaml:"healthy-threshold"`
+    UnhealthyThreshold int32         `yaml:"unhealthy-threshold"`
+    Matcher            string        `yaml:"matcher"`
+}
+
+type TargetGroupSpec struct {
+    Stickiness          string         `yaml:"stickiness"`
+    StickinessDuration  time.Duration  `yaml:"stickiness-duration"`
+    StickinessCookie    string         `yaml:"stickiness-cookie"`
+    DeregistrationDelay *time.Duration `yaml:"deregistration-delay"`
+    SlowStart           *time.Duration `yaml:"slow-start"`
+    Algorithm           string         `yaml:"algorithm"`
+}
+
+type LoadBalancerSpec struct {
+    IdleTimeout        time.Duration `yaml:"idle-timeout"`
+    HTTP2              *bool         `yaml:"http2"`
+    DeletionProtection *bool         `yaml:"deletion-protection"`
+    AccessLogs         string        `yaml:"access-logs"`
+}
+
+// SpecError is an invalid value in a spec file, pointing at the line it is on.
+type SpecError struct {
+    File string
+    Line int
+    Err  error
+}
+
+func (e *SpecError) Error() string {
+    return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+}
+
+func (e *SpecError) Unwrap() error {
+    return e.Err
+}
+
+// specLine finds the line of the field at the path (of keys and list indexes) in a document, or of the deepest field
+// along the path that exists. A field is on the line of its key, even when its value starts on the next one.
+func specLine(document *yaml.Node, path ...string) int {
+    var (
+        node = document
+        line = document.Line
+    )
+
+    if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+        node = node.Content[0]
+    }
+
+    for _, key := range path {
+        var next *yaml.Node
+
+        switch node.Kind {
+        case yaml.MappingNode:
+            for i := 0; i+1 < len(node.Content); i += 2 {
+                if node.Content[i].Value == key {
+                    next, line = node.Content[i+1], node.Content[i].Line
+                    break
+                }
+            }
+        case yaml.SequenceNode:
+            if i, err := strconv.Atoi(key); err == nil && i < len(node.Content) {
+                next, line = node.Content[i], node.Content[i].Line
+            }
+        }
+
+        if next == nil {
+            break
+        }
+
+        node = next
+    }
+
+    return line
+}
+
+// ParseServiceSpecs parses a spec file of one or more yaml documents, each describing a service, into the options to
+// load balance them with. Every document is validated as far as is possible without aws, errors pointing at the line
+// of the offending value.
+func ParseServiceSpecs(file string, data []byte) ([]SetupNewILBServiceOptions, error) {
+    var (
+        specs    []SetupNewILBServiceOptions
+        decoder  = yaml.NewDecoder(bytes.NewReader(data))
+        nodes    = yaml.NewDecoder(bytes.NewReader(data))
+        services = make(map[string]struct{})
+    )
+
+    // unknown fields are most likely typos, which would otherwise be silently left out
+    decoder.KnownFields(true)
+
+    for {
+        var (
+            spec     ServiceSpec
+            document yaml.Node
+        )
+
+        err := decoder.Decode(&spec)
+
+        if errors.Is(err, io.EOF) {
+            break
+        }
+
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", file, err)
+        }
+
+        if err = nodes.Decode(&document); err != nil {
+            return nil, fmt.Errorf("%s: %w", file, err)
+        }
+
+        // empty documents, like after a trailing ---, define nothing
+        if len(document.Content) == 0 || document.Content[0].Tag == "!!null" {
+            continue
+        }
+
+        opts, err := spec.options(file, &document)
+
+        if err != nil {
+            return nil, err
+        }
+
+        if _, ok := services[opts.ServiceName]; ok {
+            return nil, &SpecError{file, specLine(&document, "service"), fmt.Errorf("%w, '%s'", ErrSpecDuplicateService, opts.ServiceName)}
+        }
+
+        services[opts.ServiceName] = struct{}{}
+        specs = append(specs, opts)
+    }
+
+    if len(specs) == 0 {
+        return nil, fmt.Errorf("%s: %w", file, ErrSpecNoServices)
+    }
+
+    return specs, nil
+}
+
+// options validates the spec, turning it into the options to load balance the service with.
+func (s ServiceSpec) options(file string, document *yaml.Node) (SetupNewILBServiceOptions, error) {
+    invalid := func(err error, path ...string) error {
+        return &SpecError{file, specLine(document, path...), err}
+    }
+
+    if len(s.Service) == 0 {
+        return SetupNewILBServiceOptions{}, invalid(ErrSpecNoServiceName)
+    }
+
+    if err := ValidateServiceName(s.Service); err != nil {
+        return SetupNewILBServiceOptions{}, invalid(err, "service")
+    }
+
+    if len(s.SharedLoadBalancer) > 0 {
+        if err := ValidateServiceName(s.SharedLoadBalancer); err != nil {
+            return SetupNewILBServiceOptions{}, invalid(err, "shared-lb")
+        }
+    }
+
+    if len(s.Instances.Name) == 0 {
+        return SetupNewILBServiceOptions{}, invalid(ErrSpecNoInstances, "instances")
+    }
+
+    // a listener that can't be parsed by itself is pointed at, otherwise it's the listeners as a whole that don't fit
+    for i, definition := range s.Listeners {
+        if _, _, _, err := ParseListenerDefinitions([]string{definition}, false); err != nil {
+            return SetupNewILBServiceOptions{}, invalid(err, "listeners", strconv.Itoa(i))
+        }
+    }
+
+    listeners, trafficPort, trafficProtocol, err := ParseListenerDefinitions(s.Listeners, s.RedirectHTTP)
+
+    if err != nil {
+        return SetupNewILBServiceOptions{}, invalid(err, "listeners")
+    }
+
+    ipProtocol := strings.ToLower(s.IpProtocol)
+
+    if len(ipProtocol) == 0 {
+        ipProtocol = "tcp"
+    }
+
+    if ipProtocol != "tcp" && ipProtocol != "udp" {
+        return SetupNewILBServiceOptions{}, invalid(ErrInvalidIpProtocol, "ip-protocol")
+    }
+
+    requestedType, err := ParseLoadBalancerType(s.LoadBalancerType)
+
+    if err != nil {
+        return SetupNewILBServiceOptions{}, invalid(err, "lb-type")
+    }
+
+    lbType, err := ResolveLoadBalancerType(requestedType, listeners, trafficProtocol)
+
+    if err != nil {
+        return SetupNewILBServiceOptions{}, invalid(err, "listeners")
+    }
+
+    if len(s.AlpnPolicy) > 0 && !slices.Contains(AlpnPolicies, s.AlpnPolicy) {
+        return SetupNewILBServiceOptions{}, invalid(ErrInvalidAlpnPolicy, "alpn-policy")
+    }
+
+    ipAddressType, err := ParseIpAddressType(s.IpAddressType)
+
+    if err != nil {
+        return SetupNewILBServiceOptions{}, invalid(err, "ip-address-type")
+    }
+
+    for i, cidr := range s.AllowCIDRs {
+        if _, err = ParseAllowCIDRs([]string{cidr}, false); err != nil {
+            return SetupNewILBServiceOptions{}, invalid(err, "allow-cidrs", strconv.Itoa(i))
+        }
+    }
+
+    if s.Priority < 0 || s.Priority > MaxRulePriority {
+        return SetupNewILBServiceOptions{}, invalid(ErrInvalidPriority, "priority")
+    }
+
+    healthCheck, field, err := s.HealthCheck.options()
+
+    if err != nil {
+        return SetupNewILBServiceOptions{}, invalid(err, "health-check", field)
+    }
+
+    targetGroupAttributes := TargetGroupAttributes(s.TargetGroup)
+
+    if err = targetGroupAttributes.Validate(lbType); err != nil {
+        return SetupNewILBServiceOptions{}, invalid(err, "target-group")
+    }
+
+    loadBalancerAttributes := LoadBalancerAttributes(s.LoadBalancer)
+
+    if err = loadBalancerAttributes.Validate(lbType); err != nil {
+        return SetupNewILBServiceOptions{}, invalid(err, "load-balancer")
+    }
+
+    if err = ValidateTags(s.Tags); err != nil {
+        return SetupNewILBServiceOptions{}, invalid(err, "tags")
+    }
+
+    return SetupNewILBServiceOptions{
+        ServiceName:            s.Service,
+        TargetInstanceFilters:  InstanceFilters{Name: s.Instances.Name},
+        Listeners:              listeners,
+        LoadBalancerIpProtocol: ipProtocol,
+        TrafficPort:            trafficPort,
+        TrafficProtocol:        trafficProtocol,
+        CertificateNames:       s.Certificates,
+        DomainNames:            s.Domains,
+        Private:                s.Private,
+        HealthCheck:            healthCheck,
+        LoadBalancerType:       requestedType,
+        AlpnPolicy:             s.AlpnPolicy,
+        SharedLoadBalancer:     s.SharedLoadBalancer,
+        Hosts:                  s.Hosts,
+        Paths:                  s.Paths,
+        Priority:               s.Priority,
+        AllowCIDRs:             s.AllowCIDRs,
+        IPv6:                   s.IPv6,
+        IpAddressType:          ipAddressType,
+        TargetGroupAttributes:  targetGroupAttributes,
+        LoadBalancerAttributes: loadBalancerAttributes,
+        Tags:                   s.Tags,
+    }, nil
+}
+
+// options validates the health check settings the way the load-balance flags are, returning the field at fault when
+// one is invalid.
+func (s HealthCheckSpec) options() (HealthCheckOptions, string, error) {
+    if len(s.Path) > 0 && !strings.HasPrefix(s.Path, "/") {
+        return HealthCheckOptions{}, "path", ErrInvalidHealthPath
+    }
+
+    if port, err := strconv.Atoi(s.Port); len(s.Port) > 0 && s.Port != "traffic-port" && (err != nil || port < 1 || port > 65535) {
+        return HealthCheckOptions{}, "port", ErrInvalidHealthPort
+    }
+
+    if len(s.Protocol) > 0 && !slices.Contains([]string{"http", "https", "tcp"}, strings.ToLower(s.Protocol)) {
+        return HealthCheckOptions{}, "protocol", ErrInvalidHealthProtocol
+    }
+
+    if s.Interval != 0 && (s.Interval < time.Second*5 || s.Interval > time.Second*300 || s.Interval%time.Second != 0) {
+        return HealthCheckOptions{}, "interval", ErrInvalidHealthInterval
+    }
+
+    if s.HealthyThreshold != 0 && (s.HealthyThreshold < 2 || s.HealthyThreshold > 10) {
+        return HealthCheckOptions{}, "healthy-threshold", ErrInvalidHealthy
+    }
+
+    if s.UnhealthyThreshold != 0 && (s.UnhealthyThreshold < 2 || s.UnhealthyThreshold > 10) {
+        return HealthCheckOptions{}, "unhealthy-threshold", ErrInvalidUnhealthy
+    }
+
+    return HealthCheckOptions{
+        Path:               s.Path,
+        Port:               s.Port,
+        Protocol:           types.ProtocolEnum(strings.ToUpper(s.Protocol)),
+        Interval:           s.Interval,
+        HealthyThreshold:   s.HealthyThreshold,
+        UnhealthyThreshold: s.UnhealthyThreshold,
+        Matcher:            s.Matcher,
+    }, "", nil
+}
@@ -0,0 +1,104 @@
+package service_test
+
+import (
+    "testing"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/service"
+    "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
+)
+
+func TestParseServiceSpecs(t *testing.T) {
+    specs, err := service.ParseServiceSpecs("services.yaml", []byte(`
+service: website
+instances:
+  name: website
+listeners:
+  - 443:80:https:http
+redirect-http: true
+certificates: [example.com]
+domains: [example.com]
+health-check:
+  path: /healthz
+  port: 8081
+  interval: 10s
+target-group:
+  stickiness: lb_cookie
+  stickiness-duration: 1h
+  deregistration-delay: 0s
+load-balancer:
+  deletion-protection: true
+---
+service: dns
+instances:
+  name: dns
+listeners: ["53:53:udp:udp"]
+---
+`))
+
+    require.NoError(t, err)
+    require.Len(t, specs, 2)
+
+    website := specs[0]
+
+    assert.Equal(t, "website", website.ServiceName)
+    assert.Equal(t, "website", website.TargetInstanceFilters.Name)
+    assert.Equal(t, []service.ListenerOptions{
+        {Port: 443, Protocol: types.ProtocolEnumHttps},
+        {Port: 80, Protocol: types.ProtocolEnumHttp, RedirectPort: 443},
+    }, website.Listeners)
+    assert.Equal(t, int32(80), website.TrafficPort)
+    assert.Equal(t, "tcp", website.LoadBalancerIpProtocol)
+    assert.Equal(t, "8081", website.HealthCheck.Port)
+    assert.Equal(t, time.Second*10, website.HealthCheck.Interval)
+    assert.Equal(t, time.Hour, website.TargetGroupAttributes.StickinessDuration)
+    require.NotNil(t, website.TargetGroupAttributes.DeregistrationDelay)
+    assert.Equal(t, time.Duration(0), *website.TargetGroupAttributes.DeregistrationDelay)
+    require.NotNil(t, website.LoadBalancerAttributes.DeletionProtection)
+    assert.True(t, *website.LoadBalancerAttributes.DeletionProtection)
+
+    assert.Equal(t, "dns", specs[1].ServiceName)
+    assert.Equal(t, types.ProtocolEnumUdp, specs[1].TrafficProtocol)
+}
+
+func TestParseServiceSpecsErrors(t *testing.T) {
+    tests := []struct {
+        spec string
+        line int
+        err  error
+    }{
+        {"instances:\n  name: web\nlisteners: [80:80:http:http]\n", 1, service.ErrSpecNoServiceName},
+        {"service: web\nlisteners: [80:80:http:http]\ninstances: {}\n", 3, service.ErrSpecNoInstances},
+        {"\nservice: my_web\ninstances:\n  name: web\nlisteners: [80:80:http:http]\n", 2, service.ErrInvalidServiceName},
+        {"service: web\ninstances:\n  name: web\nlisteners: [80:80:http:http]\nshared-lb: -main\nhosts: [example.com]\n", 5, service.ErrInvalidServiceName},
+        {"service: web\ninstances:\n  name: web\nlisteners:\n  - 80:80:http:http\n  - 443:80:https\n", 6, service.ErrInvalidListenerDefinition},
+        {"service: web\ninstances:\n  name: web\nlisteners:\n  - 80:80:http:http\n  - 81:81:http:http\n", 4, service.ErrListenersMustShareTraffic},
+        {"service: web\ninstances:\n  name: web\nlisteners: [80:80:http:http]\nhealth-check:\n  port: 0\n", 6, service.ErrInvalidHealthPort},
+        {"service: web\ninstances:\n  name: web\nlisteners: [80:80:http:http]\nallow-cidrs:\n  - 10.0.0.0/8\n  - nowhere\n", 7, service.ErrInvalidAllowCIDR},
+        {"service: web\ninstances:\n  name: web\nlisteners: [53:53:udp:udp]\ntarget-group:\n  stickiness: lb_cookie\n", 5, service.ErrAttributeUnsupported},
+        {"service: web\ninstances:\n  name: web\nlisteners: [80:80:http:http]\ntags:\n  awsum:team: web\n", 5, service.ErrReservedTagKey},
+        {"service: web\ninstances:\n  name: web\nlisteners: [80:80:http:http]\n---\nservice: web\ninstances:\n  name: web\nlisteners: [81:81:http:http]\n", 6, service.ErrSpecDuplicateService},
+    }
+
+    for _, test := range tests {
+        _, err := service.ParseServiceSpecs("service.yaml", []byte(test.spec))
+
+        var specErr *service.SpecError
+
+        if assert.ErrorAs(t, err, &specErr, "spec: %s", test.spec) {
+            assert.Equal(t, test.line, specErr.Line, "spec: %s", test.spec)
+        }
+
+        assert.ErrorIs(t, err, test.err, "spec: %s", test.spec)
+    }
+
+    _, err := service.ParseServiceSpecs("service.yaml", []byte("service: web\nlistener: [80:80:http:http]\n"))
+
+    assert.ErrorContains(t, err, "line 2")
+
+    _, err = service.ParseServiceSpecs("service.yaml", []byte("---\n"))
+
+    assert.ErrorIs(t, err, service.ErrSpecNoServices)
+}
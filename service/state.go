@@ -0,0 +1,745 @@
+package service
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "maps"
+    "os"
+    "os/user"
+    "path"
+    "strings"
+    "sync"
+    "time"
+
+    ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+    s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+    "github.com/aws/smithy-go"
+    "github.com/levelshatter/awsum/internal/files"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+// StateFilename is the name of the state file in the awsum data directory, used when no other state location is given.
+const StateFilename = "awsum-state.json"
+
+var (
+    ErrStateLocked              = errors.New("state is locked")
+    ErrInvalidStateLocation     = errors.New("s3 state location must be like s3://bucket/key")
+    ErrServiceNotInState        = errors.New("service isn't in the state")
+    ErrImportedResourceNotFound = errors.New("resource to import doesn't exist")
+)
+
+// State records the arns and ids of the aws resources of every service awsum manages, so they are found by what they
+// are rather than by their names.
+type State struct {
+    // Serial is incremented on every write of the state.
+    Serial   int64                    `json:"serial"`
+    Services map[string]*ServiceState `json:"services"`
+}
+
+// ServiceState is the state's record of a single service: the resources it owns, along with the shared load balancer
+// (and its listeners and security group) it is on, if any.
+type ServiceState struct {
+    SharedLoadBalancer      string           `json:"shared_load_balancer,omitempty"`
+    LoadBalancerArn         string           `json:"load_balancer_arn,omitempty"`
+    ListenerArns            map[int32]string `json:"listener_arns,omitempty"`
+    TargetGroupArn          string           `json:"target_group_arn,omitempty"`
+    CanaryTargetGroupArn    string           `json:"canary_target_group_arn,omitempty"`
+    SecurityGroupId         string           `json:"security_group_id,omitempty"`
+    InstanceSecurityGroupId string           `json:"instance_security_group_id,omitempty"`
+    // Inputs are the options the service was last applied with, which drift is checked against. Services imported
+    // into the state have none until they are applied.
+    Inputs    *ServiceInputs `json:"inputs,omitempty"`
+    UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// ServiceInputs are the options a service was last applied with, as the state records them.
+type ServiceInputs struct {
+    InstanceName           string                     `json:"instance_name"`
+    Listeners              []ListenerOptions          `json:"listeners"`
+    LoadBalancerIpProtocol string                     `json:"load_balancer_ip_protocol,omitempty"`
+    TrafficPort            int32                      `json:"traffic_port"`
+    TrafficProtocol        types.ProtocolEnum         `json:"traffic_protocol"`
+    CertificateNames       []string                   `json:"certificate_names,omitempty"`
+    DomainNames            []string                   `json:"domain_names,omitempty"`
+    Private                bool                       `json:"private,omitempty"`
+    HealthCheck            HealthCheckOptions         `json:"health_check"`
+    Canary                 int32                      `json:"canary,omitempty"`
+    LoadBalancerType       types.LoadBalancerTypeEnum `json:"load_balancer_type,omitempty"`
+    AlpnPolicy             string                     `json:"alpn_policy,omitempty"`
+    SharedLoadBalancer     string                     `json:"shared_load_balancer,omitempty"`
+    Hosts                  []string                   `json:"hosts,omitempty"`
+    Paths                  []string                   `json:"paths,omitempty"`
+    Priority               int32                      `json:"priority,omitempty"`
+    AllowCIDRs             []string                   `json:"allow_cidrs,omitempty"`
+    IPv6                   bool                       `json:"ipv6,omitempty"`
+    IpAddressType          types.IpAddressType        `json:"ip_address_type,omitempty"`
+    TargetGroupAttributes  TargetGroupAttributes      `json:"target_group_attributes"`
+    LoadBalancerAttributes LoadBalancerAttributes     `json:"load_balancer_attributes"`
+    Tags                   map[string]string          `json:"tags,omitempty"`
+    // AppliedHealthCheck, AppliedTargetGroupAttributes and AppliedLoadBalancerAttributes are the health check and the
+    // attributes awsum manages as they were right after the service was applied, the ones the inputs left as they were
+    // included.
+    AppliedHealthCheck            *HealthCheckOptions `json:"applied_health_check,omitempty"`
+    AppliedTargetGroupAttributes  map[string]string   `json:"applied_target_group_attributes,omitempty"`
+    AppliedLoadBalancerAttributes map[string]string   `json:"applied_load_balancer_attributes,omitempty"`
+}
+
+func newServiceInputs(opts SetupNewILBServiceOptions) *ServiceInputs {
+    return &ServiceInputs{
+        InstanceName:           opts.TargetInstanceFilters.Name,
+        Listeners:              opts.Listeners,
+        LoadBalancerIpProtocol: opts.LoadBalancerIpProtocol,
+        TrafficPort:            opts.TrafficPort,
+        TrafficProtocol:        opts.TrafficProtocol,
+        CertificateNames:       opts.CertificateNames,
+        DomainNames:            opts.DomainNames,
+        Private:                opts.Private,
+        HealthCheck:            opts.HealthCheck,
+        Canary:                 opts.Canary,
+        LoadBalancerType:       opts.LoadBalancerType,
+        AlpnPolicy:             opts.AlpnPolicy,
+        SharedLoadBalancer:     opts.SharedLoadBalancer,
+        Hosts:                  opts.Hosts,
+        Paths:                  opts.Paths,
+        Priority:               opts.Priority,
+        AllowCIDRs:             opts.AllowCIDRs,
+        IPv6:                   opts.IPv6,
+        IpAddressType:          opts.IpAddressType,
+        TargetGroupAttributes:  opts.TargetGroupAttributes,
+        LoadBalancerAttributes: opts.LoadBalancerAttributes,
+        Tags:                   opts.Tags,
+    }
+}
+
+// options returns the options to apply the service with again.
+func (i ServiceInputs) options(ctx context.Context, serviceName string) SetupNewILBServiceOptions {
+    return SetupNewILBServiceOptions{
+        Ctx:                    ctx,
+        ServiceName:            serviceName,
+        TargetInstanceFilters:  InstanceFilters{Name: i.InstanceName},
+        Listeners:              i.Listeners,
+        LoadBalancerIpProtocol: i.LoadBalancerIpProtocol,
+        TrafficPort:            i.TrafficPort,
+        TrafficProtocol:        i.TrafficProtocol,
+        CertificateNames:       i.CertificateNames,
+        DomainNames:            i.DomainNames,
+        Private:                i.Private,
+        HealthCheck:            i.HealthCheck,
+        Canary:                 i.Canary,
+        LoadBalancerType:       i.LoadBalancerType,
+        AlpnPolicy:             i.AlpnPolicy,
+        SharedLoadBalancer:     i.SharedLoadBalancer,
+        Hosts:                  i.Hosts,
+        Paths:                  i.Paths,
+        Priority:               i.Priority,
+        AllowCIDRs:             i.AllowCIDRs,
+        IPv6:                   i.IPv6,
+        IpAddressType:          i.IpAddressType,
+        TargetGroupAttributes:  i.TargetGroupAttributes,
+        LoadBalancerAttributes: i.LoadBalancerAttributes,
+        Tags:                   i.Tags,
+    }
+}
+
+// loadBalancerArn returns the recorded load balancer of the service, unless it has since moved onto, off or between
+// shared load balancers.
+func (s ServiceState) loadBalancerArn(sharedLoadBalancer string) string {
+    if s.SharedLoadBalancer != sharedLoadBalancer {
+        return ""
+    }
+
+    return s.LoadBalancerArn
+}
+
+// securityGroupId returns the recorded security group of the service's load balancer, like loadBalancerArn.
+func (s ServiceState) securityGroupId(sharedLoadBalancer string) string {
+    if s.SharedLoadBalancer != sharedLoadBalancer {
+        return ""
+    }
+
+    return s.SecurityGroupId
+}
+
+// record sets the state's record of the service to the resources it has. The inputs, given when the options were
+// applied in full, become its last applied inputs, the previous ones are kept otherwise.
+func (s *State) record(opts SetupNewILBServiceOptions, resources *ILBServiceResources, inputs *ServiceInputs) {
+    if previous, ok := s.Services[opts.ServiceName]; ok && inputs == nil {
+        inputs = previous.Inputs
+    }
+
+    s.Services[opts.ServiceName] = &ServiceState{
+        SharedLoadBalancer:      opts.SharedLoadBalancer,
+        LoadBalancerArn:         resources.LoadBalancerArn,
+        ListenerArns:            maps.Clone(resources.ListenerArns),
+        TargetGroupArn:          resources.TargetGroupArn,
+        CanaryTargetGroupArn:    resources.CanaryTargetGroupArn,
+        SecurityGroupId:         resources.SecurityGroupId,
+        InstanceSecurityGroupId: resources.InstanceSecurityGroupId,
+        Inputs:                  inputs,
+        UpdatedAt:               time.Now().UTC(),
+    }
+}
+
+// StateLock describes who holds the lock of the state.
+type StateLock struct {
+    Holder  string    `json:"holder"`
+    Created time.Time `json:"created"`
+}
+
+func newStateLock() StateLock {
+    holder := "unknown"
+
+    if current, err := user.Current(); err == nil {
+        holder = current.Username
+    }
+
+    if hostname, err := os.Hostname(); err == nil {
+        holder = fmt.Sprintf("%s@%s", holder, hostname)
+    }
+
+    return StateLock{
+        Holder:  fmt.Sprintf("%s (pid %d)", holder, os.Getpid()),
+        Created: time.Now().UTC(),
+    }
+}
+
+// lockedError describes the lock already held at the location.
+func lockedError(held []byte, location string) error {
+    var lock StateLock
+
+    if err := json.Unmarshal(held, &lock); err != nil || len(lock.Holder) == 0 {
+        return fmt.Errorf("%w, remove %s if no other awsum is running", ErrStateLocked, location)
+    }
+
+    return fmt.Errorf(
+        "%w by %s since %s, remove %s if it isn't running anymore",
+        ErrStateLocked,
+        lock.Holder,
+        lock.Created.Format(time.DateTime),
+        location,
+    )
+}
+
+// StateBackend is where the state is kept. It is only ever written while holding its lock.
+type StateBackend interface {
+    // Location describes where the state is kept.
+    Location() string
+    // Read returns the contents of the state, nil if there is no state yet.
+    Read(ctx context.Context) ([]byte, error)
+    Write(ctx context.Context, data []byte) error
+    // Lock takes the lock of the state, failing with ErrStateLocked if it is already held.
+    Lock(ctx context.Context, lock StateLock) error
+    Unlock(ctx context.Context) error
+}
+
+// LocalStateBackend keeps the state in a local file, locked by creating a lock file next to it.
+type LocalStateBackend struct {
+    Path string
+}
+
+func (b *LocalStateBackend) lockPath() string {
+    return b.Path + ".lock"
+}
+
+func (b *LocalStateBackend) Location() string {
+    return b.Path
+}
+
+func (b *LocalStateBackend) Read(_ context.Context) ([]byte, error) {
+    data, err := os.ReadFile(b.Path)
+
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, nil
+    }
+
+    return data, err
+}
+
+// Write replaces the state file in one go, so a failed write can't leave it half written.
+func (b *LocalStateBackend) Write(_ context.Context, data []byte) error {
+    temporary := b.Path + ".tmp"
+
+    if err := files.WriteToFile(temporary, data); err != nil {
+        return err
+    }
+
+    return os.Rename(temporary, b.Path)
+}
+
+func (b *LocalStateBackend) Lock(_ context.Context, lock StateLock) error {
+    data, err := json.Marshal(lock)
+
+    if err != nil {
+        return err
+    }
+
+    if err = os.MkdirAll(path.Dir(b.Path), 0755); err != nil {
+        return err
+    }
+
+    // only one process can create the file exclusively, the others find it there
+    f, err := os.OpenFile(b.lockPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+
+    if errors.Is(err, os.ErrExist) {
+        held, _ := os.ReadFile(b.lockPath())
+        return lockedError(held, b.lockPath())
+    }
+
+    if err != nil {
+        return err
+    }
+
+    _, err = f.Write(data)
+
+    return errors.Join(err, f.Close())
+}
+
+func (b *LocalStateBackend) Unlock(_ context.Context) error {
+    return os.Remove(b.lockPath())
+}
+
+// S3StateBackend keeps the state in an s3 object. Its lock is another object next to it, created with a conditional
+// write that fails if it already exists, so no dynamodb table is needed for locking.
+type S3StateBackend struct {
+    S3     *S3
+    Bucket string
+    Key    string
+}
+
+func (b *S3StateBackend) lockKey() string {
+    return b.Key + ".lock"
+}
+
+func (b *S3StateBackend) Location() string {
+    return fmt.Sprintf("s3://%s/%s", b.Bucket, b.Key)
+}
+
+// read returns the contents of the object, nil if it doesn't exist.
+func (b *S3StateBackend) read(ctx context.Context, key string) ([]byte, error) {
+    output, err := b.S3.Client().GetObject(ctx, &s3.GetObjectInput{
+        Bucket: memory.Pointer(b.Bucket),
+        Key:    memory.Pointer(key),
+    })
+
+    var noSuchKey *s3Types.NoSuchKey
+
+    if errors.As(err, &noSuchKey) {
+        return nil, nil
+    }
+
+    if err != nil {
+        return nil, err
+    }
+
+    defer func() {
+        _ = output.Body.Close()
+    }()
+
+    return io.ReadAll(output.Body)
+}
+
+func (b *S3StateBackend) Read(ctx context.Context) ([]byte, error) {
+    return b.read(ctx, b.Key)
+}
+
+func (b *S3StateBackend) Write(ctx context.Context, data []byte) error {
+    _, err := b.S3.Client().PutObject(ctx, &s3.PutObjectInput{
+        Bucket:      memory.Pointer(b.Bucket),
+        Key:         memory.Pointer(b.Key),
+        Body:        bytes.NewReader(data),
+        ContentType: memory.Pointer("application/json"),
+    })
+
+    return err
+}
+
+func (b *S3StateBackend) Lock(ctx context.Context, lock StateLock) error {
+    data, err := json.Marshal(lock)
+
+    if err != nil {
+        return err
+    }
+
+    _, err = b.S3.Client().PutObject(ctx, &s3.PutObjectInput{
+        Bucket:      memory.Pointer(b.Bucket),
+        Key:         memory.Pointer(b.lockKey()),
+        Body:        bytes.NewReader(data),
+        ContentType: memory.Pointer("application/json"),
+        IfNoneMatch: memory.Pointer("*"),
+    })
+
+    var apiErr smithy.APIError
+
+    // the lock object already exists, or another awsum is creating it at the same time
+    if errors.As(err, &apiErr) &&
+        (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict") {
+        held, _ := b.read(ctx, b.lockKey())
+        return lockedError(held, fmt.Sprintf("s3://%s/%s", b.Bucket, b.lockKey()))
+    }
+
+    return err
+}
+
+func (b *S3StateBackend) Unlock(ctx context.Context) error {
+    _, err := b.S3.Client().DeleteObject(ctx, &s3.DeleteObjectInput{
+        Bucket: memory.Pointer(b.Bucket),
+        Key:    memory.Pointer(b.lockKey()),
+    })
+
+    return err
+}
+
+// OpenStateBackend returns the backend of the state at the location: an s3://bucket/key url, the path of a local file,
+// or the state file in the awsum data directory when empty.
+func OpenStateBackend(location string, s3Service *S3) (StateBackend, error) {
+    if bucketKey, ok := strings.CutPrefix(location, "s3://"); ok {
+        bucket, key, _ := strings.Cut(bucketKey, "/")
+
+        if len(bucket) == 0 || len(key) == 0 || strings.HasSuffix(key, "/") {
+            return nil, fmt.Errorf("%w, got '%s'", ErrInvalidStateLocation, location)
+        }
+
+        return &S3StateBackend{S3: s3Service, Bucket: bucket, Key: key}, nil
+    }
+
+    if len(location) == 0 {
+        dataDir, err := files.CreateAwsumDataDirectory()
+
+        if err != nil {
+            return nil, err
+        }
+
+        location = path.Join(dataDir, StateFilename)
+    }
+
+    return &LocalStateBackend{Path: location}, nil
+}
+
+// ParseState decodes the contents of a state, which is empty without any.
+func ParseState(data []byte) (*State, error) {
+    state := &State{}
+
+    if len(data) > 0 {
+        if err := json.Unmarshal(data, state); err != nil {
+            return nil, fmt.Errorf("failed to parse state: %w", err)
+        }
+    }
+
+    if state.Services == nil {
+        state.Services = make(map[string]*ServiceState)
+    }
+
+    return state, nil
+}
+
+func (svc *AwsumILBService) stateBackend() (StateBackend, error) {
+    if svc.State != nil {
+        return svc.State, nil
+    }
+
+    return OpenStateBackend("", nil)
+}
+
+// LoadState reads the state, which is empty if there is none yet.
+func (svc *AwsumILBService) LoadState(ctx context.Context) (*State, error) {
+    backend, err := svc.stateBackend()
+
+    if err != nil {
+        return nil, err
+    }
+
+    data, err := backend.Read(ctx)
+
+    if err != nil {
+        return nil, fmt.Errorf("failed to read state at %s: %w", backend.Location(), err)
+    }
+
+    return ParseState(data)
+}
+
+// serviceState returns the state's record of the service, which is empty if there is none.
+func (svc *AwsumILBService) serviceState(ctx context.Context, serviceName string) (ServiceState, error) {
+    state, err := svc.LoadState(ctx)
+
+    if err != nil {
+        return ServiceState{}, err
+    }
+
+    if recorded, ok := state.Services[serviceName]; ok {
+        return *recorded, nil
+    }
+
+    return ServiceState{}, nil
+}
+
+// lockState takes the lock of the state, unless this awsum already holds it, returning what releases it again.
+func (svc *AwsumILBService) lockState(ctx context.Context, backend StateBackend) (func() error, error) {
+    svc.stateMu.Lock()
+    defer svc.stateMu.Unlock()
+
+    if svc.stateHolds == 0 {
+        if err := backend.Lock(ctx, newStateLock()); err != nil {
+            return nil, err
+        }
+    }
+
+    svc.stateHolds++
+
+    var once sync.Once
+
+    return func() (err error) {
+        once.Do(func() {
+            svc.stateMu.Lock()
+            defer svc.stateMu.Unlock()
+
+            if svc.stateHolds--; svc.stateHolds > 0 {
+                return
+            }
+
+            // the lock is released even when awsum is interrupted, or nothing could change the state until it is removed
+            if unlockErr := backend.Unlock(context.WithoutCancel(ctx)); unlockErr != nil {
+                err = fmt.Errorf("failed to unlock state at %s: %w", backend.Location(), unlockErr)
+            }
+        })
+
+        return err
+    }, nil
+}
+
+// LockState takes the lock of the state until the returned function releases it, so no other awsum can plan or change
+// services in between, like between a plan being made and what applying it created being recorded. This awsum can
+// still update the state while holding it.
+func (svc *AwsumILBService) LockState(ctx context.Context) (func() error, error) {
+    backend, err := svc.stateBackend()
+
+    if err != nil {
+        return nil, err
+    }
+
+    return svc.lockState(ctx, backend)
+}
+
+// updateState changes the state while holding its lock, so no other awsum can change it in between.
+func (svc *AwsumILBService) updateState(ctx context.Context, update func(state *State) error) (err error) {
+    backend, err := svc.stateBackend()
+
+    if err != nil {
+        return err
+    }
+
+    unlock, err := svc.lockState(ctx, backend)
+
+    if err != nil {
+        return err
+    }
+
+    defer func() {
+        err = errors.Join(err, unlock())
+    }()
+
+    data, err := backend.Read(ctx)
+
+    if err != nil {
+        return fmt.Errorf("failed to read state at %s: %w", backend.Location(), err)
+    }
+
+    state, err := ParseState(data)
+
+    if err != nil {
+        return err
+    }
+
+    if err = update(state); err != nil {
+        return err
+    }
+
+    state.Serial++
+
+    if data, err = json.MarshalIndent(state, "", "  "); err != nil {
+        return err
+    }
+
+    if err = backend.Write(ctx, data); err != nil {
+        return fmt.Errorf("failed to write state at %s: %w", backend.Location(), err)
+    }
+
+    return nil
+}
+
+// findLoadBalancer returns the load balancer with the arn recorded in the state, falling back to the one with the name
+// when there is no record of it (or the recorded one is gone).
+func (svc *AwsumILBService) findLoadBalancer(ctx context.Context, arn string, name string) (*types.LoadBalancer, error) {
+    if len(arn) > 0 {
+        if loadBalancer, err := svc.ELBv2.GetLoadBalancerByArn(ctx, arn); err != nil || loadBalancer != nil {
+            return loadBalancer, err
+        }
+    }
+
+    return svc.ELBv2.SearchForLoadBalancerByName(ctx, name)
+}
+
+// findTargetGroup returns the target group recorded in the state, like findLoadBalancer.
+func (svc *AwsumILBService) findTargetGroup(ctx context.Context, arn string, name string) (*types.TargetGroup, error) {
+    if len(arn) > 0 {
+        if targetGroup, err := svc.ELBv2.GetTargetGroupByArn(ctx, arn); err != nil || targetGroup != nil {
+            return targetGroup, err
+        }
+    }
+
+    return svc.ELBv2.SearchForTargetGroupByName(ctx, name)
+}
+
+// findSecurityGroup returns the security group recorded in the state, like findLoadBalancer.
+func (svc *AwsumILBService) findSecurityGroup(ctx context.Context, id string, name string) (*ec2Types.SecurityGroup, error) {
+    if len(id) > 0 {
+        if securityGroup, err := svc.EC2.SearchForSecurityGroupById(ctx, id); err != nil || securityGroup != nil {
+            return securityGroup, err
+        }
+    }
+
+    return svc.EC2.SearchForSecurityGroupByName(ctx, name)
+}
+
+type ImportServiceStateOptions struct {
+    Ctx                context.Context
+    ServiceName        string
+    SharedLoadBalancer string
+    // LoadBalancerArn, TargetGroupArn, SecurityGroupId and InstanceSecurityGroupId are the resources to record, the
+    // ones left empty are looked up by the names awsum gives them.
+    LoadBalancerArn         string
+    TargetGroupArn          string
+    SecurityGroupId         string
+    InstanceSecurityGroupId string
+}
+
+// ImportServiceState records the existing resources of the service in the state, replacing any record of it.
+func (svc *AwsumILBService) ImportServiceState(opts ImportServiceStateOptions) (*ServiceState, error) {
+    var (
+        names = SetupNewILBServiceOptions{
+            ServiceName:        opts.ServiceName,
+            SharedLoadBalancer: opts.SharedLoadBalancer,
+        }
+        resources = &ILBServiceResources{ListenerArns: make(map[int32]string)}
+    )
+
+    if err := ValidateServiceName(opts.ServiceName); err != nil {
+        return nil, err
+    }
+
+    if len(opts.SharedLoadBalancer) > 0 {
+        if err := ValidateServiceName(opts.SharedLoadBalancer); err != nil {
+            return nil, err
+        }
+    }
+
+    loadBalancer, err := svc.findLoadBalancer(opts.Ctx, opts.LoadBalancerArn, names.LoadBalancerResourceName())
+
+    if err != nil {
+        return nil, err
+    }
+
+    if loadBalancer != nil {
+        resources.LoadBalancerArn = memory.Unwrap(loadBalancer.LoadBalancerArn)
+
+        listeners, err := svc.ELBv2.GetAllListenersInLoadBalancer(opts.Ctx, resources.LoadBalancerArn)
+
+        if err != nil {
+            return nil, err
+        }
+
+        for _, listener := range listeners {
+            resources.ListenerArns[memory.Unwrap(listener.Port)] = memory.Unwrap(listener.ListenerArn)
+        }
+    }
+
+    targetGroup, err := svc.findTargetGroup(opts.Ctx, opts.TargetGroupArn, names.AwsumResourceName())
+
+    if err != nil {
+        return nil, err
+    }
+
+    if targetGroup != nil {
+        resources.TargetGroupArn = memory.Unwrap(targetGroup.TargetGroupArn)
+    }
+
+    canaryTargetGroup, err := svc.ELBv2.SearchForTargetGroupByName(opts.Ctx, names.CanaryResourceName())
+
+    if err != nil {
+        return nil, err
+    }
+
+    if canaryTargetGroup != nil {
+        resources.CanaryTargetGroupArn = memory.Unwrap(canaryTargetGroup.TargetGroupArn)
+    }
+
+    securityGroup, err := svc.findSecurityGroup(opts.Ctx, opts.SecurityGroupId, names.LoadBalancerResourceName())
+
+    if err != nil {
+        return nil, err
+    }
+
+    if securityGroup != nil {
+        resources.SecurityGroupId = memory.Unwrap(securityGroup.GroupId)
+    }
+
+    instanceSecurityGroup, err := svc.findSecurityGroup(opts.Ctx, opts.InstanceSecurityGroupId, names.InstanceSecurityGroupResourceName())
+
+    if err != nil {
+        return nil, err
+    }
+
+    if instanceSecurityGroup != nil {
+        resources.InstanceSecurityGroupId = memory.Unwrap(instanceSecurityGroup.GroupId)
+    }
+
+    // resources given explicitly have to be the ones found, not ones that happen to have awsum's names
+    for _, ids := range [][2]string{
+        {opts.LoadBalancerArn, resources.LoadBalancerArn},
+        {opts.TargetGroupArn, resources.TargetGroupArn},
+        {opts.SecurityGroupId, resources.SecurityGroupId},
+        {opts.InstanceSecurityGroupId, resources.InstanceSecurityGroupId},
+    } {
+        if given, found := ids[0], ids[1]; len(given) > 0 && given != found {
+            return nil, fmt.Errorf("%w: %s", ErrImportedResourceNotFound, given)
+        }
+    }
+
+    if loadBalancer == nil && targetGroup == nil {
+        return nil, fmt.Errorf("%w '%s'", ErrServiceNotFound, opts.ServiceName)
+    }
+
+    var recorded *ServiceState
+
+    if err = svc.updateState(opts.Ctx, func(state *State) error {
+        state.record(names, resources, nil)
+        recorded = state.Services[opts.ServiceName]
+
+        // the resources found may not be the ones the service was last applied with
+        recorded.Inputs = nil
+
+        return nil
+    }); err != nil {
+        return nil, err
+    }
+
+    return recorded, nil
+}
+
+// ForgetServiceState removes the state's record of the service, leaving its resources as they are.
+func (svc *AwsumILBService) ForgetServiceState(ctx context.Context, serviceName string) error {
+    return svc.updateState(ctx, func(state *State) error {
+        if _, ok := state.Services[serviceName]; !ok {
+            return fmt.Errorf("%w '%s'", ErrServiceNotInState, serviceName)
+        }
+
+        delete(state.Services, serviceName)
+
+        return nil
+    })
+}
@@ -0,0 +1,126 @@
+package service_test
+
+import (
+    "context"
+    "path"
+    "testing"
+
+    "github.com/levelshatter/awsum/service"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestOpenStateBackend(t *testing.T) {
+    backend, err := service.OpenStateBackend("s3://my-bucket/awsum/state.json", nil)
+
+    if assert.NoError(t, err) {
+        assert.Equal(t, &service.S3StateBackend{Bucket: "my-bucket", Key: "awsum/state.json"}, backend)
+        assert.Equal(t, "s3://my-bucket/awsum/state.json", backend.Location())
+    }
+
+    for _, location := range []string{"s3://my-bucket", "s3://my-bucket/", "s3:///state.json", "s3://my-bucket/awsum/"} {
+        _, err = service.OpenStateBackend(location, nil)
+        assert.ErrorIs(t, err, service.ErrInvalidStateLocation, location)
+    }
+
+    backend, err = service.OpenStateBackend("/tmp/awsum-state.json", nil)
+
+    if assert.NoError(t, err) {
+        assert.Equal(t, &service.LocalStateBackend{Path: "/tmp/awsum-state.json"}, backend)
+    }
+}
+
+func TestParseState(t *testing.T) {
+    state, err := service.ParseState(nil)
+
+    if assert.NoError(t, err) {
+        assert.Zero(t, state.Serial)
+        assert.NotNil(t, state.Services)
+    }
+
+    state, err = service.ParseState([]byte(`{
+        "serial": 3,
+        "services": {
+            "web": {
+                "load_balancer_arn": "arn:lb",
+                "listener_arns": {"443": "arn:listener"},
+                "target_group_arn": "arn:tg",
+                "security_group_id": "sg-1",
+                "inputs": {"instance_name": "web", "listeners": [{"port": 443, "protocol": "HTTPS"}], "tags": {"team": "web"}}
+            }
+        }
+    }`))
+
+    if assert.NoError(t, err) {
+        assert.Equal(t, int64(3), state.Serial)
+        assert.Equal(t, "arn:lb", state.Services["web"].LoadBalancerArn)
+        assert.Equal(t, map[int32]string{443: "arn:listener"}, state.Services["web"].ListenerArns)
+        assert.Equal(t, "sg-1", state.Services["web"].SecurityGroupId)
+
+        if assert.NotNil(t, state.Services["web"].Inputs) {
+            assert.Equal(t, "web", state.Services["web"].Inputs.InstanceName)
+            assert.Equal(t, []service.ListenerOptions{{Port: 443, Protocol: "HTTPS"}}, state.Services["web"].Inputs.Listeners)
+            assert.Equal(t, map[string]string{"team": "web"}, state.Services["web"].Inputs.Tags)
+        }
+    }
+
+    _, err = service.ParseState([]byte(`{"services": []}`))
+    assert.Error(t, err)
+}
+
+func TestLocalStateBackend(t *testing.T) {
+    var (
+        ctx     = context.Background()
+        backend = &service.LocalStateBackend{Path: path.Join(t.TempDir(), "state", "awsum-state.json")}
+    )
+
+    data, err := backend.Read(ctx)
+    assert.NoError(t, err)
+    assert.Nil(t, data)
+
+    assert.NoError(t, backend.Lock(ctx, service.StateLock{Holder: "first"}))
+
+    err = backend.Lock(ctx, service.StateLock{Holder: "second"})
+    assert.ErrorIs(t, err, service.ErrStateLocked)
+    assert.ErrorContains(t, err, "first")
+
+    assert.NoError(t, backend.Write(ctx, []byte(`{"serial": 1}`)))
+    assert.NoError(t, backend.Unlock(ctx))
+
+    data, err = backend.Read(ctx)
+    assert.NoError(t, err)
+    assert.Equal(t, `{"serial": 1}`, string(data))
+
+    assert.NoError(t, backend.Lock(ctx, service.StateLock{Holder: "second"}))
+    assert.NoError(t, backend.Unlock(ctx))
+}
+
+func TestAwsumILBService_LockState(t *testing.T) {
+    var (
+        ctx     = context.Background()
+        backend = &service.LocalStateBackend{Path: path.Join(t.TempDir(), "awsum-state.json")}
+        svc     = &service.AwsumILBService{State: backend}
+    )
+
+    unlock, err := svc.LockState(ctx)
+
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    // holding the lock already, the service can take it again for its own updates
+    unlockInner, err := svc.LockState(ctx)
+
+    if !assert.NoError(t, err) {
+        return
+    }
+
+    assert.ErrorIs(t, backend.Lock(ctx, service.StateLock{Holder: "other"}), service.ErrStateLocked)
+
+    assert.NoError(t, unlockInner())
+    assert.ErrorIs(t, backend.Lock(ctx, service.StateLock{Holder: "other"}), service.ErrStateLocked)
+
+    assert.NoError(t, unlock())
+    assert.NoError(t, unlock())
+    assert.NoError(t, backend.Lock(ctx, service.StateLock{Holder: "other"}))
+    assert.NoError(t, backend.Unlock(ctx))
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+    "context"
+    "fmt"
+    "os"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/service/sts"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+type STS struct {
+    client *sts.Client
+}
+
+func NewSTS(awsConfig aws.Config) *STS {
+    return &STS{
+        client: sts.NewFromConfig(awsConfig),
+    }
+}
+
+func (svc *STS) Client() *sts.Client {
+    if svc == nil || svc.client == nil {
+        fmt.Printf("sts service not initialized!")
+        os.Exit(1)
+    }
+
+    return svc.client
+}
+
+// GetCallerArn returns the arn of the identity awsum calls aws as.
+func (svc *STS) GetCallerArn(ctx context.Context) (string, error) {
+    output, err := svc.Client().GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+
+    if err != nil {
+        return "", err
+    }
+
+    return memory.Unwrap(output.Arn), nil
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "maps"
+    "regexp"
+    "slices"
+    "strings"
+    "unicode/utf8"
+
+    "github.com/aws/aws-sdk-go-v2/service/ec2"
+    ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+    elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+    "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+const (
+    // ManagedByTagKey is the tag marking a resource as created by awsum.
+    ManagedByTagKey = "managed-by"
+    // ServiceTagKey is the tag holding the full name of the service a resource belongs to.
+    ServiceTagKey = "awsum:service"
+    // SharedLoadBalancerTagKey is the tag holding the full name of a shared load balancer (and its security group).
+    SharedLoadBalancerTagKey = "awsum:shared-lb"
+    // VersionTagKey is the tag holding the version of awsum a resource was created with.
+    VersionTagKey = "awsum:version"
+    // CreatedByTagKey is the tag holding the arn of the identity that created a resource.
+    CreatedByTagKey = "awsum:created-by"
+    // ListenerRuleOwnerTagKeyPrefix starts the tags of a shared load balancer's security group rule, one per service
+    // wanting the rule, followed by the service's name.
+    ListenerRuleOwnerTagKeyPrefix = "awsum:listener-rule-owner:"
+    // MaxTags is how many tags can be given, leaving room for awsum's own within the 50 a resource can have.
+    MaxTags           = 40
+    maxTagKeyLength   = 128
+    maxTagValueLength = 256
+)
+
+// Version is the version of awsum, tagged on the resources it creates.
+var Version = "dev"
+
+var (
+    ErrInvalidTag     = errors.New("tags must be like key=value, keys of at most 128 and values of at most 256 letters, digits, spaces and _.:/=+-@")
+    ErrDuplicateTag   = errors.New("tag is given more than once")
+    ErrReservedTagKey = errors.New("tag keys starting with aws: or awsum:, and managed-by, are reserved")
+    ErrTooManyTags    = fmt.Errorf("at most %d tags can be given", MaxTags)
+)
+
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// ParseTags parses tags given as key=value.
+func ParseTags(definitions []string) (map[string]string, error) {
+    tags := make(map[string]string)
+
+    for _, definition := range definitions {
+        key, value, ok := strings.Cut(definition, "=")
+
+        if !ok {
+            return nil, fmt.Errorf("%w, got '%s'", ErrInvalidTag, definition)
+        }
+
+        if _, ok = tags[key]; ok {
+            return nil, fmt.Errorf("%w, '%s'", ErrDuplicateTag, key)
+        }
+
+        tags[key] = value
+    }
+
+    if err := ValidateTags(tags); err != nil {
+        return nil, err
+    }
+
+    return tags, nil
+}
+
+// ValidateTags checks the tags can be put on aws resources alongside awsum's own.
+func ValidateTags(tags map[string]string) error {
+    if len(tags) > MaxTags {
+        return ErrTooManyTags
+    }
+
+    for _, key := range slices.Sorted(maps.Keys(tags)) {
+        value := tags[key]
+
+        if len(key) == 0 || utf8.RuneCountInString(key) > maxTagKeyLength || utf8.RuneCountInString(value) > maxTagValueLength ||
+            !tagPattern.MatchString(key) || !tagPattern.MatchString(value) {
+            return fmt.Errorf("%w, got '%s=%s'", ErrInvalidTag, key, value)
+        }
+
+        if key == ManagedByTagKey || strings.HasPrefix(strings.ToLower(key), "aws:") || strings.HasPrefix(key, "awsum:") {
+            return fmt.Errorf("%w, got '%s'", ErrReservedTagKey, key)
+        }
+    }
+
+    return nil
+}
+
+// serviceTags returns the tags of the resources the service owns: who manages them, the full name of the service they
+// belong to (which their names may be cut short of) and the tags given.
+func (opts SetupNewILBServiceOptions) serviceTags() map[string]string {
+    tags := map[string]string{
+        ManagedByTagKey: "awsum",
+        ServiceTagKey:   opts.ServiceName,
+    }
+
+    maps.Copy(tags, opts.Tags)
+
+    return tags
+}
+
+// loadBalancerTags returns the tags of the load balancer the service is on, along with its listeners and security
+// group. A shared load balancer belongs to no service in particular, so it has its own name instead of the service's
+// and none of the tags given.
+func (opts SetupNewILBServiceOptions) loadBalancerTags() map[string]string {
+    if len(opts.SharedLoadBalancer) > 0 {
+        return map[string]string{
+            ManagedByTagKey:          "awsum",
+            SharedLoadBalancerTagKey: opts.SharedLoadBalancer,
+        }
+    }
+
+    return opts.serviceTags()
+}
+
+// callerArn returns the arn of the identity awsum calls aws as, only asking sts the first time.
+func (svc *AwsumILBService) callerArn(ctx context.Context) (string, error) {
+    svc.callerMu.Lock()
+    defer svc.callerMu.Unlock()
+
+    if len(svc.caller) == 0 {
+        caller, err := svc.STS.GetCallerArn(ctx)
+
+        if err != nil {
+            return "", fmt.Errorf("failed to get the caller identity: %w", err)
+        }
+
+        svc.caller = caller
+    }
+
+    return svc.caller, nil
+}
+
+// creationTags returns the tags of a resource being created: the ones given, plus who is creating it with which version
+// of awsum.
+func (svc *AwsumILBService) creationTags(ctx context.Context, tags map[string]string) (map[string]string, error) {
+    caller, err := svc.callerArn(ctx)
+
+    if err != nil {
+        return nil, err
+    }
+
+    created := maps.Clone(tags)
+    created[VersionTagKey] = Version
+    created[CreatedByTagKey] = caller
+
+    return created, nil
+}
+
+func elbv2Tags(tags map[string]string) []types.Tag {
+    var elbv2Tags []types.Tag
+
+    for _, key := range slices.Sorted(maps.Keys(tags)) {
+        elbv2Tags = append(elbv2Tags, types.Tag{Key: memory.Pointer(key), Value: memory.Pointer(tags[key])})
+    }
+
+    return elbv2Tags
+}
+
+func ec2Tags(tags map[string]string) []ec2Types.Tag {
+    var ec2Tags []ec2Types.Tag
+
+    for _, key := range slices.Sorted(maps.Keys(tags)) {
+        ec2Tags = append(ec2Tags, ec2Types.Tag{Key: memory.Pointer(key), Value: memory.Pointer(tags[key])})
+    }
+
+    return ec2Tags
+}
+
+// tagDifferences returns the changes needed for the current tags to include the desired ones, along with the tags to
+// set to make them. Other tags are left alone.
+func tagDifferences(desired map[string]string, current map[string]string) ([]string, map[string]string) {
+    var (
+        changes []string
+        changed = make(map[string]string)
+    )
+
+    for _, key := range slices.Sorted(maps.Keys(desired)) {
+        if value, ok := current[key]; !ok || value != desired[key] {
+            changes = append(changes, fmt.Sprintf("%s: %s -> %s", key, orNone(value), desired[key]))
+            changed[key] = desired[key]
+        }
+    }
+
+    return changes, changed
+}
+
+// planELBTags adds the tags the existing load balancer or target group is missing, like the ones of resources created
+// before awsum tagged them.
+func (svc *AwsumILBService) planELBTags(
+    opts SetupNewILBServiceOptions,
+    plan *ILBServicePlan,
+    resource string,
+    name string,
+    arn string,
+    desired map[string]string,
+) error {
+    current, err := svc.ELBv2.GetTags(opts.Ctx, arn)
+
+    if err != nil {
+        return err
+    }
+
+    if changes, changed := tagDifferences(desired, current[arn]); len(changes) > 0 {
+        plan.add(PlanActionModify, fmt.Sprintf("%s tags", resource), name, func(ctx context.Context, _ *ILBServiceResources) error {
+            _, err := svc.ELBv2.Client().AddTags(ctx, &elbv2.AddTagsInput{
+                ResourceArns: []string{arn},
+                Tags:         elbv2Tags(changed),
+            })
+
+            return err
+        }, changes...)
+    }
+
+    return nil
+}
+
+// planSecurityGroupTags adds the tags the existing security group is missing, like planELBTags.
+func (svc *AwsumILBService) planSecurityGroupTags(
+    plan *ILBServicePlan,
+    name string,
+    securityGroup *ec2Types.SecurityGroup,
+    desired map[string]string,
+) {
+    current := make(map[string]string)
+
+    for _, tag := range securityGroup.Tags {
+        current[memory.Unwrap(tag.Key)] = memory.Unwrap(tag.Value)
+    }
+
+    if changes, changed := tagDifferences(desired, current); len(changes) > 0 {
+        groupId := memory.Unwrap(securityGroup.GroupId)
+
+        plan.add(PlanActionModify, "security group tags", name, func(ctx context.Context, _ *ILBServiceResources) error {
+            _, err := svc.EC2.Client().CreateTags(ctx, &ec2.CreateTagsInput{
+                Resources: []string{groupId},
+                Tags:      ec2Tags(changed),
+            })
+
+            return err
+        }, changes...)
+    }
+}
@@ -0,0 +1,53 @@
+package service_test
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+
+    "github.com/levelshatter/awsum/service"
+    "github.com/stretchr/testify/assert"
+)
+
+func TestParseTags(t *testing.T) {
+    tags, err := service.ParseTags([]string{"team=payments", "cost-center=1234", "note=a=b", "empty="})
+
+    if assert.NoError(t, err) {
+        assert.Equal(t, map[string]string{"team": "payments", "cost-center": "1234", "note": "a=b", "empty": ""}, tags)
+    }
+
+    tags, err = service.ParseTags(nil)
+
+    if assert.NoError(t, err) {
+        assert.Empty(t, tags)
+    }
+
+    tests := []struct {
+        tags []string
+        err  error
+    }{
+        {[]string{"team"}, service.ErrInvalidTag},
+        {[]string{"=payments"}, service.ErrInvalidTag},
+        {[]string{"team=pay;ments"}, service.ErrInvalidTag},
+        {[]string{strings.Repeat("k", 129) + "=v"}, service.ErrInvalidTag},
+        {[]string{"team=payments", "team=billing"}, service.ErrDuplicateTag},
+        {[]string{"managed-by=me"}, service.ErrReservedTagKey},
+        {[]string{"aws:cloudformation:stack-name=web"}, service.ErrReservedTagKey},
+        {[]string{"AWS:team=web"}, service.ErrReservedTagKey},
+        {[]string{"awsum:service=web"}, service.ErrReservedTagKey},
+    }
+
+    for _, test := range tests {
+        _, err = service.ParseTags(test.tags)
+        assert.ErrorIs(t, err, test.err, test.tags)
+    }
+
+    var tooMany []string
+
+    for i := range service.MaxTags + 1 {
+        tooMany = append(tooMany, fmt.Sprintf("tag-%d=%d", i, i))
+    }
+
+    _, err = service.ParseTags(tooMany)
+    assert.ErrorIs(t, err, service.ErrTooManyTags)
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+    "github.com/levelshatter/awsum/internal/memory"
+)
+
+// ServiceDeleteTimeout is how long deleting the load balancer, and then its security group, may each take.
+const ServiceDeleteTimeout = time.Minute * 10
+
+var (
+    ErrServiceNotFound   = errors.New("no resources found for service")
+    ErrDeletionProtected = errors.New("the service's load balancer has deletion protection, turn it off with --deletion-protection=false first")
+)
+
+type DeleteILBServiceOptions struct {
+    Ctx         context.Context
+    ServiceName string
+    // KeepDNS leaves the dns records pointing at the load balancer in place.
+    KeepDNS bool
+}
+
+// PlanILBServiceDeletion works out how to remove every resource awsum created for the service, in dependency order:
+// dns records, listeners, the load balancer, the target group and finally the security groups, the instances' one taken
+// off them first. A service on a shared load balancer only has its own listener rules (and the security group rules
+// letting its traffic out) removed from it.
+func (svc *AwsumILBService) PlanILBServiceDeletion(opts DeleteILBServiceOptions) (*ILBServicePlan, error) {
+    var (
+        err  error
+        name = AwsumILBResourceName(opts.ServiceName)
+        plan = &ILBServicePlan{
+            Options: SetupNewILBServiceOptions{
+                Ctx:         opts.Ctx,
+                ServiceName: opts.ServiceName,
+            },
+        }
+    )
+
+    if err = ValidateServiceName(opts.ServiceName); err != nil {
+        return nil, err
+    }
+
+    if plan.recorded, err = svc.serviceState(opts.Ctx, opts.ServiceName); err != nil {
+        return nil, err
+    }
+
+    // the service is forgotten once every resource is gone, a failure leaves it recorded for the next attempt
+    plan.record = func(ctx context.Context, _ *ILBServiceResources, applyErr error) error {
+        if applyErr != nil {
+            return nil
+        }
+
+        return svc.updateState(ctx, func(state *State) error {
+            delete(state.Services, opts.ServiceName)
+            return nil
+        })
+    }
+
+    loadBalancer, err := svc.findLoadBalancer(opts.Ctx, plan.recorded.loadBalancerArn(""), name)
+
+    if err != nil {
+        return nil, err
+    }
+
+    if loadBalancer != nil {
+        loadBalancerArn := loadBalancer.LoadBalancerArn
+
+        attributes, err := svc.ELBv2.GetLoadBalancerAttributes(opts.Ctx, memory.Unwrap(loadBalancerArn))
+
+        if err != nil {
+            return nil, err
+        }
+
+        // nothing is removed rather than everything but the load balancer
+        if attributes["deletion_protection.enabled"] == "true" {
+            return nil, ErrDeletionProtected
+        }
+
+        if !opts.KeepDNS {
+            aliases, err := svc.Route53.GetAliasRecordsPointingTo(opts.Ctx, memory.Unwrap(loadBalancer.DNSName))
+
+            if err != nil {
+                return nil, err
+            }
+
+            for _, alias := range aliases {
+                plan.add(PlanActionDelete, "dns record", memory.Unwrap(alias.Record.Name), func(ctx context.Context, _ *ILBServiceResources) error {
+                    return svc.Route53.DeleteRecord(ctx, alias.HostedZoneId, alias.Record)
+                }, fmt.Sprintf("%s alias: %s", alias.Record.Type, memory.Unwrap(alias.Record.AliasTarget.DNSName)))
+            }
+        }
+
+        listeners, err := svc.ELBv2.GetAllListenersInLoadBalancer(opts.Ctx, memory.Unwrap(loadBalancerArn))
+
+        if err != nil {
+            return nil, err
+        }
+
+        for _, listener := range listeners {
+            plan.add(PlanActionDelete, "listener", listenerName(name, memory.Unwrap(listener.Port)), func(ctx context.Context, _ *ILBServiceResources) error {
+                _, err := svc.ELBv2.Client().DeleteListener(ctx, &elbv2.DeleteListenerInput{
+                    ListenerArn: listener.ListenerArn,
+                })
+
+                return err
+            }, fmt.Sprintf("protocol: %s", listener.Protocol))
+        }
+
+        plan.add(PlanActionDelete, "load balancer", name, func(ctx context.Context, _ *ILBServiceResources) error {
+            if _, err := svc.ELBv2.Client().DeleteLoadBalancer(ctx, &elbv2.DeleteLoadBalancerInput{
+                LoadBalancerArn: loadBalancerArn,
+            }); err != nil {
+                return err
+            }
+
+            fmt.Println("waiting for the load balancer to be deleted...")
+
+            return elbv2.NewLoadBalancersDeletedWaiter(svc.ELBv2.Client()).Wait(ctx, &elbv2.DescribeLoadBalancersInput{
+                LoadBalancerArns: []string{memory.Unwrap(loadBalancerArn)},
+            }, ServiceDeleteTimeout)
+        }, fmt.Sprintf("dns name: %s", memory.Unwrap(loadBalancer.DNSName)))
+    }
+
+    targetGroup, err := svc.findTargetGroup(opts.Ctx, plan.recorded.TargetGroupArn, name)
+
+    if err != nil {
+        return nil, err
+    }
+
+    if targetGroup != nil {
+        // without a load balancer of its own, the service may be on a shared one
+        if loadBalancer == nil {
+            if err = svc.planSharedServiceDeletion(opts, plan, targetGroup); err != nil {
+                return nil, err
+            }
+        }
+
+        plan.add(PlanActionDelete, "target group", name, func(ctx context.Context, _ *ILBServiceResources) error {
+            _, err := svc.ELBv2.Client().DeleteTargetGroup(ctx, &elbv2.DeleteTargetGroupInput{
+                TargetGroupArn: targetGroup.TargetGroupArn,
+            })
+
+            return err
+        })
+    }
+
+    canaryTargetGroup, err := svc.findTargetGroup(opts.Ctx, plan.recorded.CanaryTargetGroupArn, AwsumILBCanaryResourceName(opts.ServiceName))
+
+    if err != nil {
+        return nil, err
+    }
+
+    if canaryTargetGroup != nil {
+        plan.add(PlanActionDelete, "target group", AwsumILBCanaryResourceName(opts.ServiceName), func(ctx context.Context, _ *ILBServiceResources) error {
+            _, err := svc.ELBv2.Client().DeleteTargetGroup(ctx, &elbv2.DeleteTargetGroupInput{
+                TargetGroupArn: canaryTargetGroup.TargetGroupArn,
+            })
+
+            return err
+        })
+    }
+
+    if err = svc.planInstanceSecurityGroupDeletion(opts, plan); err != nil {
+        return nil, err
+    }
+
+    securityGroup, err := svc.findSecurityGroup(opts.Ctx, plan.recorded.securityGroupId(""), name)
+
+    if err != nil {
+        return nil, err
+    }
+
+    if securityGroup != nil {
+        plan.add(PlanActionDelete, "security group", name, func(ctx context.Context, _ *ILBServiceResources) error {
+            return svc.EC2.DeleteSecurityGroupWhenUnused(ctx, memory.Unwrap(securityGroup.GroupId), ServiceDeleteTimeout)
+        }, memory.Unwrap(securityGroup.GroupId))
+    }
+
+    if !plan.HasChanges() {
+        return nil, fmt.Errorf("%w '%s'", ErrServiceNotFound, opts.ServiceName)
+    }
+
+    return plan, nil
+}
@@ -7,6 +7,7 @@ var (
     DefaultELBv2    *ELBv2
     DefaultACM      *ACM
     DefaultRoute53  *Route53
+    DefaultS3       *S3
     DefaultAwsumILB *AwsumILBService
 )
 
@@ -15,5 +16,6 @@ func Setup(awsConfig aws.Config) {
     DefaultELBv2 = NewELBv2(awsConfig)
     DefaultACM = NewACM(awsConfig)
     DefaultRoute53 = NewRoute53(awsConfig)
+    DefaultS3 = NewS3(awsConfig)
     DefaultAwsumILB = NewAwsumILBService(awsConfig)
 }